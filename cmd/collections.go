@@ -0,0 +1,99 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/rs/zerolog/log"
+
+	"document-rag/internal/config"
+	"document-rag/internal/models"
+	"document-rag/internal/store"
+)
+
+// runCollections handles the collections list|create|drop subcommands
+func runCollections(ctx context.Context, args []string) {
+	if len(args) == 0 {
+		log.Fatal().Msg("Usage: collections list | create -name NAME [-model MODEL] | drop -name NAME")
+	}
+
+	cmd := flag.NewFlagSet("collections "+args[0], flag.ExitOnError)
+	name := cmd.String("name", "", "Collection name")
+	model := cmd.String("model", "", "Embedding model of a new collection (default embed_llm.llm_model)")
+	cmd.Parse(args[1:])
+
+	cfg, err := config.LoadConfig(configFilePath)
+	if err != nil {
+		log.Fatal().Err(err).Msg("Error loading config")
+	}
+
+	backend, err := store.OpenBackend(ctx, cfg)
+	if err != nil {
+		log.Fatal().Err(err).Msg("Error opening vector store")
+	}
+	defer backend.Close()
+
+	switch args[0] {
+	case "list":
+		listCollections(ctx, backend)
+	case "create":
+		if *name == "" {
+			log.Fatal().Msg("Please provide the collection name using the -name flag")
+		}
+		createCollection(ctx, cfg, backend, *name, *model)
+	case "drop":
+		if *name == "" {
+			log.Fatal().Msg("Please provide the collection name using the -name flag")
+		}
+		if err := backend.DropCollection(ctx, *name); err != nil {
+			log.Fatal().Err(err).Msg("Error dropping collection")
+		}
+		log.Info().Msgf("Dropped collection %s", *name)
+	default:
+		log.Fatal().Msgf("Unknown collections command %q", args[0])
+	}
+}
+
+func listCollections(ctx context.Context, backend store.Backend) {
+	collections, err := backend.Collections(ctx)
+	if err != nil {
+		log.Fatal().Err(err).Msg("Error listing collections")
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "NAME\tMODEL\tDIMENSION\tCHUNKS\tCREATED")
+	for _, c := range collections {
+		created := "-"
+		if !c.CreatedAt.IsZero() {
+			created = c.CreatedAt.Local().Format(time.DateTime)
+		}
+		fmt.Fprintf(w, "%s\t%s\t%d\t%d\t%s\n", c.Name, c.EmbeddingModel, c.Dimension, c.Chunks, created)
+	}
+	w.Flush()
+}
+
+func createCollection(ctx context.Context, cfg *config.Config, backend store.Backend, name, model string) {
+	_, err := backend.Describe(ctx, name)
+	if err == nil {
+		log.Fatal().Msgf("Collection %s already exists", name)
+	}
+	if !errors.Is(err, models.ErrCollectionNotFound) {
+		log.Fatal().Err(err).Msg("Error reading collection")
+	}
+
+	embedCfg := cfg.EmbedLLM
+	if model != "" {
+		embedCfg.Model = model
+	}
+	vectorStore, _, err := store.OpenCollection(ctx, backend, name, embedCfg, false)
+	if err != nil {
+		log.Fatal().Err(err).Msg("Error creating collection")
+	}
+	info := vectorStore.Info()
+	log.Info().Msgf("Created collection %s for %s (%d dimensions)", info.Name, info.EmbeddingModel, info.Dimension)
+}
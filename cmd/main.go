@@ -5,24 +5,29 @@ import (
 	"flag"
 	"fmt"
 	"os"
+	"os/signal"
+	"strings"
 	"time"
 
-	"github.com/philippgille/chromem-go"
 	"github.com/rs/zerolog"
 	"github.com/rs/zerolog/log"
 
-	"document-rag/internal/chromemdb"
 	"document-rag/internal/config"
-	"document-rag/internal/db"
-	"document-rag/internal/embedding"
+	"document-rag/internal/filter"
 	"document-rag/internal/helper"
+	"document-rag/internal/ingest"
+	"document-rag/internal/models"
 	"document-rag/internal/parser"
 	"document-rag/internal/rag"
+	"document-rag/internal/store"
 )
 
 const (
 	configFilePath = "./configs/config.yaml"
-	vectorSize     = 768
+
+	// formats of the -file flag
+	formatBG       = "bg"
+	formatDocument = "document"
 )
 
 func main() {
@@ -33,11 +38,35 @@ func main() {
 	filePath := flag.String("file", "", "Path to the document file")
 	query := flag.String("query", "", "Query to be answered")
 	dryRun := flag.Bool("dry-run", false, "Dry run, do not save to database")
+	filterExpr := flag.String("filter", "", "Restrict the query to matching chunks, e.g. 'chapter=II AND speaker=Krishna'")
+	reset := flag.Bool("reset", false, "Drop the collection before ingesting, required when its embedding model changes")
+	mode := flag.String("mode", "", "Search mode of the query: vector, text or hybrid (default from config)")
+	collection := flag.String("collection", "", "Collection to ingest into or query, comma-separated to query several (default from config)")
+	session := flag.String("session", "", "Chat session to continue with the query, \"new\" starts one and prints its ID")
+	promptName := flag.String("prompt", "", "Prompt template of the query, see the prompts command (default from config)")
+	stream := flag.Bool("stream", true, "Print the answer of a query as it is generated")
+	format := flag.String("format", formatBG, "Format of the -file: bg for BG text, document for PDF, office and text files")
 	flag.Parse()
 
-	// TODO: parse bg file and print the result
-	if *filePath != "" {
-		parseBGText(context.Background(), *filePath, *dryRun)
+	if flag.NArg() > 0 {
+		switch flag.Arg(0) {
+		case "collections":
+			runCollections(context.Background(), flag.Args()[1:])
+		case "migrate":
+			runMigrate(context.Background(), flag.Args()[1:])
+		case "sources":
+			runSources(context.Background(), flag.Args()[1:])
+		case "snapshot":
+			runSnapshot(context.Background(), flag.Args()[1:])
+		case "sessions":
+			runSessions(context.Background(), flag.Args()[1:])
+		case "prompts":
+			runPrompts()
+		case "serve":
+			runServe(context.Background(), flag.Args()[1:])
+		default:
+			log.Fatal().Msgf("Unknown command %q", flag.Arg(0))
+		}
 		return
 	}
 
@@ -45,15 +74,22 @@ func main() {
 		log.Fatal().Msg("Please provide either a document file using the -file flag or a query using the -query flag, but not both")
 	}
 
-	if *query != "" {
-		searchBGContent(context.Background(), *query)
+	if *filePath != "" {
+		switch *format {
+		case formatBG:
+			parseBGText(context.Background(), *filePath, *collection, *dryRun, *reset)
+		case formatDocument:
+			storeFileEmbedding(context.Background(), *filePath, *collection, *dryRun, *reset)
+		default:
+			log.Fatal().Msgf("Unsupported format %q, use %s or %s", *format, formatBG, formatDocument)
+		}
 		return
 	}
 
-	// if *filePath != "" {
-	// 	storeFileEmbedding(context.Background(), *filePath)
-	// 	return
-	// }
+	if *query != "" {
+		performRAG(context.Background(), *query, *collection, *filterExpr, *mode, *session, *promptName, *stream)
+		return
+	}
 
 	// if *query != "" {
 	// 	performRAG(context.Background(), *query)
@@ -63,7 +99,9 @@ func main() {
 	// log.Fatal().Msg("Please provide either a document file using the -file flag or a query using the -query flag")
 }
 
-func storeFileEmbedding(ctx context.Context, filePath string) {
+// storeFileEmbedding ingests a PDF, office or text file, or prints its chunks
+// with dryRun
+func storeFileEmbedding(ctx context.Context, filePath, collection string, dryRun, reset bool) {
 	cfg, err := config.LoadConfig(configFilePath)
 	if err != nil {
 		log.Fatal().Err(err).Msg("Error loading config")
@@ -71,80 +109,112 @@ func storeFileEmbedding(ctx context.Context, filePath string) {
 
 	log.Debug().Interface("config", cfg).Msg("Loaded config")
 
-	dbClient, err := db.ConnectDB(&cfg.Database)
-	if err != nil {
-		log.Fatal().Err(err).Msg("Error connecting to database")
-	}
-	dbInstance := db.NewDB(dbClient, cfg.Database.Debug)
-	defer dbInstance.Close()
-
-	if err := db.DropDocuments(ctx, dbInstance); err != nil {
-		log.Fatal().Err(err).Msg("Error clearing documents")
-	}
-
-	if err := db.InitDB(ctx, dbInstance, vectorSize); err != nil {
-		log.Fatal().Err(err).Msg("Error initializing database")
+	if dryRun {
+		src, err := ingest.DocumentSource(cfg, filePath)
+		if err != nil {
+			log.Fatal().Err(err).Msg("Error reading document")
+		}
+		chunks, err := src.Load()
+		if err != nil {
+			log.Fatal().Err(err).Msg("Error parsing document")
+		}
+		log.Info().Msg("Parsed content")
+		helper.PrettyPrint(chunks)
+		return
 	}
 
-	embedder, err := embedding.NewOllamaEmbedder(&cfg.EmbedLLM)
+	backend, err := store.OpenBackend(ctx, cfg)
 	if err != nil {
-		log.Fatal().Err(err).Msg("Error initializing embedder")
+		log.Fatal().Err(err).Msg("Error opening vector store")
 	}
+	defer backend.Close()
 
-	chunks, err := parser.ParseToMarkdown(filePath, cfg)
+	vectorStore, embedder, err := store.OpenCollection(ctx, backend, collectionOrDefault(cfg, collection), cfg.EmbedLLM, reset)
 	if err != nil {
-		log.Error().Err(err).Msg("Error parsing document")
-		return
+		log.Fatal().Err(err).Msg("Error opening collection")
 	}
 
-	chunkEmbeddings, err := embedding.GenerateEmbedding(ctx, embedder, filePath, chunks)
+	src, err := ingest.DocumentSource(cfg, filePath)
 	if err != nil {
-		log.Fatal().Err(err).Msg("Error generating embedding")
-	}
-
-	// Convert chunk embeddings to Document records for batch storage
-	docs := make([]db.Document, len(chunkEmbeddings))
-	for i, ce := range chunkEmbeddings {
-		docs[i] = db.Document{
-			Content:        ce.Content,
-			Embedding:      ce.Embedding,
-			SourceFilename: ce.SourceFilename,
-			PageNumber:     ce.PageNumber,
-			ChunkID:        ce.ChunkID,
-		}
+		log.Fatal().Err(err).Msg("Error reading document")
 	}
 
-	if err := db.StoreDocuments(ctx, dbInstance, docs); err != nil {
+	_, err = ingest.Ingest(ctx, vectorStore, embedder, src)
+	if err != nil {
 		log.Fatal().Err(err).Msg("Error storing document")
 	}
+
+	exportInMemory(ctx, cfg, vectorStore)
 }
 
-func performRAG(ctx context.Context, query string) {
+func performRAG(ctx context.Context, query, collections, filterExpr, mode, sessionID, promptName string, stream bool) {
+	// Ctrl-C stops the generation instead of killing the process mid-write
+	ctx, stop := signal.NotifyContext(ctx, os.Interrupt)
+	defer stop()
+
 	cfg, err := config.LoadConfig(configFilePath)
 	if err != nil {
 		log.Fatal().Err(err).Msg("Error loading config")
 	}
 
+	where, err := filter.Parse(filterExpr)
+	if err != nil {
+		log.Fatal().Err(err).Msg("Error parsing filter")
+	}
+
+	searchMode := models.SearchMode("")
+	if mode != "" {
+		if searchMode, err = models.ParseSearchMode(mode); err != nil {
+			log.Fatal().Err(err).Msg("Error parsing search mode")
+		}
+	}
+
 	log.Debug().Interface("config", cfg).Msg("Loaded config")
 
-	dbClient, err := db.ConnectDB(&cfg.Database)
+	backend, err := store.OpenBackend(ctx, cfg)
 	if err != nil {
-		log.Fatal().Err(err).Msg("Error connecting to database")
+		log.Fatal().Err(err).Msg("Error opening vector store")
 	}
-	dbInstance := db.NewDB(dbClient, cfg.Database.Debug)
-	defer dbInstance.Close()
+	defer backend.Close()
 
-	embedder, err := embedding.NewOllamaEmbedder(&cfg.EmbedLLM)
+	targets, err := rag.OpenCollections(ctx, backend, strings.Split(collectionOrDefault(cfg, collections), ","), cfg.EmbedLLM)
 	if err != nil {
-		log.Fatal().Err(err).Msg("Error initializing embedder")
+		log.Fatal().Err(err).Msg("Error opening collection")
 	}
 
-	rag := rag.NewRAG(dbInstance, nil, embedder, cfg)
-	response, err := rag.Query(ctx, query)
+	ragInstance, err := rag.NewRAG(targets, cfg)
+	if err != nil {
+		log.Fatal().Err(err).Msg("Error creating RAG")
+	}
+	opts := rag.QueryOptions{Filter: where, Mode: searchMode, Prompt: promptName}
+	if stream {
+		log.Info().Msg("Query: ~~~~~~~~~~~~~~~~~~~~~~~~~>>>>>")
+		fmt.Printf("%s\n\n", query)
+		log.Info().Msg("Assistant: ~~~~~~~~~~~~~~~~~~~~~~~~~>>>>>")
+		opts.Stream = printStream
+	}
+
+	var response models.PromptResponse
+	if sessionID == "" {
+		response, err = ragInstance.QueryWithOptions(ctx, query, opts)
+	} else {
+		response, err = chatQuery(ctx, cfg, ragInstance, sessionID, query, opts)
+	}
+	if ctx.Err() != nil {
+		fmt.Println()
+		log.Warn().Msg("Generation cancelled")
+		return
+	}
 	if err != nil {
 		log.Fatal().Err(err).Msg("Error querying")
 	}
 
+	if stream {
+		log.Info().Msg("Source: ~~~~~~~~~~~~~~~~~~~~~~~~~>>>>>")
+		fmt.Printf("%s\n\n", response.Source)
+		return
+	}
+
 	log.Info().Msg("Query: ~~~~~~~~~~~~~~~~~~~~~~~~~>>>>>")
 	fmt.Printf("%s\n\n", query)
 
@@ -153,141 +223,94 @@ func performRAG(ctx context.Context, query string) {
 
 	log.Info().Msg("Assistant: ~~~~~~~~~~~~~~~~~~~~~~~~~>>>>>")
 
-	fmt.Printf("%s\n\n", response.Content)
+	fmt.Printf("%s\n", response.Content)
+	printCitations(response)
+}
 
+// printStream prints the tokens of an answer as they arrive, then its
+// citations
+func printStream(ctx context.Context, event models.StreamEvent) error {
+	if !event.Done {
+		fmt.Print(event.Token)
+		return nil
+	}
+	fmt.Println()
+	printCitations(*event.Response)
+	return nil
 }
 
-const (
-	dbPath         = "./chromemdb"
-	collectionName = "bg_collection"
-	inMemory       = false
-)
+func printCitations(response models.PromptResponse) {
+	if len(response.Results) == 0 {
+		fmt.Println()
+		return
+	}
+	fmt.Print("\nReferences:\n")
+	if len(response.Citations) == 0 {
+		fmt.Println("(the answer cites no passage)")
+	}
+	for _, c := range response.Citations {
+		fmt.Println(c)
+	}
+	if len(response.UnknownCitations) > 0 {
+		fmt.Printf("Cited passages not in the context: %v\n", response.UnknownCitations)
+	}
+	fmt.Println()
+}
+
+// collectionOrDefault returns the -collection flag, or the configured default
+func collectionOrDefault(cfg *config.Config, collection string) string {
+	if collection != "" {
+		return collection
+	}
+	return store.DefaultCollection(cfg)
+}
 
-func parseBGText(ctx context.Context, filePath string, dryRun bool) {
+func parseBGText(ctx context.Context, filePath, collection string, dryRun, reset bool) {
 	// load config
 	cfg, err := config.LoadConfig(configFilePath)
 	if err != nil {
 		log.Fatal().Err(err).Msg("Error loading config")
 	}
 
-	// create folder
-	err = helper.CreateFolder(dbPath)
-	if err != nil {
-		log.Fatal().Err(err).Msg("Error creating folder")
-	}
-
 	log.Debug().Interface("config", cfg).Msg("Loaded config")
 
-	// parse content
-	content := parser.ParseBGText(filePath, cfg)
-	log.Info().Msg("Parsed content")
-	helper.PrettyPrint(content)
-
-	// add context
-	// content = parser.AddContextByChapter(content, cfg)
-	// log.Info().Msg("Content with context")
-	// helper.PrettyPrint(content)
-
 	if dryRun {
+		// parse content
+		content := parser.ParseBGText(filePath, cfg)
+		log.Info().Msg("Parsed content")
+		helper.PrettyPrint(content)
 		return
 	}
-	// embed content
-	embedder, err := embedding.NewOllamaEmbedder(&cfg.EmbedLLM)
-	if err != nil {
-		log.Fatal().Err(err).Msg("Error initializing embedder")
-	}
 
-	var docs []chromem.Document
-	for _, section := range content {
-		// if content is empty, skip
-		if section.Content == "" {
-			continue
-		}
-		embedding, err := embedder.EmbedQuery(ctx, section.Content)
-		if err != nil {
-			log.Fatal().Err(err).Msg("Error generating embedding")
-		}
-		docs = append(docs, chromem.Document{
-			ID:        fmt.Sprintf("%s-%s-%d", section.Chapter, section.Speaker, section.ChunkID),
-			Content:   section.Content,
-			Metadata:  parser.CreateMetadata(section),
-			Embedding: embedding,
-		})
+	backend, err := store.OpenBackend(ctx, cfg)
+	if err != nil {
+		log.Fatal().Err(err).Msg("Error opening vector store")
 	}
+	defer backend.Close()
 
-	// store content in database
-	// create chromemdb
-	db, err := chromemdb.NewVectorDBManager(dbPath, collectionName, inMemory, cfg.RAG.EncryptionKey)
+	// open the collection first so a dimension mismatch fails before parsing
+	vectorStore, embedder, err := store.OpenCollection(ctx, backend, collectionOrDefault(cfg, collection), cfg.EmbedLLM, reset)
 	if err != nil {
-		log.Fatal().Err(err).Msg("Error creating vector database manager")
+		log.Fatal().Err(err).Msg("Error opening collection")
 	}
 
-	// create collection
-	_, err = db.GetOrCreateCollection(collectionName)
+	src, err := ingest.BGSource(cfg, filePath)
 	if err != nil {
-		log.Fatal().Err(err).Msg("Error creating collection")
+		log.Fatal().Err(err).Msg("Error reading document")
 	}
 
-	// delete collection first
-	// err = db.DeleteCollection()
-	// if err != nil {
-	// 	log.Fatal().Err(err).Msg("Error deleting collection")
-	// }
-
-	log.Info().Msgf("Adding %d documents to vector database", len(docs))
-
-	// add content to chromemdb
-	err = db.CreateDocs(docs)
+	_, err = ingest.Ingest(ctx, vectorStore, embedder, src)
 	if err != nil {
 		log.Fatal().Err(err).Msg("Error adding content to vector database")
 	}
 
-	if inMemory {
-		// export collection
-		err = db.Export(ctx)
-		if err != nil {
-			log.Fatal().Err(err).Msg("Error exporting collection")
-		}
-	}
+	exportInMemory(ctx, cfg, vectorStore)
 }
 
-// search bg content
-func searchBGContent(ctx context.Context, query string) error {
-	cfg, err := config.LoadConfig(configFilePath)
-	if err != nil {
-		log.Fatal().Err(err).Msg("Error loading config")
-	}
-	// create chromemdb
-	db, err := chromemdb.NewVectorDBManager(dbPath, collectionName, inMemory, cfg.RAG.EncryptionKey)
-	if err != nil {
-		log.Fatal().Err(err).Msg("Error creating vector database manager")
-	}
-
-	// create collection
-	_, err = db.GetOrCreateCollection(collectionName)
-	if err != nil {
-		log.Fatal().Err(err).Msg("Error creating collection")
-	}
-
-	embedder, err := embedding.NewOllamaEmbedder(&cfg.EmbedLLM)
-	if err != nil {
-		log.Fatal().Err(err).Msg("Error initializing embedder")
-	}
-
-	rag := rag.NewRAG(nil, db, embedder, cfg)
-	response, err := rag.Query(ctx, query)
-	if err != nil {
-		log.Fatal().Err(err).Msg("Error querying")
+// exportInMemory saves the snapshot of an in-memory collection, which is
+// lost when the process exits otherwise
+func exportInMemory(ctx context.Context, cfg *config.Config, vectorStore store.VectorStore) {
+	if err := store.Persist(ctx, cfg, vectorStore); err != nil {
+		log.Fatal().Err(err).Msg("Error exporting collection")
 	}
-
-	log.Info().Msg("Query: ~~~~~~~~~~~~~~~~~~~~~~~~~>>>>>")
-	fmt.Printf("%s\n\n", query)
-
-	log.Info().Msg("Source: ~~~~~~~~~~~~~~~~~~~~~~~~~>>>>>")
-	fmt.Printf("%s\n\n", response.Source)
-
-	log.Info().Msg("Assistant: ~~~~~~~~~~~~~~~~~~~~~~~~~>>>>>")
-	fmt.Printf("%s\n\n", response.Content)
-
-	return nil
 }
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/rs/zerolog/log"
+
+	"document-rag/internal/config"
+	"document-rag/internal/db"
+)
+
+// runMigrate handles the migrate up|down|status subcommands against the
+// Postgres database of the config
+func runMigrate(ctx context.Context, args []string) {
+	action := "up"
+	if len(args) > 0 {
+		action = args[0]
+	}
+
+	cfg, err := config.LoadConfig(configFilePath)
+	if err != nil {
+		log.Fatal().Err(err).Msg("Error loading config")
+	}
+
+	dbClient, err := db.ConnectDB(ctx, &cfg.Database)
+	if err != nil {
+		log.Fatal().Err(err).Msg("Error connecting to database")
+	}
+	dbInstance := db.NewDB(dbClient, cfg.Database.Debug)
+	defer dbInstance.Close()
+
+	switch action {
+	case "up":
+		group, err := db.Migrate(ctx, dbInstance)
+		if err != nil {
+			log.Fatal().Err(err).Msg("Error migrating")
+		}
+		if group.IsZero() {
+			log.Info().Msg("Schema is up to date")
+			return
+		}
+		log.Info().Msgf("Migrated to %s", group)
+	case "down":
+		group, err := db.Rollback(ctx, dbInstance)
+		if err != nil {
+			log.Fatal().Err(err).Msg("Error rolling back")
+		}
+		if group.IsZero() {
+			log.Info().Msg("No migrations to roll back")
+			return
+		}
+		log.Info().Msgf("Rolled back %s", group)
+	case "status":
+		migrations, err := db.MigrationStatus(ctx, dbInstance)
+		if err != nil {
+			log.Fatal().Err(err).Msg("Error reading migrations")
+		}
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "MIGRATION\tGROUP\tMIGRATED")
+		for _, m := range migrations {
+			migrated := "pending"
+			if m.IsApplied() {
+				migrated = m.MigratedAt.Local().Format(time.DateTime)
+			}
+			fmt.Fprintf(w, "%s\t%d\t%s\n", m.String(), m.GroupID, migrated)
+		}
+		w.Flush()
+	default:
+		log.Fatal().Msgf("Unknown migrate command %q, use up, down or status", action)
+	}
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/rs/zerolog/log"
+
+	"document-rag/internal/config"
+	"document-rag/internal/prompt"
+)
+
+// runPrompts validates the prompt templates and lists them with the
+// collections that use them
+func runPrompts() {
+	cfg, err := config.LoadConfig(configFilePath)
+	if err != nil {
+		log.Fatal().Err(err).Msg("Error loading config")
+	}
+
+	prompts, err := prompt.Load(&cfg.Prompts)
+	if err != nil {
+		log.Fatal().Err(err).Msg("Error loading prompt templates")
+	}
+	defaultTemplate, _ := prompts.Get("")
+
+	usedBy := map[string][]string{}
+	for collection, name := range cfg.Prompts.Collections {
+		usedBy[name] = append(usedBy[name], collection)
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "TEMPLATE\tDEFAULT\tCOLLECTIONS")
+	for _, name := range prompts.Names() {
+		isDefault := ""
+		if name == defaultTemplate.Name {
+			isDefault = "yes"
+		}
+		collections := usedBy[name]
+		sort.Strings(collections)
+		fmt.Fprintf(w, "%s\t%s\t%s\n", name, orDash(isDefault), orDash(strings.Join(collections, ",")))
+	}
+	w.Flush()
+}
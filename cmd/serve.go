@@ -0,0 +1,71 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/rs/zerolog/log"
+
+	"document-rag/internal/config"
+	"document-rag/internal/prompt"
+	"document-rag/internal/server"
+)
+
+const shutdownTimeout = 15 * time.Second
+
+// runServe runs the HTTP API until interrupted
+func runServe(ctx context.Context, args []string) {
+	cmd := flag.NewFlagSet("serve", flag.ExitOnError)
+	addr := cmd.String("addr", "", "Listen address (default from config, :8080)")
+	cmd.Parse(args)
+
+	cfg, err := config.LoadConfig(configFilePath)
+	if err != nil {
+		log.Fatal().Err(err).Msg("Error loading config")
+	}
+	if *addr != "" {
+		cfg.Server.Addr = *addr
+	}
+
+	// a broken template fails here rather than on every query
+	prompts, err := prompt.Load(&cfg.Prompts)
+	if err != nil {
+		log.Fatal().Err(err).Msg("Error loading prompt templates")
+	}
+	log.Info().Strs("templates", prompts.Names()).Msg("Loaded prompt templates")
+
+	srv, err := server.New(ctx, cfg, prompts)
+	if err != nil {
+		log.Fatal().Err(err).Msg("Error starting server")
+	}
+	defer srv.Close()
+
+	httpServer := &http.Server{
+		Addr:              srv.Addr(),
+		Handler:           srv.Handler(),
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
+	ctx, stop := signal.NotifyContext(ctx, os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+		defer cancel()
+		if err := httpServer.Shutdown(shutdownCtx); err != nil {
+			log.Warn().Err(err).Msg("Error shutting down server")
+		}
+	}()
+
+	log.Info().Msgf("Serving on %s", httpServer.Addr)
+	if err := httpServer.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		log.Fatal().Err(err).Msg("Error serving")
+	}
+	log.Info().Msg("Server stopped")
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/rs/zerolog/log"
+
+	"document-rag/internal/chat"
+	"document-rag/internal/config"
+	"document-rag/internal/models"
+	"document-rag/internal/rag"
+)
+
+// chatQuery answers query as the next turn of a chat session and saves it,
+// a session ID of "new" starts a session with a generated ID
+func chatQuery(ctx context.Context, cfg *config.Config, ragInstance *rag.RAG, sessionID, query string, opts rag.QueryOptions) (models.PromptResponse, error) {
+	sessions, err := chat.OpenStore(ctx, cfg)
+	if err != nil {
+		return models.PromptResponse{}, err
+	}
+	defer sessions.Close()
+
+	if sessionID == "new" {
+		sessionID = chat.NewID()
+		log.Info().Msgf("Started chat session %s", sessionID)
+	}
+	session, err := chat.LoadOrCreate(ctx, sessions, sessionID)
+	if err != nil {
+		return models.PromptResponse{}, fmt.Errorf("failed to load chat session: %w", err)
+	}
+
+	response, err := ragInstance.Chat(ctx, session, query, opts)
+	if err != nil {
+		return response, err
+	}
+	if err := sessions.Save(ctx, session); err != nil {
+		return response, fmt.Errorf("failed to save chat session: %w", err)
+	}
+	return response, nil
+}
+
+// runSessions handles the sessions list|show|delete subcommands
+func runSessions(ctx context.Context, args []string) {
+	if len(args) == 0 {
+		log.Fatal().Msg("Usage: sessions list | show -id ID | delete -id ID")
+	}
+
+	cmd := flag.NewFlagSet("sessions "+args[0], flag.ExitOnError)
+	id := cmd.String("id", "", "Chat session ID")
+	cmd.Parse(args[1:])
+
+	cfg, err := config.LoadConfig(configFilePath)
+	if err != nil {
+		log.Fatal().Err(err).Msg("Error loading config")
+	}
+
+	sessions, err := chat.OpenStore(ctx, cfg)
+	if err != nil {
+		log.Fatal().Err(err).Msg("Error opening session store")
+	}
+	defer sessions.Close()
+
+	if args[0] != "list" && *id == "" {
+		log.Fatal().Msg("Please provide the session using the -id flag")
+	}
+
+	switch args[0] {
+	case "list":
+		listSessions(ctx, sessions)
+	case "show":
+		session, err := sessions.Load(ctx, *id)
+		if err != nil {
+			log.Fatal().Err(err).Msgf("Error loading session %s", *id)
+		}
+		showSession(session)
+	case "delete":
+		if err := sessions.Delete(ctx, *id); err != nil {
+			log.Fatal().Err(err).Msgf("Error deleting session %s", *id)
+		}
+		log.Info().Msgf("Deleted session %s", *id)
+	default:
+		log.Fatal().Msgf("Unknown sessions command %q", args[0])
+	}
+}
+
+func listSessions(ctx context.Context, sessions chat.SessionStore) {
+	list, err := sessions.List(ctx)
+	if err != nil {
+		log.Fatal().Err(err).Msg("Error listing sessions")
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tTURNS\tCREATED\tUPDATED\tFIRST QUESTION")
+	for _, s := range list {
+		first := "-"
+		if len(s.Turns) > 0 {
+			first = s.Turns[0].Question
+			if len(first) > 60 {
+				first = first[:57] + "..."
+			}
+		}
+		fmt.Fprintf(w, "%s\t%d\t%s\t%s\t%s\n",
+			s.ID, len(s.Turns), s.CreatedAt.Local().Format(time.DateTime), s.UpdatedAt.Local().Format(time.DateTime), first)
+	}
+	w.Flush()
+}
+
+func showSession(session *models.ChatSession) {
+	if session.Summary != "" {
+		fmt.Printf("== Summary of turns 1-%d\n%s\n\n", session.Summarized, strings.TrimSpace(session.Summary))
+	}
+	for i, turn := range session.Turns {
+		fmt.Printf("== Turn %d (%s)\n", i+1, turn.At.Local().Format(time.DateTime))
+		fmt.Printf("User: %s\n", turn.Question)
+		if turn.Query != "" {
+			fmt.Printf("Searched: %s\n", turn.Query)
+		}
+		fmt.Printf("Assistant: %s\n\n", strings.TrimSpace(turn.Answer))
+	}
+}
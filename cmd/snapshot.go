@@ -0,0 +1,84 @@
+package main
+
+import (
+	"context"
+	"flag"
+
+	"github.com/rs/zerolog/log"
+
+	"document-rag/internal/chromemdb"
+	"document-rag/internal/config"
+	"document-rag/internal/store"
+)
+
+// snapshotter is implemented by backends that can save collections to
+// snapshot files
+type snapshotter interface {
+	SnapshotPath(name string) string
+	CreateSnapshot(name, path string) (*chromemdb.SnapshotManifest, error)
+	RestoreSnapshot(path string) (*chromemdb.SnapshotManifest, error)
+}
+
+// runSnapshot handles the snapshot create|restore subcommands of the chromem
+// backend
+func runSnapshot(ctx context.Context, args []string) {
+	if len(args) == 0 {
+		log.Fatal().Msg("Usage: snapshot create [-name NAME] [-file PATH] | restore [-name NAME] [-file PATH]")
+	}
+
+	cmd := flag.NewFlagSet("snapshot "+args[0], flag.ExitOnError)
+	name := cmd.String("name", "", "Collection name (default from config)")
+	file := cmd.String("file", "", "Snapshot file (default <snapshot dir>/<name>.chromem)")
+	cmd.Parse(args[1:])
+
+	cfg, err := config.LoadConfig(configFilePath)
+	if err != nil {
+		log.Fatal().Err(err).Msg("Error loading config")
+	}
+
+	backend, err := store.OpenBackend(ctx, cfg)
+	if err != nil {
+		log.Fatal().Err(err).Msg("Error opening vector store")
+	}
+	defer backend.Close()
+
+	snapshots, ok := backend.(snapshotter)
+	if !ok {
+		log.Fatal().Msgf("The %s backend does not support snapshots", cfg.VectorStore.Backend)
+	}
+
+	collection := collectionOrDefault(cfg, *name)
+	path := *file
+	if path == "" {
+		path = snapshots.SnapshotPath(collection)
+	}
+
+	switch args[0] {
+	case "create":
+		manifest, err := snapshots.CreateSnapshot(collection, path)
+		if err != nil {
+			log.Fatal().Err(err).Msg("Error creating snapshot")
+		}
+		log.Info().Msgf("Saved %d chunks of %s to %s", manifest.Documents, manifest.Collection, path)
+	case "restore":
+		if *name != "" {
+			manifest, err := chromemdb.ReadSnapshotManifest(path)
+			if err != nil {
+				log.Fatal().Err(err).Msg("Error reading snapshot")
+			}
+			if manifest.Collection != collection {
+				log.Fatal().Msgf("Snapshot %s holds collection %s, not %s", path, manifest.Collection, collection)
+			}
+		}
+		manifest, err := snapshots.RestoreSnapshot(path)
+		if err != nil {
+			log.Fatal().Err(err).Msg("Error restoring snapshot")
+		}
+		if cfg.VectorStore.InMemory {
+			log.Warn().Msg("The database is in memory, set vector_store.snapshot.restore to load snapshots at startup")
+		}
+		log.Info().Msgf("Restored %d chunks of %s from %s", manifest.Documents, manifest.Collection, path)
+	default:
+		log.Fatal().Msgf("Unknown snapshot command %q", args[0])
+	}
+}
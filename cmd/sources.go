@@ -0,0 +1,170 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/rs/zerolog/log"
+
+	"document-rag/internal/config"
+	"document-rag/internal/ingest"
+	"document-rag/internal/store"
+)
+
+// runSources handles the sources list|show|delete|reingest subcommands
+func runSources(ctx context.Context, args []string) {
+	if len(args) == 0 {
+		log.Fatal().Msg("Usage: sources list | show -source FILE|-id ID | delete -source FILE|-id ID | reingest -source FILE [-collection NAME]")
+	}
+
+	cmd := flag.NewFlagSet("sources "+args[0], flag.ExitOnError)
+	collection := cmd.String("collection", "", "Collection of the sources (default from config)")
+	source := cmd.String("source", "", "Source file name as it was ingested")
+	sourceID := cmd.Int64("id", 0, "Source ID as listed, instead of -source (pgvector only)")
+	cmd.Parse(args[1:])
+
+	cfg, err := config.LoadConfig(configFilePath)
+	if err != nil {
+		log.Fatal().Err(err).Msg("Error loading config")
+	}
+
+	backend, err := store.OpenBackend(ctx, cfg)
+	if err != nil {
+		log.Fatal().Err(err).Msg("Error opening vector store")
+	}
+	defer backend.Close()
+
+	name := collectionOrDefault(cfg, *collection)
+	if args[0] != "list" && *source == "" && *sourceID == 0 {
+		log.Fatal().Msg("Please provide the source file using the -source flag")
+	}
+
+	switch args[0] {
+	case "list":
+		listSources(ctx, openExisting(ctx, backend, name))
+	case "show":
+		vectorStore := openExisting(ctx, backend, name)
+		showChunks(ctx, vectorStore, sourceName(ctx, vectorStore, *source, *sourceID))
+	case "delete":
+		vectorStore := openExisting(ctx, backend, name)
+		*source = sourceName(ctx, vectorStore, *source, *sourceID)
+		n, err := vectorStore.DeleteSource(ctx, *source)
+		if err != nil {
+			log.Fatal().Err(err).Msg("Error deleting source")
+		}
+		if n == 0 {
+			log.Fatal().Msgf("Source %s has no chunks in %s", *source, name)
+		}
+		exportInMemory(ctx, cfg, vectorStore)
+		log.Info().Msgf("Deleted %d chunks of %s from %s", n, *source, name)
+	case "reingest":
+		vectorStore, embedder, err := store.OpenCollection(ctx, backend, name, cfg.EmbedLLM, false)
+		if err != nil {
+			log.Fatal().Err(err).Msg("Error opening collection")
+		}
+		src, err := ingest.BGSource(cfg, sourceName(ctx, vectorStore, *source, *sourceID))
+		if err != nil {
+			log.Fatal().Err(err).Msg("Error reading document")
+		}
+		if _, err := ingest.Reingest(ctx, vectorStore, embedder, src); err != nil {
+			log.Fatal().Err(err).Msg("Error re-ingesting source")
+		}
+		exportInMemory(ctx, cfg, vectorStore)
+	default:
+		log.Fatal().Msgf("Unknown sources command %q", args[0])
+	}
+}
+
+// openExisting opens a collection for reading and deleting, with the model
+// and dimension it was created with so the embedding server is not needed
+func openExisting(ctx context.Context, backend store.Backend, name string) store.VectorStore {
+	info, err := backend.Describe(ctx, name)
+	if err != nil {
+		log.Fatal().Err(err).Msg("Error opening collection")
+	}
+	vectorStore, err := backend.Collection(ctx, name, info.EmbeddingModel, info.Dimension)
+	if err != nil {
+		log.Fatal().Err(err).Msg("Error opening collection")
+	}
+	return vectorStore
+}
+
+// sourceName returns the source given by -source, or looks up the one with
+// the -id of the listing
+func sourceName(ctx context.Context, vectorStore store.VectorStore, source string, id int64) string {
+	if id == 0 {
+		return source
+	}
+	sources, err := vectorStore.Sources(ctx)
+	if err != nil {
+		log.Fatal().Err(err).Msg("Error listing sources")
+	}
+	for _, s := range sources {
+		if s.ID == id {
+			return s.Name
+		}
+	}
+	log.Fatal().Msgf("Source %d not found in %s", id, vectorStore.Info().Name)
+	return ""
+}
+
+func listSources(ctx context.Context, vectorStore store.VectorStore) {
+	sources, err := vectorStore.Sources(ctx)
+	if err != nil {
+		log.Fatal().Err(err).Msg("Error listing sources")
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tSOURCE\tTYPE\tSIZE\tPAGES\tCHUNKS\tINGESTED\tHASH")
+	for _, s := range sources {
+		ingested := "-"
+		if !s.IngestedAt.IsZero() {
+			ingested = s.IngestedAt.Local().Format(time.DateTime)
+		}
+		hash := "-"
+		if s.Hash != "" {
+			hash = s.Hash[:min(12, len(s.Hash))]
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%d\t%s\t%s\n",
+			orDash(s.ID), s.Name, orDash(s.MIMEType), orDash(s.Size), orDash(s.PageCount), s.Chunks, ingested, hash)
+	}
+	w.Flush()
+}
+
+func showChunks(ctx context.Context, vectorStore store.VectorStore, source string) {
+	chunks, err := vectorStore.Chunks(ctx, source)
+	if err != nil {
+		log.Fatal().Err(err).Msg("Error reading chunks")
+	}
+	if len(chunks) == 0 {
+		log.Fatal().Msgf("Source %s has no chunks", source)
+	}
+
+	for _, c := range chunks {
+		fmt.Printf("== %s (page %d, chunk %d)\n", c.ID, c.PageNumber, c.ChunkID)
+		keys := make([]string, 0, len(c.Metadata))
+		for k := range c.Metadata {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			fmt.Printf("%s: %s\n", k, c.Metadata[k])
+		}
+		fmt.Printf("%s\n\n", strings.TrimSpace(c.Content))
+	}
+}
+
+// orDash prints the zero value of fields a backend does not keep as -
+func orDash[T comparable](v T) string {
+	var zero T
+	if v == zero {
+		return "-"
+	}
+	return fmt.Sprint(v)
+}
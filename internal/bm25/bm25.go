@@ -0,0 +1,230 @@
+// Package bm25 is an in-memory inverted index ranking documents with Okapi
+// BM25, persisted as a gob file.
+package bm25
+
+import (
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"io/fs"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+)
+
+// standard BM25 parameters, k1 saturates term frequency and b scales the
+// length normalization
+const (
+	k1 = 1.2
+	b  = 0.75
+)
+
+// Index maps terms to the documents containing them. It is safe for
+// concurrent use.
+type Index struct {
+	mu          sync.RWMutex
+	docs        map[string]map[string]int // doc ID -> term frequencies
+	lengths     map[string]int
+	postings    map[string]map[string]int // term -> doc ID -> frequency
+	totalLength int
+}
+
+// Hit is a document matching a query
+type Hit struct {
+	ID    string
+	Score float64
+}
+
+// New returns an empty index
+func New() *Index {
+	return &Index{
+		docs:     map[string]map[string]int{},
+		lengths:  map[string]int{},
+		postings: map[string]map[string]int{},
+	}
+}
+
+// Add indexes the text of a document, replacing its previous text
+func (ix *Index) Add(id, text string) {
+	terms := Tokenize(text)
+	freqs := make(map[string]int, len(terms))
+	for _, t := range terms {
+		freqs[t]++
+	}
+
+	ix.mu.Lock()
+	defer ix.mu.Unlock()
+	ix.remove(id)
+	ix.insert(id, freqs, len(terms))
+}
+
+// Remove drops documents from the index
+func (ix *Index) Remove(ids ...string) {
+	ix.mu.Lock()
+	defer ix.mu.Unlock()
+	for _, id := range ids {
+		ix.remove(id)
+	}
+}
+
+// Len returns the number of indexed documents
+func (ix *Index) Len() int {
+	ix.mu.RLock()
+	defer ix.mu.RUnlock()
+	return len(ix.docs)
+}
+
+func (ix *Index) insert(id string, freqs map[string]int, length int) {
+	ix.docs[id] = freqs
+	ix.lengths[id] = length
+	ix.totalLength += length
+	for t, f := range freqs {
+		if ix.postings[t] == nil {
+			ix.postings[t] = map[string]int{}
+		}
+		ix.postings[t][id] = f
+	}
+}
+
+func (ix *Index) remove(id string) {
+	freqs, ok := ix.docs[id]
+	if !ok {
+		return
+	}
+	for t := range freqs {
+		delete(ix.postings[t], id)
+		if len(ix.postings[t]) == 0 {
+			delete(ix.postings, t)
+		}
+	}
+	ix.totalLength -= ix.lengths[id]
+	delete(ix.docs, id)
+	delete(ix.lengths, id)
+}
+
+// Search returns the documents containing any query term ordered by
+// descending BM25 score, all of them when k <= 0
+func (ix *Index) Search(query string, k int) []Hit {
+	ix.mu.RLock()
+	defer ix.mu.RUnlock()
+
+	n := float64(len(ix.docs))
+	if n == 0 {
+		return nil
+	}
+	avgLength := float64(ix.totalLength) / n
+
+	scores := map[string]float64{}
+	seen := map[string]bool{}
+	for _, t := range Tokenize(query) {
+		// a repeated query term does not count twice
+		if seen[t] {
+			continue
+		}
+		seen[t] = true
+
+		postings := ix.postings[t]
+		df := float64(len(postings))
+		if df == 0 {
+			continue
+		}
+		idf := math.Log(1 + (n-df+0.5)/(df+0.5))
+		for id, f := range postings {
+			tf := float64(f)
+			norm := 1 - b + b*float64(ix.lengths[id])/avgLength
+			scores[id] += idf * tf * (k1 + 1) / (tf + k1*norm)
+		}
+	}
+
+	hits := make([]Hit, 0, len(scores))
+	for id, s := range scores {
+		hits = append(hits, Hit{ID: id, Score: s})
+	}
+	sort.Slice(hits, func(i, j int) bool {
+		if hits[i].Score != hits[j].Score {
+			return hits[i].Score > hits[j].Score
+		}
+		return hits[i].ID < hits[j].ID
+	})
+	if k > 0 && len(hits) > k {
+		hits = hits[:k]
+	}
+	return hits
+}
+
+// snapshot is the persisted form, postings are rebuilt on load
+type snapshot struct {
+	Version int
+	Docs    map[string]map[string]int
+	Lengths map[string]int
+}
+
+// snapshotVersion changes with the tokenizer, an index written by another
+// version is discarded and rebuilt
+const snapshotVersion = 1
+
+// ErrStale is returned by Load for an index written with another tokenizer
+var ErrStale = errors.New("bm25 index was built by another version")
+
+// Save writes the index to path, replacing the file atomically
+func (ix *Index) Save(path string) error {
+	ix.mu.RLock()
+	defer ix.mu.RUnlock()
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*")
+	if err != nil {
+		return fmt.Errorf("failed to save bm25 index: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	err = gob.NewEncoder(tmp).Encode(snapshot{
+		Version: snapshotVersion,
+		Docs:    ix.docs,
+		Lengths: ix.lengths,
+	})
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return fmt.Errorf("failed to save bm25 index: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return fmt.Errorf("failed to save bm25 index: %w", err)
+	}
+	return nil
+}
+
+// Load reads an index written by Save. A missing file yields fs.ErrNotExist
+// and an outdated one ErrStale, both mean the index has to be rebuilt.
+func Load(path string) (*Index, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var snap snapshot
+	if err := gob.NewDecoder(f).Decode(&snap); err != nil {
+		return nil, fmt.Errorf("failed to load bm25 index: %w", err)
+	}
+	if snap.Version != snapshotVersion {
+		return nil, ErrStale
+	}
+
+	ix := New()
+	for id, freqs := range snap.Docs {
+		ix.insert(id, freqs, snap.Lengths[id])
+	}
+	return ix, nil
+}
+
+// RemoveFile deletes a saved index, a missing file is not an error
+func RemoveFile(path string) error {
+	err := os.Remove(path)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,241 @@
+package bm25
+
+import (
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"io/fs"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestStem(t *testing.T) {
+	tests := []struct {
+		word string
+		want string
+	}{
+		{"caresses", "caress"},
+		{"ponies", "poni"},
+		{"cats", "cat"},
+		{"running", "run"},
+		{"hopping", "hop"},
+		{"agreed", "agre"},
+		{"happy", "happi"},
+		{"relational", "relat"},
+		{"generalization", "gener"},
+		{"electrical", "electr"},
+		{"adjustable", "adjust"},
+		{"controlling", "control"},
+		{"as", "as"},
+	}
+	for _, tt := range tests {
+		if got := Stem(tt.word); got != tt.want {
+			t.Errorf("Stem(%q) = %q, want %q", tt.word, got, tt.want)
+		}
+	}
+}
+
+func TestTokenize(t *testing.T) {
+	tests := []struct {
+		name string
+		text string
+		want string
+	}{
+		{"stems and lowers", "Running Connections", "run connect"},
+		{"drops stopwords", "the soul of all beings", "soul be"},
+		{"splits on punctuation", "error-code: E42/retry", "error code e42 retri"},
+		{"keeps words with digits", "ISO9001 v2", "iso9001 v2"},
+		{"keeps non-ASCII words", "Dharmakṣetre kurukṣetre", "dharmakṣetre kurukṣetre"},
+		{"empty", " .,; ", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := strings.Join(Tokenize(tt.text), " "); got != tt.want {
+				t.Errorf("Tokenize(%q) = %q, want %q", tt.text, got, tt.want)
+			}
+		})
+	}
+}
+
+func newIndex(docs map[string]string) *Index {
+	ix := New()
+	for id, text := range docs {
+		ix.Add(id, text)
+	}
+	return ix
+}
+
+func hitIDs(hits []Hit) string {
+	ids := make([]string, len(hits))
+	for i, h := range hits {
+		ids[i] = h.ID
+	}
+	return strings.Join(ids, ",")
+}
+
+func TestSearch(t *testing.T) {
+	ix := newIndex(map[string]string{
+		"weapons": "Weapons cannot cut the soul, fire cannot burn it.",
+		"yoga":    "Yoga is skill in action, perform your duty without attachment.",
+		"duty":    "Duty performed without attachment to results frees the soul.",
+		"long":    "The soul is eternal. " + strings.Repeat("Padding words fill this chunk. ", 20),
+	})
+	tests := []struct {
+		name  string
+		query string
+		k     int
+		want  string
+	}{
+		{"single term", "yoga", 0, "yoga"},
+		{"stemmed query matches inflections", "performing duties", 0, "duty,yoga"},
+		{"long documents rank last, ties by ID", "soul", 0, "duty,weapons,long"},
+		{"k limits", "soul", 2, "duty,weapons"},
+		{"rare terms weigh more", "soul attachment fire", 0, "weapons,duty,yoga,long"},
+		{"repeated term counts once", "yoga yoga yoga", 0, "yoga"},
+		{"stopwords only", "the of and", 0, ""},
+		{"unknown term", "krishna", 0, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := hitIDs(ix.Search(tt.query, tt.k)); got != tt.want {
+				t.Errorf("Search(%q) = %s, want %s", tt.query, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestScore(t *testing.T) {
+	ix := newIndex(map[string]string{"a": "soul soul eternal", "b": "duty"})
+	hits := ix.Search("soul", 0)
+	if len(hits) != 1 {
+		t.Fatalf("hits %v", hits)
+	}
+	// idf with n=2, df=1; tf=2 in a document of length 3, average length 2
+	idf := math.Log(1 + (2-1+0.5)/(1+0.5))
+	norm := 1 - b + b*3/2.0
+	want := idf * 2 * (k1 + 1) / (2 + k1*norm)
+	if math.Abs(hits[0].Score-want) > 1e-9 {
+		t.Errorf("score %v, want %v", hits[0].Score, want)
+	}
+
+	// repeated query terms do not change the score
+	if again := ix.Search("soul souls", 0); math.Abs(again[0].Score-want) > 1e-9 {
+		t.Errorf("score of a repeated term %v, want %v", again[0].Score, want)
+	}
+}
+
+func TestAddReplacesAndRemove(t *testing.T) {
+	ix := newIndex(map[string]string{"a": "soul", "b": "soul duty"})
+	ix.Add("a", "duty")
+	if got := hitIDs(ix.Search("soul", 0)); got != "b" {
+		t.Errorf("soul matches %s after replacing a, want b", got)
+	}
+	ix.Remove("b", "missing")
+	if got := hitIDs(ix.Search("soul duty", 0)); got != "a" {
+		t.Errorf("matches %s after removing b, want a", got)
+	}
+	if ix.Len() != 1 {
+		t.Errorf("Len = %d, want 1", ix.Len())
+	}
+	ix.Remove("a")
+	if ix.Search("duty", 0) != nil || len(ix.postings) != 0 || ix.totalLength != 0 {
+		t.Errorf("empty index keeps postings %v and length %d", ix.postings, ix.totalLength)
+	}
+}
+
+func TestSaveLoad(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "c.bm25")
+	ix := newIndex(map[string]string{"a": "weapons cannot cut the soul", "b": "duty without attachment"})
+	if err := ix.Save(path); err != nil {
+		t.Fatalf("Save: %v", err)
+	}
+
+	loaded, err := Load(path)
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	for _, query := range []string{"soul", "attachment duty", "weapons"} {
+		want, got := ix.Search(query, 0), loaded.Search(query, 0)
+		if fmt.Sprint(got) != fmt.Sprint(want) {
+			t.Errorf("loaded index finds %v for %q, want %v", got, query, want)
+		}
+	}
+	entries, _ := os.ReadDir(dir)
+	if len(entries) != 1 {
+		t.Errorf("save left %d files", len(entries))
+	}
+
+	tests := []struct {
+		name  string
+		write func(path string) error
+		err   error
+	}{
+		{"missing file", func(path string) error { return nil }, fs.ErrNotExist},
+		{"other version", func(path string) error {
+			f, err := os.Create(path)
+			if err != nil {
+				return err
+			}
+			defer f.Close()
+			return gob.NewEncoder(f).Encode(snapshot{Version: snapshotVersion + 1})
+		}, ErrStale},
+		{"damaged file", func(path string) error { return os.WriteFile(path, []byte("not gob"), 0o644) }, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "c.bm25")
+			if err := tt.write(path); err != nil {
+				t.Fatal(err)
+			}
+			_, err := Load(path)
+			if err == nil || (tt.err != nil && !errors.Is(err, tt.err)) {
+				t.Errorf("Load error = %v, want %v", err, tt.err)
+			}
+		})
+	}
+
+	if err := RemoveFile(path); err != nil {
+		t.Errorf("RemoveFile: %v", err)
+	}
+	if err := RemoveFile(path); err != nil {
+		t.Errorf("RemoveFile of a missing file: %v", err)
+	}
+}
+
+func TestConcurrentUpdates(t *testing.T) {
+	ix := New()
+	path := filepath.Join(t.TempDir(), "c.bm25")
+	var wg sync.WaitGroup
+	for w := 0; w < 4; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < 50; i++ {
+				id := fmt.Sprintf("%d-%d", w, i)
+				ix.Add(id, "soul duty "+id)
+				ix.Search("soul", 5)
+				if i%2 == 1 {
+					ix.Remove(id)
+				}
+				if i%10 == 0 {
+					if err := ix.Save(path); err != nil {
+						t.Errorf("Save: %v", err)
+					}
+				}
+			}
+		}(w)
+	}
+	wg.Wait()
+
+	if ix.Len() != 100 {
+		t.Errorf("Len = %d, want 100", ix.Len())
+	}
+	if hits := ix.Search("soul", 0); len(hits) != 100 {
+		t.Errorf("%d hits, want 100", len(hits))
+	}
+}
@@ -0,0 +1,54 @@
+package bm25
+
+import (
+	"strings"
+	"unicode"
+)
+
+// stopwords are common English words that carry no retrieval signal
+var stopwords = toSet(`a about above after again against all am an and any are as at be because been
+before being below between both but by can did do does doing down during each few for from
+further had has have having he her here hers herself him himself his how i if in into is it its
+itself just me more most my myself no nor not now of off on once only or other our ours
+ourselves out over own s same she should so some such t than that the their theirs them
+themselves then there these they this those through to too under until up very was we were
+what when where which while who whom why will with you your yours yourself yourselves`)
+
+func toSet(words string) map[string]bool {
+	set := map[string]bool{}
+	for _, w := range strings.Fields(words) {
+		set[w] = true
+	}
+	return set
+}
+
+// Tokenize splits text into lower case terms on anything but letters and
+// digits, drops stopwords and stems plain English words. Words with digits
+// or non-ASCII letters, such as error codes or transliterations, are kept
+// as they are.
+func Tokenize(text string) []string {
+	words := strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+
+	terms := words[:0]
+	for _, w := range words {
+		if stopwords[w] {
+			continue
+		}
+		if isASCIILetters(w) {
+			w = Stem(w)
+		}
+		terms = append(terms, w)
+	}
+	return terms
+}
+
+func isASCIILetters(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] < 'a' || s[i] > 'z' {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,100 @@
+// Package chat persists the sessions of multi-turn conversations, so a
+// client can continue one by its ID
+package chat
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	"document-rag/internal/config"
+	"document-rag/internal/db"
+	"document-rag/internal/models"
+)
+
+const (
+	StoreFile     = "file"
+	StorePostgres = "postgres"
+)
+
+const defaultDir = "./sessions"
+
+// SessionStore loads and saves chat sessions
+type SessionStore interface {
+	// Load returns a session, models.ErrSessionNotFound if it does not exist
+	Load(ctx context.Context, id string) (*models.ChatSession, error)
+	// Save creates or replaces a session
+	Save(ctx context.Context, session *models.ChatSession) error
+	// List returns every session, most recently updated first
+	List(ctx context.Context) ([]models.ChatSession, error)
+	// Delete removes a session, models.ErrSessionNotFound if it does not exist
+	Delete(ctx context.Context, id string) error
+	Close() error
+}
+
+// session IDs end up in file names
+var sessionID = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+
+// ValidateID rejects session IDs that are not usable by every store
+func ValidateID(id string) error {
+	if !sessionID.MatchString(id) {
+		return fmt.Errorf("invalid session ID %q, use letters, digits, _ and -", id)
+	}
+	return nil
+}
+
+// NewID returns a random session ID
+func NewID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// NewSession starts an empty session
+func NewSession(id string) *models.ChatSession {
+	now := time.Now().UTC()
+	return &models.ChatSession{ID: id, CreatedAt: now, UpdatedAt: now}
+}
+
+// LoadOrCreate returns the session with the given ID, a new one if it does
+// not exist yet
+func LoadOrCreate(ctx context.Context, store SessionStore, id string) (*models.ChatSession, error) {
+	if err := ValidateID(id); err != nil {
+		return nil, err
+	}
+	session, err := store.Load(ctx, id)
+	if err == models.ErrSessionNotFound {
+		return NewSession(id), nil
+	}
+	return session, err
+}
+
+// OpenStore opens the session store of the config, JSON files under
+// chat.dir by default or the chat_sessions table of the database
+func OpenStore(ctx context.Context, cfg *config.Config) (SessionStore, error) {
+	switch strings.ToLower(cfg.Chat.Store) {
+	case "", StoreFile:
+		dir := cfg.Chat.Dir
+		if dir == "" {
+			dir = defaultDir
+		}
+		return NewFileStore(dir)
+	case StorePostgres:
+		dbClient, err := db.ConnectDB(ctx, &cfg.Database)
+		if err != nil {
+			return nil, fmt.Errorf("failed to connect to database: %w", err)
+		}
+		dbInstance := db.NewDB(dbClient, cfg.Database.Debug)
+		if err := db.InitDB(ctx, dbInstance); err != nil {
+			dbInstance.Close()
+			return nil, fmt.Errorf("failed to initialize database: %w", err)
+		}
+		return &PgStore{db: dbInstance}, nil
+	default:
+		return nil, fmt.Errorf("unsupported session store: %s", cfg.Chat.Store)
+	}
+}
@@ -0,0 +1,103 @@
+package chat
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"document-rag/internal/models"
+)
+
+// FileStore keeps every session as <id>.json in a directory
+type FileStore struct {
+	dir string
+}
+
+func NewFileStore(dir string) (*FileStore, error) {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, fmt.Errorf("failed to create session directory: %w", err)
+	}
+	return &FileStore{dir: dir}, nil
+}
+
+func (f *FileStore) path(id string) string {
+	return filepath.Join(f.dir, id+".json")
+}
+
+func (f *FileStore) Load(ctx context.Context, id string) (*models.ChatSession, error) {
+	if err := ValidateID(id); err != nil {
+		return nil, err
+	}
+	data, err := os.ReadFile(f.path(id))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, models.ErrSessionNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	var session models.ChatSession
+	if err := json.Unmarshal(data, &session); err != nil {
+		return nil, fmt.Errorf("failed to read session %s: %w", id, err)
+	}
+	return &session, nil
+}
+
+// Save writes the session to a temporary file first, so an interrupted save
+// keeps the previous version
+func (f *FileStore) Save(ctx context.Context, session *models.ChatSession) error {
+	if err := ValidateID(session.ID); err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(session, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := f.path(session.ID) + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o600); err != nil {
+		return fmt.Errorf("failed to write session %s: %w", session.ID, err)
+	}
+	return os.Rename(tmp, f.path(session.ID))
+}
+
+func (f *FileStore) List(ctx context.Context) ([]models.ChatSession, error) {
+	entries, err := os.ReadDir(f.dir)
+	if err != nil {
+		return nil, err
+	}
+	var sessions []models.ChatSession
+	for _, entry := range entries {
+		id, ok := strings.CutSuffix(entry.Name(), ".json")
+		if !ok || entry.IsDir() {
+			continue
+		}
+		session, err := f.Load(ctx, id)
+		if err != nil {
+			return nil, err
+		}
+		sessions = append(sessions, *session)
+	}
+	sort.SliceStable(sessions, func(i, j int) bool {
+		return sessions[i].UpdatedAt.After(sessions[j].UpdatedAt)
+	})
+	return sessions, nil
+}
+
+func (f *FileStore) Delete(ctx context.Context, id string) error {
+	if err := ValidateID(id); err != nil {
+		return err
+	}
+	err := os.Remove(f.path(id))
+	if errors.Is(err, os.ErrNotExist) {
+		return models.ErrSessionNotFound
+	}
+	return err
+}
+
+func (f *FileStore) Close() error {
+	return nil
+}
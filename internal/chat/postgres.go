@@ -0,0 +1,35 @@
+package chat
+
+import (
+	"context"
+
+	"document-rag/internal/db"
+	"document-rag/internal/models"
+
+	"github.com/uptrace/bun"
+)
+
+// PgStore keeps sessions in the chat_sessions table
+type PgStore struct {
+	db *bun.DB
+}
+
+func (p *PgStore) Load(ctx context.Context, id string) (*models.ChatSession, error) {
+	return db.GetChatSession(ctx, p.db, id)
+}
+
+func (p *PgStore) Save(ctx context.Context, session *models.ChatSession) error {
+	return db.SaveChatSession(ctx, p.db, session)
+}
+
+func (p *PgStore) List(ctx context.Context) ([]models.ChatSession, error) {
+	return db.ListChatSessions(ctx, p.db)
+}
+
+func (p *PgStore) Delete(ctx context.Context, id string) error {
+	return db.DeleteChatSession(ctx, p.db, id)
+}
+
+func (p *PgStore) Close() error {
+	return p.db.Close()
+}
@@ -0,0 +1,167 @@
+package chromemdb
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"document-rag/internal/bm25"
+	"document-rag/internal/models"
+
+	"github.com/philippgille/chromem-go"
+)
+
+// DefaultCollection is the collection used when none is configured
+const DefaultCollection = "bg_collection"
+
+// Backend holds every collection of one chromem database
+type Backend struct {
+	db            *chromem.DB
+	dbPath        string
+	inMemory      bool
+	encryptionKey string
+
+	// encrypted collection snapshots, see snapshot.go
+	snapshotDir       string
+	compressSnapshots bool
+
+	mu sync.Mutex
+	// collection info by collection, read from its file on first use, the
+	// only copy in an in-memory database
+	infos map[string]collectionInfo
+	// loaded BM25 indexes by collection
+	keywords map[string]*bm25.Index
+}
+
+// NewBackend opens the chromem database at dbPath, or an empty one in memory.
+// An in-memory database keeps its collections in encrypted snapshots, so it
+// needs an encryption key.
+func NewBackend(dbPath string, inMemory bool, encryptionKey string) (*Backend, error) {
+	var db *chromem.DB
+	var err error
+	if inMemory && encryptionKey == "" {
+		return nil, errors.New("an in-memory database needs an encryption key to save its collections")
+	}
+	if inMemory {
+		db = chromem.NewDB()
+	} else {
+		db, err = chromem.NewPersistentDB(dbPath, compress)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create database: %v", err)
+		}
+	}
+
+	return &Backend{
+		db:            db,
+		dbPath:        dbPath,
+		inMemory:      inMemory,
+		encryptionKey: encryptionKey,
+		infos:         map[string]collectionInfo{},
+		keywords:      map[string]*bm25.Index{},
+	}, nil
+}
+
+func (b *Backend) manager(collectionName string) *VectorDBManager {
+	return &VectorDBManager{
+		backend:       b,
+		db:            b.db,
+		ctx:           context.Background(),
+		dbPath:        b.dbPath,
+		compress:      b.compressSnapshots,
+		encryptionKey: b.encryptionKey,
+		filePath:      b.SnapshotPath(collectionName),
+		inMemory:      b.inMemory,
+	}
+}
+
+// Collection opens a collection, creating it for the given embedding model
+// and dimension if it does not exist yet
+func (b *Backend) Collection(name, embeddingModel string, dimension int) (*VectorDBManager, error) {
+	m := b.manager(name)
+	if _, err := m.GetOrCreateCollection(name); err != nil {
+		return nil, err
+	}
+
+	info, err := b.readInfo(name)
+	if err != nil {
+		return nil, err
+	}
+
+	switch info.Dimension {
+	case 0:
+		info.Dimension = dimension
+	case dimension:
+	default:
+		return nil, fmt.Errorf("%w: collection %s holds %d-dimensional embeddings but the embedding model produces %d, "+
+			"re-ingest with -reset to rebuild the collection for the new model",
+			models.ErrDimensionMismatch, name, info.Dimension, dimension)
+	}
+	// collections created before the model was recorded adopt the current one
+	if info.EmbeddingModel == "" {
+		info.EmbeddingModel = embeddingModel
+	}
+	if info.CreatedAt.IsZero() {
+		info.CreatedAt = time.Now().UTC()
+	}
+	if err := b.writeInfo(name, info); err != nil {
+		return nil, err
+	}
+
+	m.dimension = dimension
+	return m, nil
+}
+
+// Describe returns a collection with its chunk count
+func (b *Backend) Describe(name string) (*models.CollectionInfo, error) {
+	c := b.db.GetCollection(name, nil)
+	if c == nil {
+		return nil, fmt.Errorf("%w: %s", models.ErrCollectionNotFound, name)
+	}
+	info, err := b.readInfo(name)
+	if err != nil {
+		return nil, err
+	}
+	return &models.CollectionInfo{
+		Name:           name,
+		EmbeddingModel: info.EmbeddingModel,
+		Dimension:      info.Dimension,
+		Chunks:         c.Count(),
+		CreatedAt:      info.CreatedAt,
+	}, nil
+}
+
+// Collections returns every collection of the database ordered by name
+func (b *Backend) Collections() ([]models.CollectionInfo, error) {
+	names := make([]string, 0)
+	for name := range b.db.ListCollections() {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	infos := make([]models.CollectionInfo, 0, len(names))
+	for _, name := range names {
+		info, err := b.Describe(name)
+		if err != nil {
+			return nil, err
+		}
+		infos = append(infos, *info)
+	}
+	return infos, nil
+}
+
+// DropCollection deletes a collection with its documents and info
+func (b *Backend) DropCollection(name string) error {
+	if b.db.GetCollection(name, nil) == nil {
+		return fmt.Errorf("%w: %s", models.ErrCollectionNotFound, name)
+	}
+	if err := b.db.DeleteCollection(name); err != nil {
+		return fmt.Errorf("failed to drop collection: %v", err)
+	}
+	if err := b.dropKeywords(name); err != nil {
+		return err
+	}
+	return b.removeInfo(name)
+}
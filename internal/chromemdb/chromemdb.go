@@ -21,13 +21,16 @@ type Document struct {
 
 // VectorDBManager encapsulates the chromem-go database operations
 type VectorDBManager struct {
-	db         *chromem.DB
-	collection *chromem.Collection
-	ctx        context.Context
-	dbPath     string
-	compress   bool
+	backend       *Backend
+	db            *chromem.DB
+	collection    *chromem.Collection
+	ctx           context.Context
+	dbPath        string
+	compress      bool
 	encryptionKey string
-	filePath   string
+	filePath      string
+	inMemory      bool
+	dimension     int
 }
 
 const (
@@ -35,33 +38,17 @@ const (
 )
 
 // NewVectorDBManager initializes a new vector database manager
-func NewVectorDBManager(dbPath,collectionName string, inMemory bool, encryptionKey string) (*VectorDBManager, error) {
-	ctx := context.Background()
-	var db *chromem.DB
-	var err error
-	if inMemory {
-		db = chromem.NewDB()
-	} else {
-		db, err = chromem.NewPersistentDB(dbPath, compress)
-		if err != nil {
-			return nil, fmt.Errorf("failed to create database: %v", err)
-		}
+func NewVectorDBManager(dbPath, collectionName string, inMemory bool, encryptionKey string) (*VectorDBManager, error) {
+	b, err := NewBackend(dbPath, inMemory, encryptionKey)
+	if err != nil {
+		return nil, err
 	}
-
-	return &VectorDBManager{
-		db:         db,
-		collection: nil,
-		ctx:        ctx,
-		dbPath:     dbPath,
-		compress:   compress,
-		encryptionKey: encryptionKey,
-		filePath:   dbPath + "/" + collectionName + ".chromem",
-	}, nil
+	return b.manager(collectionName), nil
 }
 
 // create or read collection
 func (m *VectorDBManager) GetOrCreateCollection(collectionName string) (*chromem.Collection, error) {
-	c, 	err := m.db.GetOrCreateCollection(collectionName, nil, nil)
+	c, err := m.db.GetOrCreateCollection(collectionName, nil, nil)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create/get collection: %v", err)
 	}
@@ -82,7 +69,7 @@ func (m *VectorDBManager) Create(doc Document) error {
 	if err != nil {
 		return fmt.Errorf("failed to add document: %v", err)
 	}
-	return nil
+	return m.indexDocuments(m.ctx, []chromem.Document{chromemDoc})
 }
 
 // add multiple documents
@@ -91,7 +78,7 @@ func (m *VectorDBManager) CreateDocs(documents []chromem.Document) error {
 	if err != nil {
 		return fmt.Errorf("failed to add document: %v", err)
 	}
-	return nil
+	return m.indexDocuments(m.ctx, documents)
 }
 
 // Read retrieves documents by ID or performs a similarity search
@@ -115,38 +102,40 @@ func (m *VectorDBManager) DeleteCollection() error {
 	if err != nil {
 		return fmt.Errorf("failed to drop collection: %v", err)
 	}
-	return nil
+	return m.backend.dropKeywords(m.collection.Name)
 }
 
-// export to file
+// Export writes the collection to its encrypted snapshot file
 func (m *VectorDBManager) Export(ctx context.Context) error {
-	if m.encryptionKey == "" {
-		return fmt.Errorf("encryption key is required")
-	}
 	if m.collection == nil {
 		return fmt.Errorf("collection is required")
 	}
-	if m.dbPath == "" {
-		return fmt.Errorf("db path is required")
-	}
 
 	log.Debug().Msgf("Collection name: %s", m.collection.Name)
 	log.Debug().Msgf("File path: %s", m.filePath)
 	log.Debug().Msgf("Compress: %t", m.compress)
-	log.Debug().Msgf("DB path: %s", m.dbPath)
-	// export collection
-	err := m.db.ExportToFile(m.filePath, m.compress, m.encryptionKey, m.collection.Name)
+	manifest, err := m.backend.CreateSnapshot(m.collection.Name, m.filePath)
 	if err != nil {
-		return fmt.Errorf("failed to export database: %v", err)
+		return fmt.Errorf("failed to export collection: %w", err)
 	}
+	log.Debug().Msgf("Exported %d documents to %s", manifest.Documents, m.filePath)
 	return nil
 }
 
-// import from file
+// Import replaces the collection with the content of its snapshot file
 func (m *VectorDBManager) Import(ctx context.Context) error {
-	err := m.db.ImportFromFile(m.dbPath, m.collection.Name)
+	if m.collection == nil {
+		return fmt.Errorf("collection is required")
+	}
+
+	manifest, err := m.backend.RestoreSnapshot(m.filePath)
 	if err != nil {
-		return fmt.Errorf("failed to import database: %v", err)
+		return fmt.Errorf("failed to import collection: %w", err)
+	}
+	if manifest.Collection != m.collection.Name {
+		return fmt.Errorf("snapshot %s holds collection %s, not %s", m.filePath, manifest.Collection, m.collection.Name)
 	}
+	m.collection = m.db.GetCollection(manifest.Collection, nil)
+	m.dimension = manifest.Dimension
 	return nil
 }
@@ -0,0 +1,100 @@
+package chromemdb
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"time"
+
+	"document-rag/internal/models"
+)
+
+// collectionInfo is kept next to the chromem files, chromem does not expose
+// collection metadata once the collection is created
+type collectionInfo struct {
+	EmbeddingModel string    `json:"embedding_model,omitempty"`
+	Dimension      int       `json:"dimension"`
+	CreatedAt      time.Time `json:"created_at,omitempty"`
+}
+
+func (b *Backend) infoPath(name string) string {
+	return filepath.Join(b.dbPath, name+".info.json")
+}
+
+func (b *Backend) readInfo(name string) (collectionInfo, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if info, ok := b.infos[name]; ok || b.inMemory {
+		return info, nil
+	}
+
+	var info collectionInfo
+	data, err := os.ReadFile(b.infoPath(name))
+	if errors.Is(err, fs.ErrNotExist) {
+		return info, nil
+	}
+	if err != nil {
+		return info, fmt.Errorf("failed to read collection info: %v", err)
+	}
+	if err := json.Unmarshal(data, &info); err != nil {
+		return info, fmt.Errorf("failed to decode collection info: %v", err)
+	}
+	b.infos[name] = info
+	return info, nil
+}
+
+func (b *Backend) writeInfo(name string, info collectionInfo) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if !b.inMemory {
+		data, err := json.MarshalIndent(info, "", "  ")
+		if err != nil {
+			return fmt.Errorf("failed to encode collection info: %v", err)
+		}
+		if err := os.WriteFile(b.infoPath(name), data, 0o644); err != nil {
+			return fmt.Errorf("failed to write collection info: %v", err)
+		}
+	}
+	b.infos[name] = info
+	return nil
+}
+
+func (b *Backend) removeInfo(name string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	delete(b.infos, name)
+	if b.inMemory {
+		return nil
+	}
+
+	err := os.Remove(b.infoPath(name))
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("failed to remove collection info: %v", err)
+	}
+	return nil
+}
+
+// Dimension returns the embedding dimension of the collection, 0 if unknown
+func (m *VectorDBManager) Dimension() int {
+	return m.dimension
+}
+
+// Info returns the collection the manager is scoped to, its info is read
+// from the file once when the collection is opened
+func (m *VectorDBManager) Info() models.CollectionInfo {
+	info, err := m.backend.Describe(m.collection.Name)
+	if err != nil {
+		return models.CollectionInfo{Name: m.collection.Name, Dimension: m.dimension}
+	}
+	return *info
+}
+
+func (m *VectorDBManager) checkDimension(embedding []float32) error {
+	if m.dimension > 0 && len(embedding) != m.dimension {
+		return fmt.Errorf("%w: got %d dimensions, the collection holds %d", models.ErrDimensionMismatch, len(embedding), m.dimension)
+	}
+	return nil
+}
@@ -0,0 +1,51 @@
+package chromemdb
+
+import (
+	"os"
+	"testing"
+)
+
+func TestInfoReadOnce(t *testing.T) {
+	b, m := newSnapshotBackend(t, 2)
+
+	// the info file is read when the collection opens, not on every call
+	if err := os.WriteFile(b.infoPath(testCollection), []byte(`{"embedding_model": "other"}`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	info := m.Info()
+	if info.EmbeddingModel != testModel || info.Dimension != testDimension || info.Chunks != 2 {
+		t.Errorf("Info = %+v", info)
+	}
+
+	stored, err := b.readInfo(testCollection)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stored.EmbeddingModel = "next-model"
+	if err := b.writeInfo(testCollection, stored); err != nil {
+		t.Fatalf("writeInfo: %v", err)
+	}
+	if got := m.Info().EmbeddingModel; got != "next-model" {
+		t.Errorf("Info after writeInfo has model %s, want next-model", got)
+	}
+
+	// a new backend reads what writeInfo stored
+	reopened, err := NewBackend(b.dbPath, false, testKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	described, err := reopened.Describe(testCollection)
+	if err != nil {
+		t.Fatalf("Describe: %v", err)
+	}
+	if described.EmbeddingModel != "next-model" || described.Chunks != 2 {
+		t.Errorf("reopened collection %+v", described)
+	}
+
+	if err := b.DropCollection(testCollection); err != nil {
+		t.Fatalf("DropCollection: %v", err)
+	}
+	if _, ok := b.infos[testCollection]; ok {
+		t.Error("dropped collection keeps its cached info")
+	}
+}
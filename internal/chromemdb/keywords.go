@@ -0,0 +1,114 @@
+package chromemdb
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"path/filepath"
+
+	"document-rag/internal/bm25"
+
+	"github.com/philippgille/chromem-go"
+	"github.com/rs/zerolog/log"
+)
+
+// The BM25 index of a collection is kept next to the chromem files and
+// shared by every manager of the collection. It is rebuilt from the stored
+// documents when missing or out of step with the collection.
+
+func (b *Backend) keywordsPath(name string) string {
+	return filepath.Join(b.dbPath, name+".bm25.gob")
+}
+
+// keywordIndex returns the BM25 index of the manager's collection, loading
+// or rebuilding it on first use
+func (m *VectorDBManager) keywordIndex(ctx context.Context) (*bm25.Index, error) {
+	if m.collection == nil {
+		return nil, fmt.Errorf("collection is required")
+	}
+	b := m.backend
+	name := m.collection.Name
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if ix, ok := b.keywords[name]; ok {
+		return ix, nil
+	}
+
+	var ix *bm25.Index
+	if !b.inMemory {
+		var err error
+		ix, err = bm25.Load(b.keywordsPath(name))
+		switch {
+		case err == nil:
+		case errors.Is(err, fs.ErrNotExist), errors.Is(err, bm25.ErrStale):
+			ix = nil
+		default:
+			log.Warn().Err(err).Msgf("Rebuilding keyword index of %s", name)
+			ix = nil
+		}
+	}
+
+	if ix == nil || ix.Len() != m.collection.Count() {
+		docs, err := m.documentsWhere(ctx, nil)
+		if err != nil {
+			return nil, fmt.Errorf("failed to rebuild keyword index: %w", err)
+		}
+		ix = bm25.New()
+		for _, doc := range docs {
+			ix.Add(doc.ID, doc.Content)
+		}
+		if err := b.saveKeywords(name, ix); err != nil {
+			return nil, err
+		}
+		log.Debug().Msgf("Built keyword index of %s with %d documents", name, ix.Len())
+	}
+
+	b.keywords[name] = ix
+	return ix, nil
+}
+
+// indexDocuments adds documents to the BM25 index after they were stored
+func (m *VectorDBManager) indexDocuments(ctx context.Context, docs []chromem.Document) error {
+	ix, err := m.keywordIndex(ctx)
+	if err != nil {
+		return err
+	}
+	for _, doc := range docs {
+		ix.Add(doc.ID, doc.Content)
+	}
+	return m.backend.saveKeywords(m.collection.Name, ix)
+}
+
+// unindexDocuments removes deleted documents from the BM25 index
+func (m *VectorDBManager) unindexDocuments(ctx context.Context, ids []string) error {
+	ix, err := m.keywordIndex(ctx)
+	if err != nil {
+		return err
+	}
+	ix.Remove(ids...)
+	return m.backend.saveKeywords(m.collection.Name, ix)
+}
+
+func (b *Backend) saveKeywords(name string, ix *bm25.Index) error {
+	if b.inMemory {
+		return nil
+	}
+	return ix.Save(b.keywordsPath(name))
+}
+
+// dropKeywords forgets the BM25 index of a deleted collection
+func (b *Backend) dropKeywords(name string) error {
+	b.mu.Lock()
+	delete(b.keywords, name)
+	b.mu.Unlock()
+
+	if b.inMemory {
+		return nil
+	}
+	if err := bm25.RemoveFile(b.keywordsPath(name)); err != nil {
+		return fmt.Errorf("failed to remove keyword index: %v", err)
+	}
+	return nil
+}
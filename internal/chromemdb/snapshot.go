@@ -0,0 +1,319 @@
+package chromemdb
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/philippgille/chromem-go"
+	"github.com/rs/zerolog/log"
+)
+
+// A snapshot is one collection exported by chromem, encrypted with AES-GCM
+// and optionally gzip compressed, plus a JSON manifest holding the collection
+// info chromem does not export and a checksum of the file. The manifest of
+// the snapshot at path is path.json and names the data file, which is written
+// under a new name per content, path.<checksum prefix>. Replacing a snapshot
+// only switches the manifest, renamed into place last, so a failure or crash
+// leaves the previous manifest and data file in place. Snapshots written
+// before data files were named keep their data at path itself.
+
+const (
+	snapshotExt  = ".chromem"
+	manifestExt  = ".json"
+	snapshotMode = 0o600
+	// hex digits of the checksum in data file names
+	dataNameSum = 16
+)
+
+// SnapshotManifest describes a snapshot file
+type SnapshotManifest struct {
+	Collection     string    `json:"collection"`
+	EmbeddingModel string    `json:"embedding_model,omitempty"`
+	Dimension      int       `json:"dimension"`
+	Documents      int       `json:"documents"`
+	Compressed     bool      `json:"compressed"`
+	SHA256         string    `json:"sha256"`
+	CreatedAt      time.Time `json:"created_at"`
+	// File is the data file next to the manifest, empty when the data is at
+	// the snapshot path itself
+	File string `json:"file,omitempty"`
+}
+
+// dataPath returns the data file of the snapshot at path
+func (m *SnapshotManifest) dataPath(path string) string {
+	if m.File == "" {
+		return path
+	}
+	return filepath.Join(filepath.Dir(path), m.File)
+}
+
+// ConfigureSnapshots sets the directory of snapshot files, the database path
+// when empty, and whether new snapshots are compressed
+func (b *Backend) ConfigureSnapshots(dir string, compress bool) {
+	b.snapshotDir = dir
+	b.compressSnapshots = compress
+}
+
+// SnapshotPath returns the default snapshot file of a collection
+func (b *Backend) SnapshotPath(name string) string {
+	dir := b.snapshotDir
+	if dir == "" {
+		dir = b.dbPath
+	}
+	return filepath.Join(dir, name+snapshotExt)
+}
+
+// CreateSnapshot writes the collection to an encrypted snapshot at path. An
+// existing snapshot is replaced by switching its manifest once the new data
+// file is complete, then its data file is removed.
+func (b *Backend) CreateSnapshot(name, path string) (*SnapshotManifest, error) {
+	if b.encryptionKey == "" {
+		return nil, fmt.Errorf("encryption key is required")
+	}
+	c := b.db.GetCollection(name, nil)
+	if c == nil {
+		return nil, fmt.Errorf("collection %s does not exist", name)
+	}
+	info, err := b.readInfo(name)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create snapshot folder: %v", err)
+	}
+
+	tmp := path + ".tmp"
+	defer os.Remove(tmp)
+	if err := b.db.ExportToFile(tmp, b.compressSnapshots, b.encryptionKey, name); err != nil {
+		return nil, fmt.Errorf("failed to export collection: %v", err)
+	}
+	if err := os.Chmod(tmp, snapshotMode); err != nil {
+		return nil, fmt.Errorf("failed to write snapshot: %v", err)
+	}
+	sum, err := fileSHA256(tmp)
+	if err != nil {
+		return nil, err
+	}
+
+	manifest := &SnapshotManifest{
+		Collection:     name,
+		EmbeddingModel: info.EmbeddingModel,
+		Dimension:      info.Dimension,
+		Documents:      c.Count(),
+		Compressed:     b.compressSnapshots,
+		SHA256:         sum,
+		CreatedAt:      time.Now().UTC(),
+		File:           filepath.Base(path) + "." + sum[:dataNameSum],
+	}
+	previous, err := ReadSnapshotManifest(path)
+	if err != nil {
+		previous = nil
+	}
+	data := manifest.dataPath(path)
+	if err := os.Rename(tmp, data); err != nil {
+		return nil, fmt.Errorf("failed to write snapshot: %v", err)
+	}
+	if err := writeManifest(path, manifest); err != nil {
+		// the previous manifest is untouched, drop the data it does not name
+		if previous == nil || previous.dataPath(path) != data {
+			os.Remove(data)
+		}
+		return nil, err
+	}
+
+	// the manifest names the new data file, older ones are unreferenced
+	if previous != nil && previous.File == "" {
+		removeSnapshotFile(path)
+	}
+	stale, _ := filepath.Glob(path + "." + strings.Repeat("?", dataNameSum))
+	for _, f := range stale {
+		if f != data {
+			removeSnapshotFile(f)
+		}
+	}
+	return manifest, nil
+}
+
+func removeSnapshotFile(path string) {
+	if err := os.Remove(path); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		log.Warn().Err(err).Msgf("Failed to remove old snapshot data %s", path)
+	}
+}
+
+// RestoreSnapshot replaces the collection of a snapshot file with its
+// content. The file is checked against its manifest and decrypted into a
+// scratch database first, so a damaged snapshot or a wrong key leaves the
+// live collection untouched.
+func (b *Backend) RestoreSnapshot(path string) (*SnapshotManifest, error) {
+	if b.encryptionKey == "" {
+		return nil, fmt.Errorf("encryption key is required")
+	}
+	manifest, err := ReadSnapshotManifest(path)
+	if err != nil {
+		return nil, err
+	}
+	data := manifest.dataPath(path)
+	if err := verifySnapshot(data, manifest, b.encryptionKey); err != nil {
+		return nil, fmt.Errorf("snapshot %s is not valid: %w", path, err)
+	}
+
+	name := manifest.Collection
+	if b.db.GetCollection(name, nil) != nil {
+		// stale document files of a persistent collection would survive the import
+		if err := b.db.DeleteCollection(name); err != nil {
+			return nil, fmt.Errorf("failed to replace collection: %v", err)
+		}
+	}
+	if err := b.db.ImportFromFile(data, b.encryptionKey, name); err != nil {
+		return nil, fmt.Errorf("failed to import snapshot: %v", err)
+	}
+	if err := b.dropKeywords(name); err != nil {
+		return nil, err
+	}
+
+	info, err := b.readInfo(name)
+	if err != nil {
+		return nil, err
+	}
+	info.EmbeddingModel = manifest.EmbeddingModel
+	info.Dimension = manifest.Dimension
+	if info.CreatedAt.IsZero() {
+		info.CreatedAt = manifest.CreatedAt
+	}
+	if err := b.writeInfo(name, info); err != nil {
+		return nil, err
+	}
+	return manifest, nil
+}
+
+// RestoreSnapshots restores every snapshot of the snapshot directory, it is
+// used to load an in-memory database at startup. A snapshot that fails does
+// not stop the others, the failures are returned together.
+func (b *Backend) RestoreSnapshots() ([]SnapshotManifest, error) {
+	manifests, err := filepath.Glob(b.SnapshotPath("*") + manifestExt)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list snapshots: %v", err)
+	}
+	legacy, err := filepath.Glob(b.SnapshotPath("*"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to list snapshots: %v", err)
+	}
+	for _, path := range legacy {
+		if _, err := os.Stat(path + manifestExt); errors.Is(err, fs.ErrNotExist) {
+			log.Warn().Msgf("Skipping snapshot %s without manifest", path)
+		}
+	}
+
+	var restored []SnapshotManifest
+	var errs []error
+	for _, m := range manifests {
+		manifest, err := b.RestoreSnapshot(strings.TrimSuffix(m, manifestExt))
+		if err != nil {
+			log.Error().Err(err).Msg("Failed to restore snapshot")
+			errs = append(errs, err)
+			continue
+		}
+		restored = append(restored, *manifest)
+	}
+	return restored, errors.Join(errs...)
+}
+
+// ReadSnapshotManifest reads the manifest written next to a snapshot file
+func ReadSnapshotManifest(path string) (*SnapshotManifest, error) {
+	data, err := os.ReadFile(path + manifestExt)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, fmt.Errorf("snapshot manifest %s not found", path+manifestExt)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read snapshot manifest: %v", err)
+	}
+	var manifest SnapshotManifest
+	if err := json.Unmarshal(data, &manifest); err != nil {
+		return nil, fmt.Errorf("failed to decode snapshot manifest: %v", err)
+	}
+	if manifest.Collection == "" || manifest.SHA256 == "" {
+		return nil, fmt.Errorf("snapshot manifest %s is incomplete", path+manifestExt)
+	}
+	if manifest.File != "" && filepath.Base(manifest.File) != manifest.File {
+		return nil, fmt.Errorf("snapshot manifest %s names a file outside its folder", path+manifestExt)
+	}
+	return &manifest, nil
+}
+
+func writeManifest(path string, manifest *SnapshotManifest) error {
+	data, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode snapshot manifest: %v", err)
+	}
+	tmp := path + manifestExt + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o644); err != nil {
+		return fmt.Errorf("failed to write snapshot manifest: %v", err)
+	}
+	if err := os.Rename(tmp, path+manifestExt); err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("failed to write snapshot manifest: %v", err)
+	}
+	return nil
+}
+
+// verifySnapshot checks the file checksum, then decrypts it and checks the
+// document count and that every embedding has the recorded dimension
+func verifySnapshot(path string, manifest *SnapshotManifest, encryptionKey string) error {
+	sum, err := fileSHA256(path)
+	if err != nil {
+		return err
+	}
+	if !strings.EqualFold(sum, manifest.SHA256) {
+		return fmt.Errorf("checksum mismatch, the file was modified or truncated")
+	}
+
+	scratch := chromem.NewDB()
+	if err := scratch.ImportFromFile(path, encryptionKey, manifest.Collection); err != nil {
+		return fmt.Errorf("failed to decrypt: %v", err)
+	}
+	c := scratch.GetCollection(manifest.Collection, nil)
+	if c == nil {
+		return fmt.Errorf("collection %s is missing", manifest.Collection)
+	}
+	if c.Count() != manifest.Documents {
+		return fmt.Errorf("holds %d documents, the manifest records %d", c.Count(), manifest.Documents)
+	}
+	if c.Count() == 0 {
+		return nil
+	}
+	if manifest.Dimension <= 0 {
+		return fmt.Errorf("manifest records no embedding dimension")
+	}
+
+	// chromem rejects documents of another dimension when ranking them
+	probe := make([]float32, manifest.Dimension)
+	probe[0] = 1
+	if _, err := c.QueryEmbedding(context.Background(), probe, c.Count(), nil, nil); err != nil {
+		return fmt.Errorf("embeddings do not match %d dimensions: %v", manifest.Dimension, err)
+	}
+	return nil
+}
+
+func fileSHA256(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", fmt.Errorf("failed to read snapshot: %v", err)
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", fmt.Errorf("failed to read snapshot: %v", err)
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
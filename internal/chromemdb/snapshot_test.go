@@ -0,0 +1,282 @@
+package chromemdb
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"document-rag/internal/models"
+)
+
+const (
+	testKey        = "0123456789abcdef0123456789abcdef"
+	testCollection = "manuals"
+	testModel      = "embed-model"
+	testDimension  = 3
+)
+
+// newSnapshotBackend opens a database under a temporary folder holding one
+// collection with n chunks
+func newSnapshotBackend(t *testing.T, n int) (*Backend, *VectorDBManager) {
+	t.Helper()
+	b, err := NewBackend(filepath.Join(t.TempDir(), "db"), false, testKey)
+	if err != nil {
+		t.Fatalf("NewBackend: %v", err)
+	}
+	b.ConfigureSnapshots(t.TempDir(), false)
+	m, err := b.Collection(testCollection, testModel, testDimension)
+	if err != nil {
+		t.Fatalf("Collection: %v", err)
+	}
+	addChunks(t, m, 0, n)
+	return b, m
+}
+
+// addChunks adds chunks first to first+n-1 of source a.txt
+func addChunks(t *testing.T, m *VectorDBManager, first, n int) {
+	t.Helper()
+	chunks := make([]models.ChunkEmbedding, n)
+	for i := range chunks {
+		id := first + i
+		chunks[i] = models.ChunkEmbedding{
+			ID:             fmt.Sprintf("a.txt-%d", id),
+			Content:        fmt.Sprintf("chunk %d", id),
+			Embedding:      []float32{1, float32(id), 0},
+			SourceFilename: "a.txt",
+			ChunkID:        id,
+		}
+	}
+	if err := m.Upsert(context.Background(), chunks); err != nil {
+		t.Fatalf("Upsert: %v", err)
+	}
+}
+
+func countDocuments(t *testing.T, b *Backend) int {
+	t.Helper()
+	info, err := b.Describe(testCollection)
+	if err != nil {
+		t.Fatalf("Describe: %v", err)
+	}
+	return info.Chunks
+}
+
+func TestSnapshotRoundTrip(t *testing.T) {
+	for _, compressed := range []bool{false, true} {
+		t.Run(fmt.Sprintf("compressed=%v", compressed), func(t *testing.T) {
+			b, m := newSnapshotBackend(t, 3)
+			b.compressSnapshots = compressed
+			path := b.SnapshotPath(testCollection)
+
+			manifest, err := b.CreateSnapshot(testCollection, path)
+			if err != nil {
+				t.Fatalf("CreateSnapshot: %v", err)
+			}
+			if manifest.Documents != 3 || manifest.Dimension != testDimension ||
+				manifest.EmbeddingModel != testModel || manifest.Compressed != compressed {
+				t.Errorf("unexpected manifest %+v", manifest)
+			}
+
+			addChunks(t, m, 3, 2)
+			restored, err := b.RestoreSnapshot(path)
+			if err != nil {
+				t.Fatalf("RestoreSnapshot: %v", err)
+			}
+			if restored.SHA256 != manifest.SHA256 {
+				t.Errorf("restored %s, want %s", restored.SHA256, manifest.SHA256)
+			}
+			if got := countDocuments(t, b); got != 3 {
+				t.Errorf("restored collection holds %d documents, want 3", got)
+			}
+			info, err := b.readInfo(testCollection)
+			if err != nil {
+				t.Fatalf("readInfo: %v", err)
+			}
+			if info.Dimension != testDimension || info.EmbeddingModel != testModel {
+				t.Errorf("restored info %+v", info)
+			}
+		})
+	}
+}
+
+func TestSnapshotReplaceKeepsOneDataFile(t *testing.T) {
+	b, m := newSnapshotBackend(t, 2)
+	path := b.SnapshotPath(testCollection)
+
+	if _, err := b.CreateSnapshot(testCollection, path); err != nil {
+		t.Fatalf("CreateSnapshot: %v", err)
+	}
+	addChunks(t, m, 2, 1)
+	manifest, err := b.CreateSnapshot(testCollection, path)
+	if err != nil {
+		t.Fatalf("CreateSnapshot: %v", err)
+	}
+
+	files, err := filepath.Glob(path + "*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{path + "." + manifest.SHA256[:dataNameSum], path + manifestExt}
+	if strings.Join(files, ",") != strings.Join(want, ",") {
+		t.Errorf("snapshot files = %v, want %v", files, want)
+	}
+}
+
+func TestRestoreSnapshotRejects(t *testing.T) {
+	tests := []struct {
+		name string
+		// change damages the snapshot at path created for the backend
+		change func(t *testing.T, b *Backend, path string)
+		err    string
+	}{
+		{
+			name: "wrong key",
+			change: func(t *testing.T, b *Backend, path string) {
+				b.encryptionKey = strings.Repeat("f", len(testKey))
+			},
+			err: "failed to decrypt",
+		},
+		{
+			name: "checksum mismatch",
+			change: func(t *testing.T, b *Backend, path string) {
+				m, err := ReadSnapshotManifest(path)
+				if err != nil {
+					t.Fatal(err)
+				}
+				f, err := os.OpenFile(m.dataPath(path), os.O_APPEND|os.O_WRONLY, 0)
+				if err != nil {
+					t.Fatal(err)
+				}
+				f.Write([]byte{0})
+				f.Close()
+			},
+			err: "checksum mismatch",
+		},
+		{
+			name: "document count mismatch",
+			change: func(t *testing.T, b *Backend, path string) {
+				m, err := ReadSnapshotManifest(path)
+				if err != nil {
+					t.Fatal(err)
+				}
+				m.Documents++
+				if err := writeManifest(path, m); err != nil {
+					t.Fatal(err)
+				}
+			},
+			err: "holds 2 documents, the manifest records 3",
+		},
+		{
+			name: "missing manifest",
+			change: func(t *testing.T, b *Backend, path string) {
+				if err := os.Remove(path + manifestExt); err != nil {
+					t.Fatal(err)
+				}
+			},
+			err: "not found",
+		},
+		{
+			name: "stale manifest",
+			change: func(t *testing.T, b *Backend, path string) {
+				// a manifest kept from before the snapshot was replaced
+				old, err := os.ReadFile(path + manifestExt)
+				if err != nil {
+					t.Fatal(err)
+				}
+				m := b.manager(testCollection)
+				if _, err := m.GetOrCreateCollection(testCollection); err != nil {
+					t.Fatal(err)
+				}
+				addChunks(t, m, 2, 1)
+				if _, err := b.CreateSnapshot(testCollection, path); err != nil {
+					t.Fatal(err)
+				}
+				if err := os.WriteFile(path+manifestExt, old, 0o644); err != nil {
+					t.Fatal(err)
+				}
+			},
+			err: "failed to read snapshot",
+		},
+		{
+			name: "manifest names a file outside its folder",
+			change: func(t *testing.T, b *Backend, path string) {
+				m, err := ReadSnapshotManifest(path)
+				if err != nil {
+					t.Fatal(err)
+				}
+				m.File = filepath.Join("..", m.File)
+				if err := writeManifest(path, m); err != nil {
+					t.Fatal(err)
+				}
+			},
+			err: "outside its folder",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b, m := newSnapshotBackend(t, 2)
+			path := b.SnapshotPath(testCollection)
+			if _, err := b.CreateSnapshot(testCollection, path); err != nil {
+				t.Fatalf("CreateSnapshot: %v", err)
+			}
+			tt.change(t, b, path)
+
+			// the live collection must survive a rejected snapshot
+			addChunks(t, m, 10, 2)
+			live := countDocuments(t, b)
+			_, err := b.RestoreSnapshot(path)
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Fatalf("RestoreSnapshot error = %v, want %q", err, tt.err)
+			}
+			if got := countDocuments(t, b); got != live {
+				t.Errorf("live collection holds %d documents after a failed restore, want %d", got, live)
+			}
+		})
+	}
+}
+
+func TestRestoreSnapshotsSkipsFailures(t *testing.T) {
+	b, _ := newSnapshotBackend(t, 2)
+	if _, err := b.CreateSnapshot(testCollection, b.SnapshotPath(testCollection)); err != nil {
+		t.Fatalf("CreateSnapshot: %v", err)
+	}
+	// a snapshot whose manifest is missing is skipped, a damaged one fails
+	if err := os.WriteFile(b.SnapshotPath("orphan"), []byte("data"), snapshotMode); err != nil {
+		t.Fatal(err)
+	}
+	damaged := &SnapshotManifest{Collection: "damaged", SHA256: strings.Repeat("0", 64), File: "damaged.chromem.0"}
+	if err := writeManifest(b.SnapshotPath("damaged"), damaged); err != nil {
+		t.Fatal(err)
+	}
+
+	restored, err := b.RestoreSnapshots()
+	if err == nil || !strings.Contains(err.Error(), "damaged") {
+		t.Errorf("RestoreSnapshots error = %v, want the damaged snapshot", err)
+	}
+	if len(restored) != 1 || restored[0].Collection != testCollection {
+		t.Errorf("restored %+v, want %s", restored, testCollection)
+	}
+}
+
+func TestNewBackendInMemoryNeedsKey(t *testing.T) {
+	tests := []struct {
+		name     string
+		inMemory bool
+		key      string
+		wantErr  bool
+	}{
+		{"in memory without key", true, "", true},
+		{"in memory with key", true, testKey, false},
+		{"persistent without key", false, "", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := NewBackend(filepath.Join(t.TempDir(), "db"), tt.inMemory, tt.key)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("NewBackend error = %v, want error %v", err, tt.wantErr)
+			}
+		})
+	}
+}
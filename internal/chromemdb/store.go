@@ -0,0 +1,446 @@
+package chromemdb
+
+import (
+	"context"
+	"fmt"
+	"runtime"
+	"sort"
+	"strconv"
+	"time"
+
+	"document-rag/internal/filter"
+	"document-rag/internal/fusion"
+	"document-rag/internal/models"
+
+	"github.com/philippgille/chromem-go"
+)
+
+// Upsert adds chunks to the collection, chromem replaces documents with an existing ID
+func (m *VectorDBManager) Upsert(ctx context.Context, chunks []models.ChunkEmbedding) error {
+	if len(chunks) == 0 {
+		return nil
+	}
+	now := time.Now().UTC().Format(time.RFC3339)
+	docs := make([]chromem.Document, len(chunks))
+	for i, ce := range chunks {
+		if err := m.checkDimension(ce.Embedding); err != nil {
+			return fmt.Errorf("chunk %s: %w", ce.ID, err)
+		}
+		docs[i] = toChromemDocument(ce)
+		docs[i].Metadata[models.MetaIngestedAt] = now
+	}
+	err := m.collection.AddDocuments(ctx, docs, runtime.NumCPU())
+	if err != nil {
+		return fmt.Errorf("failed to add documents: %v", err)
+	}
+	return m.indexDocuments(ctx, docs)
+}
+
+// Search returns the k best chunks for the request mode: most similar to the
+// request embedding, best BM25 matches of the request text, or both merged
+// with rank fusion
+func (m *VectorDBManager) Search(ctx context.Context, req models.SearchRequest) ([]models.SearchResult, error) {
+	var results []models.SearchResult
+	var err error
+	switch req.Mode {
+	case models.SearchText:
+		results, err = m.textSearch(ctx, req, req.K)
+	case models.SearchHybrid:
+		results, err = fusion.Hybrid(req.K, req.Hybrid,
+			func(k int) ([]models.SearchResult, error) { return m.vectorSearch(ctx, req, k) },
+			func(k int) ([]models.SearchResult, error) { return m.textSearch(ctx, req, k) })
+	default:
+		results, err = m.vectorSearch(ctx, req, req.K)
+	}
+	if err != nil || req.WithEmbeddings {
+		return results, err
+	}
+	// the embeddings come with the documents, drop them unless asked for
+	for i := range results {
+		results[i].Embedding = nil
+	}
+	return results, nil
+}
+
+func (m *VectorDBManager) vectorSearch(ctx context.Context, req models.SearchRequest, k int) ([]models.SearchResult, error) {
+	if err := m.checkDimension(req.Embedding); err != nil {
+		return nil, fmt.Errorf("query: %w", err)
+	}
+
+	// chromem rejects nResults larger than the collection
+	count := m.collection.Count()
+	k = min(k, count)
+	if k <= 0 {
+		return nil, nil
+	}
+
+	where, whereDocument, exact := pushdownFilter(req.Filter)
+	nResults := k
+	if !exact {
+		// the rest of the filter is applied after ranking, so rank everything
+		nResults = count
+	}
+
+	docs, err := m.SearchWithQueryOptions(ctx, chromem.QueryOptions{
+		QueryEmbedding: req.Embedding,
+		NResults:       nResults,
+		Where:          where,
+		WhereDocument:  whereDocument,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	results := make([]models.SearchResult, 0, k)
+	for _, doc := range docs {
+		if !exact && !filter.Match(req.Filter, filterFields(doc)) {
+			continue
+		}
+		res := fromChromemResult(doc)
+		res.Collection = m.collection.Name
+		results = append(results, res)
+		if len(results) == k {
+			break
+		}
+	}
+	return results, nil
+}
+
+// textSearch ranks the collection with BM25, scores are mapped to [0, 1)
+// like the Postgres full-text rank
+func (m *VectorDBManager) textSearch(ctx context.Context, req models.SearchRequest, k int) ([]models.SearchResult, error) {
+	if req.Text == "" {
+		return nil, fmt.Errorf("full-text search needs a query text")
+	}
+	ix, err := m.keywordIndex(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	// filtered searches look further down the ranking for k matches
+	limit := k
+	if req.Filter != nil {
+		limit = 0
+	}
+
+	var results []models.SearchResult
+	for _, hit := range ix.Search(req.Text, limit) {
+		doc, err := m.collection.GetByID(ctx, hit.ID)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read document %s: %v", hit.ID, err)
+		}
+		r := chromem.Result{
+			ID:         doc.ID,
+			Metadata:   doc.Metadata,
+			Embedding:  doc.Embedding,
+			Content:    doc.Content,
+			Similarity: float32(hit.Score / (hit.Score + 1)),
+		}
+		if !filter.Match(req.Filter, filterFields(r)) {
+			continue
+		}
+		res := fromChromemResult(r)
+		res.Collection = m.collection.Name
+		results = append(results, res)
+		if len(results) == k {
+			break
+		}
+	}
+	return results, nil
+}
+
+// docIDField is the filter field of the chunk ID, synthesized by filterFields
+// rather than stored as metadata
+const docIDField = "doc_id"
+
+// pushdownFilter extracts the top level equality and content conditions that
+// chromem can evaluate natively. exact is false when other conditions remain
+// and the whole expression has to be matched against each result.
+func pushdownFilter(e filter.Expr) (where, whereDocument map[string]string, exact bool) {
+	exact = true
+	for _, term := range filter.Conjuncts(e) {
+		switch t := term.(type) {
+		case filter.Cond:
+			switch {
+			case t.Op == filter.OpEq && t.Field != filter.ContentField && t.Field != docIDField:
+				if where == nil {
+					where = map[string]string{}
+				}
+				if v, ok := where[t.Field]; ok && v != t.Value() {
+					exact = false
+					continue
+				}
+				where[t.Field] = t.Value()
+				continue
+			case t.Op == filter.OpContains && t.Field == filter.ContentField:
+				if pushContent(&whereDocument, "$contains", t.Value()) {
+					continue
+				}
+			}
+		case filter.Not:
+			if c, ok := t.Term.(filter.Cond); ok && c.Op == filter.OpContains && c.Field == filter.ContentField {
+				if pushContent(&whereDocument, "$not_contains", c.Value()) {
+					continue
+				}
+			}
+		}
+		exact = false
+	}
+	return where, whereDocument, exact
+}
+
+// chromem takes a single value per whereDocument operator
+func pushContent(whereDocument *map[string]string, op, value string) bool {
+	if *whereDocument == nil {
+		*whereDocument = map[string]string{}
+	}
+	if _, ok := (*whereDocument)[op]; ok {
+		return false
+	}
+	(*whereDocument)[op] = value
+	return true
+}
+
+// filterFields exposes a result's metadata and content to filter.Match
+func filterFields(r chromem.Result) map[string]string {
+	fields := make(map[string]string, len(r.Metadata)+2)
+	for k, v := range r.Metadata {
+		fields[k] = v
+	}
+	fields[docIDField] = r.ID
+	fields[filter.ContentField] = r.Content
+	return fields
+}
+
+// SourceState returns the recorded hashes of a source
+func (m *VectorDBManager) SourceState(ctx context.Context, source string) (models.SourceState, error) {
+	docs, err := m.documentsWhere(ctx, map[string]string{models.MetaSourceFilename: source})
+	if err != nil {
+		return models.SourceState{}, err
+	}
+
+	state := models.SourceState{Chunks: make(map[string]string, len(docs))}
+	for i, doc := range docs {
+		state.Chunks[doc.ID] = doc.Metadata[models.MetaContentHash]
+		// a source only counts as ingested when every chunk carries the same hash
+		if i == 0 {
+			state.Hash = doc.Metadata[models.MetaSourceHash]
+		} else if state.Hash != doc.Metadata[models.MetaSourceHash] {
+			state.Hash = ""
+		}
+	}
+	return state, nil
+}
+
+// RecordSource records the source hash on every chunk of a source, chromem
+// keeps no file metadata
+func (m *VectorDBManager) RecordSource(ctx context.Context, src models.SourceInfo) error {
+	hash := src.Hash
+	docs, err := m.documentsWhere(ctx, map[string]string{models.MetaSourceFilename: src.Name})
+	if err != nil {
+		return err
+	}
+
+	var stale []chromem.Document
+	for _, doc := range docs {
+		if doc.Metadata[models.MetaSourceHash] == hash {
+			continue
+		}
+		metadata := make(map[string]string, len(doc.Metadata))
+		for k, v := range doc.Metadata {
+			metadata[k] = v
+		}
+		metadata[models.MetaSourceHash] = hash
+		stale = append(stale, chromem.Document{
+			ID:        doc.ID,
+			Content:   doc.Content,
+			Metadata:  metadata,
+			Embedding: doc.Embedding,
+		})
+	}
+	if len(stale) == 0 {
+		return nil
+	}
+	if err := m.collection.AddDocuments(ctx, stale, runtime.NumCPU()); err != nil {
+		return fmt.Errorf("failed to update documents: %v", err)
+	}
+	return nil
+}
+
+// documentsWhere returns every document whose metadata matches where.
+// chromem has no listing API, so this ranks the filtered documents against
+// an arbitrary vector of the collection's dimension.
+func (m *VectorDBManager) documentsWhere(ctx context.Context, where map[string]string) ([]chromem.Result, error) {
+	count := m.collection.Count()
+	if count == 0 {
+		return nil, nil
+	}
+	if m.dimension == 0 {
+		return nil, fmt.Errorf("collection dimension is unknown")
+	}
+
+	probe := make([]float32, m.dimension)
+	probe[0] = 1
+	docs, err := m.collection.QueryEmbedding(ctx, probe, count, where, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list documents: %v", err)
+	}
+	return docs, nil
+}
+
+// Delete removes the documents with the given IDs
+func (m *VectorDBManager) Delete(ctx context.Context, ids ...string) error {
+	if len(ids) == 0 {
+		return nil
+	}
+	err := m.collection.Delete(ctx, nil, nil, ids...)
+	if err != nil {
+		return fmt.Errorf("failed to delete documents: %v", err)
+	}
+	return m.unindexDocuments(ctx, ids)
+}
+
+// Sources returns every source of the collection ordered by name
+func (m *VectorDBManager) Sources(ctx context.Context) ([]models.SourceInfo, error) {
+	docs, err := m.documentsWhere(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	bySource := map[string]*models.SourceInfo{}
+	var sources []*models.SourceInfo
+	for _, doc := range docs {
+		name := doc.Metadata[models.MetaSourceFilename]
+		src, ok := bySource[name]
+		if !ok {
+			src = &models.SourceInfo{Name: name, Hash: doc.Metadata[models.MetaSourceHash]}
+			bySource[name] = src
+			sources = append(sources, src)
+		}
+		src.Chunks++
+		// a source only has a hash when every chunk carries the same one
+		if src.Hash != doc.Metadata[models.MetaSourceHash] {
+			src.Hash = ""
+		}
+		if t, err := time.Parse(time.RFC3339, doc.Metadata[models.MetaIngestedAt]); err == nil && t.After(src.IngestedAt) {
+			src.IngestedAt = t
+		}
+	}
+
+	result := make([]models.SourceInfo, len(sources))
+	for i, src := range sources {
+		result[i] = *src
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Name < result[j].Name })
+	return result, nil
+}
+
+// Chunks returns the stored chunks of a source, without embeddings
+func (m *VectorDBManager) Chunks(ctx context.Context, source string) ([]models.ChunkEmbedding, error) {
+	docs, err := m.documentsWhere(ctx, map[string]string{models.MetaSourceFilename: source})
+	if err != nil {
+		return nil, err
+	}
+
+	chunks := make([]models.ChunkEmbedding, len(docs))
+	for i, doc := range docs {
+		res := fromChromemResult(doc)
+		chunks[i] = models.ChunkEmbedding{
+			ID:             res.ID,
+			Content:        res.Content,
+			SourceFilename: res.SourceFilename,
+			PageNumber:     res.PageNumber,
+			ChunkID:        res.ChunkID,
+			Metadata:       res.Metadata,
+			ContentHash:    doc.Metadata[models.MetaContentHash],
+			SourceHash:     doc.Metadata[models.MetaSourceHash],
+		}
+	}
+	sort.Slice(chunks, func(i, j int) bool {
+		a, b := chunks[i], chunks[j]
+		if a.PageNumber != b.PageNumber {
+			return a.PageNumber < b.PageNumber
+		}
+		if a.ChunkID != b.ChunkID {
+			return a.ChunkID < b.ChunkID
+		}
+		return a.ID < b.ID
+	})
+	return chunks, nil
+}
+
+// DeleteSource removes every chunk of a source
+func (m *VectorDBManager) DeleteSource(ctx context.Context, source string) (int, error) {
+	docs, err := m.documentsWhere(ctx, map[string]string{models.MetaSourceFilename: source})
+	if err != nil {
+		return 0, err
+	}
+	ids := make([]string, len(docs))
+	for i, doc := range docs {
+		ids[i] = doc.ID
+	}
+	if err := m.Delete(ctx, ids...); err != nil {
+		return 0, err
+	}
+	return len(ids), nil
+}
+
+// Reset deletes the collection and creates it again empty, keeping its
+// embedding model and dimension
+func (m *VectorDBManager) Reset(ctx context.Context) error {
+	name := m.collection.Name
+	if err := m.DeleteCollection(); err != nil {
+		return err
+	}
+	_, err := m.GetOrCreateCollection(name)
+	return err
+}
+
+// Close is a no-op, chromem persists on every write
+func (m *VectorDBManager) Close() error {
+	return nil
+}
+
+// chromem only keeps a string map, so the chunk fields are folded into the metadata
+func toChromemDocument(ce models.ChunkEmbedding) chromem.Document {
+	metadata := make(map[string]string, len(ce.Metadata)+3)
+	for k, v := range ce.Metadata {
+		metadata[k] = v
+	}
+	metadata[models.MetaSourceFilename] = ce.SourceFilename
+	metadata[models.MetaPageNumber] = strconv.Itoa(ce.PageNumber)
+	metadata[models.MetaChunkID] = strconv.Itoa(ce.ChunkID)
+	metadata[models.MetaContentHash] = ce.ContentHash
+	metadata[models.MetaSourceHash] = ce.SourceHash
+
+	return chromem.Document{
+		ID:        ce.ID,
+		Content:   ce.Content,
+		Metadata:  metadata,
+		Embedding: ce.Embedding,
+	}
+}
+
+func fromChromemResult(r chromem.Result) models.SearchResult {
+	res := models.SearchResult{
+		ID:        r.ID,
+		Content:   r.Content,
+		Metadata:  make(map[string]string, len(r.Metadata)),
+		Score:     models.Similarity(float64(r.Similarity)),
+		Embedding: r.Embedding,
+	}
+	for k, v := range r.Metadata {
+		switch k {
+		case models.MetaSourceFilename:
+			res.SourceFilename = v
+		case models.MetaPageNumber:
+			res.PageNumber, _ = strconv.Atoi(v)
+		case models.MetaChunkID:
+			res.ChunkID, _ = strconv.Atoi(v)
+		case models.MetaContentHash, models.MetaSourceHash, models.MetaIngestedAt:
+		default:
+			res.Metadata[k] = v
+		}
+	}
+	return res
+}
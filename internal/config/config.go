@@ -2,6 +2,7 @@ package config
 
 import (
 	"os"
+	"time"
 
 	"gopkg.in/yaml.v3"
 )
@@ -11,28 +12,185 @@ type Config struct {
 	EmbedLLM LLMConfig `yaml:"embed_llm"`
 	QueryLLM LLMConfig `yaml:"query_llm"`
 	RAG      RAGConfig `yaml:"rag_config"`
+
+	VectorStore VectorStoreConfig `yaml:"vector_store"`
+	Chat        ChatConfig        `yaml:"chat"`
+	Prompts     PromptsConfig     `yaml:"prompts"`
+	Server      ServerConfig      `yaml:"server"`
+}
+
+// ServerConfig is the HTTP API of the serve command
+type ServerConfig struct {
+	Addr        string `yaml:"addr"`          // listen address, :8080 by default
+	UploadDir   string `yaml:"upload_dir"`    // uploaded files are kept here as sources, ./uploads by default
+	MaxUploadMB int64  `yaml:"max_upload_mb"` // largest accepted upload, 32 by default
+	APIKey      string `yaml:"api_key"`       // bearer token required on /v1 when set
 }
 
+// PromptsConfig names the prompt templates of the answer, see package prompt
+type PromptsConfig struct {
+	Dir         string                          `yaml:"dir"`         // <name>.tmpl files, ./prompts by default
+	Default     string                          `yaml:"default"`     // template of queries, the built-in default by default
+	Collections map[string]string               `yaml:"collections"` // template by collection, used when every queried collection has the same
+	Templates   map[string]PromptTemplateConfig `yaml:"templates"`   // templates defined in the config instead of files
+}
+
+// PromptTemplateConfig is a prompt template in text/template syntax
+type PromptTemplateConfig struct {
+	System string `yaml:"system"`
+	User   string `yaml:"user"`
+}
+
+// ChatConfig controls multi-turn chat sessions
+type ChatConfig struct {
+	Store     string `yaml:"store"`     // file (default) or postgres, the database section
+	Dir       string `yaml:"dir"`       // file store directory, ./sessions by default
+	MaxTurns  int    `yaml:"max_turns"` // previous turns sent to the LLM, 6 by default
+	Summarize bool   `yaml:"summarize"` // summarize older turns instead of dropping them
+}
+
+// DbConfig is the Postgres connection, either a DSN or its fields. The PG*
+// environment variables and DATABASE_URL override the file, see LoadConfig.
 type DbConfig struct {
-	Host     string `yaml:"host"`
-	Port     string `yaml:"port"`
-	User     string `yaml:"user"`
-	Password string `yaml:"password"`
-	Database string `yaml:"database"`
-	Debug    bool   `yaml:"debug"`
+	DSN         string `yaml:"dsn"` // postgres://user@host:port/database?sslmode=..., replaces host, port, user and database
+	Host        string `yaml:"host"`
+	Port        string `yaml:"port"`
+	User        string `yaml:"user"`
+	Password    string `yaml:"password"`
+	Database    string `yaml:"database"`
+	SSLMode     string `yaml:"sslmode"`     // disable, require, verify-ca or verify-full, disable for localhost and require otherwise by default
+	SSLRootCert string `yaml:"sslrootcert"` // CA certificate verifying the server
+	Debug       bool   `yaml:"debug"`
+
+	Pool             PoolConfig    `yaml:"pool"`
+	ConnectTimeout   time.Duration `yaml:"connect_timeout"`   // 5s by default
+	StatementTimeout time.Duration `yaml:"statement_timeout"` // none by default
+	PgBouncer        bool          `yaml:"pgbouncer"`         // behind pgbouncer in transaction mode, no session state
+	ConnectRetries   int           `yaml:"connect_retries"`   // pings before giving up at startup, 5 by default
+	RetryDelay       time.Duration `yaml:"retry_delay"`       // before the first retry, doubling after each, 1s by default
+
+	VectorIndex VectorIndexConfig `yaml:"vector_index"`
+	BulkLoad    BulkLoadConfig    `yaml:"bulk_load"`
+}
+
+// PoolConfig sizes the connection pool, a pooler in front of the database
+// usually limits the connections of a client
+type PoolConfig struct {
+	MaxOpenConns    int           `yaml:"max_open_conns"`     // 10 by default
+	MaxIdleConns    int           `yaml:"max_idle_conns"`     // 5 by default
+	ConnMaxLifetime time.Duration `yaml:"conn_max_lifetime"`  // 30m by default
+	ConnMaxIdleTime time.Duration `yaml:"conn_max_idle_time"` // 5m by default
+}
+
+// BulkLoadConfig controls how chunks are written to Postgres. Each store call
+// runs in one transaction, split into batches of BatchSize rows.
+type BulkLoadConfig struct {
+	BatchSize int  `yaml:"batch_size"` // 500 by default
+	Copy      bool `yaml:"copy"`       // stream batches with COPY instead of INSERT
+}
+
+// VectorIndexConfig controls the pgvector ANN index and the distance metric
+// used both to build it and to order search results
+type VectorIndexConfig struct {
+	Type           string `yaml:"type"`   // hnsw (default), ivfflat or none
+	Metric         string `yaml:"metric"` // cosine (default), l2 or inner_product
+	M              int    `yaml:"m"`
+	EfConstruction int    `yaml:"ef_construction"`
+	Lists          int    `yaml:"lists"`
+	EfSearch       int    `yaml:"ef_search"`
+	Probes         int    `yaml:"probes"`
+}
+
+type VectorStoreConfig struct {
+	Backend    string `yaml:"backend"`    // pgvector or chromem (default)
+	Collection string `yaml:"collection"` // default collection for ingest and query
+	Path       string `yaml:"path"`       // chromem database directory, ./chromemdb by default
+	InMemory   bool   `yaml:"in_memory"`  // chromem only
+
+	Snapshot SnapshotConfig `yaml:"snapshot"` // chromem only
+}
+
+// SnapshotConfig controls the encrypted chromem snapshot files, keyed by
+// rag_config.encryption_key
+type SnapshotConfig struct {
+	Dir      string `yaml:"dir"`      // vector_store.path by default
+	Compress bool   `yaml:"compress"` // gzip before encrypting
+	Restore  bool   `yaml:"restore"`  // load every snapshot at startup in in_memory mode
 }
 
 type LLMConfig struct {
 	BaseURL string `yaml:"llm_base_url"`
 	Key     string `yaml:"llm_key"`
 	Model   string `yaml:"llm_model"`
+
+	ContextWindow int `yaml:"context_window"` // tokens the model accepts, 8192 by default
+	MaxTokens     int `yaml:"max_tokens"`     // tokens kept free for the answer, 1024 by default
 }
 
 type RAGConfig struct {
-	ChunkSize    int `yaml:"chunk_size"`
-	ChunkOverlap int `yaml:"chunk_overlap"`
-	MaxResults   int `yaml:"max_results"`
+	ChunkSize     int    `yaml:"chunk_size"`
+	ChunkOverlap  int    `yaml:"chunk_overlap"`
+	MaxResults    int    `yaml:"max_results"`
 	EncryptionKey string `yaml:"encryption_key"`
+
+	SearchMode string       `yaml:"search_mode"` // vector (default), text or hybrid
+	Hybrid     HybridConfig `yaml:"hybrid"`
+	MMR        MMRConfig    `yaml:"mmr"`
+	Expand     ExpandConfig `yaml:"expand"`
+
+	Rerank    RerankConfig    `yaml:"rerank"`
+	Transform TransformConfig `yaml:"transform"`
+	Packing   PackingConfig   `yaml:"packing"`
+
+	MinScore float64 `yaml:"min_score"` // chunks scoring below are not used as context, 0 keeps all
+	ScoreGap float64 `yaml:"score_gap"` // adaptive k, results end at the first score drop this large, 0 disables
+}
+
+// ExpandConfig controls neighbour expansion, which widens every retrieved
+// chunk with the chunks around it so the context holds passages
+type ExpandConfig struct {
+	Neighbours int      `yaml:"neighbours"` // chunks before and after each hit, 0 disables
+	GroupBy    []string `yaml:"group_by"`   // metadata keys neighbours share with the hit, such as chapter and speaker
+}
+
+// PackingConfig fits the context passages into the context window of the
+// query LLM, best scoring first
+type PackingConfig struct {
+	Overflow  string `yaml:"overflow"`   // truncate (default), summarize or drop a passage that does not fit
+	MinTokens int    `yaml:"min_tokens"` // smallest room worth a shortened passage, 64 by default
+}
+
+// TransformConfig turns a question into more search queries with the query
+// LLM before retrieval, the rankings of all of them are merged
+type TransformConfig struct {
+	Rewrite    bool `yaml:"rewrite"`     // also search a query rewritten from the question
+	MultiQuery int  `yaml:"multi_query"` // paraphrases searched besides the question, 0 disables
+	HyDE       bool `yaml:"hyde"`        // also search with a hypothetical answer passage
+}
+
+// RerankConfig selects the reranker run between retrieval and prompting
+type RerankConfig struct {
+	Type   string    `yaml:"type"`    // none (default), http or llm
+	LLM    LLMConfig `yaml:"llm"`     // the /rerank endpoint for http, the judge for llm (query_llm by default)
+	FetchK int       `yaml:"fetch_k"` // chunks retrieved for the reranker, 4 x top_k by default
+	TopK   int       `yaml:"top_k"`   // chunks kept after reranking, max_results by default
+}
+
+// MMRConfig controls the maximal marginal relevance re-selection of retrieved
+// chunks, which trades relevance for covering distinct passages
+type MMRConfig struct {
+	Enabled bool    `yaml:"enabled"`
+	Lambda  float64 `yaml:"lambda"`  // 1 is pure relevance, lower favours diversity, 0.5 by default
+	FetchK  int     `yaml:"fetch_k"` // candidates to select from, 4 x max_results by default
+}
+
+// HybridConfig controls how hybrid search merges vector and full-text results
+type HybridConfig struct {
+	Fusion       string  `yaml:"fusion"`        // rrf (default) or weighted
+	VectorWeight float64 `yaml:"vector_weight"` // 1 by default
+	TextWeight   float64 `yaml:"text_weight"`   // 1 by default
+	RRFK         int     `yaml:"rrf_k"`         // 60 by default
+	Candidates   int     `yaml:"candidates"`    // per retriever, 4 x max_results by default
 }
 
 func LoadConfig(path string) (*Config, error) {
@@ -44,5 +202,25 @@ func LoadConfig(path string) (*Config, error) {
 	if err := yaml.Unmarshal(data, &cfg); err != nil {
 		return nil, err
 	}
+	cfg.Database.applyEnv()
 	return &cfg, nil
 }
+
+// applyEnv overrides the connection with the environment variables libpq
+// reads, so credentials do not have to be kept in the config file
+func (c *DbConfig) applyEnv() {
+	for name, field := range map[string]*string{
+		"DATABASE_URL":  &c.DSN,
+		"PGHOST":        &c.Host,
+		"PGPORT":        &c.Port,
+		"PGUSER":        &c.User,
+		"PGPASSWORD":    &c.Password,
+		"PGDATABASE":    &c.Database,
+		"PGSSLMODE":     &c.SSLMode,
+		"PGSSLROOTCERT": &c.SSLRootCert,
+	} {
+		if v, ok := os.LookupEnv(name); ok && v != "" {
+			*field = v
+		}
+	}
+}
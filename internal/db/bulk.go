@@ -0,0 +1,208 @@
+package db
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"document-rag/internal/config"
+
+	"github.com/rs/zerolog/log"
+	"github.com/uptrace/bun"
+	"github.com/uptrace/bun/driver/pgdriver"
+)
+
+// DefaultBatchSize keeps multi-row inserts well below the size where
+// statements get slow to build and parse
+const DefaultBatchSize = 500
+
+// LoadOptions controls how documents are written in bulk
+type LoadOptions struct {
+	BatchSize int  // rows per statement, DefaultBatchSize when <= 0
+	Copy      bool // stream rows with COPY instead of multi-row INSERT
+}
+
+// NewLoadOptions returns the load options of the config, nil means defaults
+func NewLoadOptions(cfg *config.BulkLoadConfig) LoadOptions {
+	if cfg == nil {
+		return LoadOptions{BatchSize: DefaultBatchSize}
+	}
+	opts := LoadOptions{BatchSize: cfg.BatchSize, Copy: cfg.Copy}
+	if opts.BatchSize <= 0 {
+		opts.BatchSize = DefaultBatchSize
+	}
+	return opts
+}
+
+// loadColumns are the columns written by a bulk load, the others keep their
+// defaults
+var loadColumns = []string{
+	"collection", "doc_id", "content", "embedding", "source_id",
+	"page_number", "chunk_id", "metadata", "content_hash",
+}
+
+// upsertColumns are replaced when a row with the same collection and doc_id exists
+var upsertColumns = loadColumns[2:]
+
+// UpsertDocuments inserts documents in batches within one transaction, so a
+// failing batch leaves none of them stored, and replaces any existing row
+// with the same collection and doc_id. Sources named by SourceFilename are
+// registered as needed.
+func UpsertDocuments(ctx context.Context, db *bun.DB, documents []Document, opts LoadOptions) error {
+	if len(documents) == 0 {
+		return nil
+	}
+	batchSize := opts.BatchSize
+	if batchSize <= 0 {
+		batchSize = DefaultBatchSize
+	}
+
+	// COPY runs on the driver connection, so the transaction has to be
+	// pinned to it
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to get connection: %w", err)
+	}
+	defer conn.Close()
+
+	start := time.Now()
+	err = conn.RunInTx(ctx, nil, func(ctx context.Context, tx bun.Tx) error {
+		if err := ensureSources(ctx, tx, documents); err != nil {
+			return err
+		}
+		if opts.Copy {
+			// rows are copied into a staging table and merged from there,
+			// COPY itself cannot resolve conflicts
+			_, err := tx.ExecContext(ctx, `CREATE TEMP TABLE documents_load ON COMMIT DROP AS
+				SELECT `+strings.Join(loadColumns, ", ")+` FROM documents WITH NO DATA`)
+			if err != nil {
+				return fmt.Errorf("failed to create staging table: %w", err)
+			}
+		}
+
+		for n, i := 1, 0; i < len(documents); n, i = n+1, i+batchSize {
+			batch := documents[i:min(i+batchSize, len(documents))]
+			batchStart := time.Now()
+
+			var err error
+			if opts.Copy {
+				err = copyBatch(ctx, conn, tx, batch)
+			} else {
+				err = insertBatch(ctx, tx, batch)
+			}
+			if err != nil {
+				return fmt.Errorf("batch %d (rows %d-%d): %w", n, i+1, i+len(batch), err)
+			}
+
+			log.Debug().
+				Int("batch", n).
+				Int("rows", len(batch)).
+				Dur("took", time.Since(batchStart)).
+				Msgf("Stored batch %d of %d", n, (len(documents)+batchSize-1)/batchSize)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	took := time.Since(start)
+	log.Info().
+		Int("rows", len(documents)).
+		Dur("took", took).
+		Float64("rows_per_sec", float64(len(documents))/took.Seconds()).
+		Bool("copy", opts.Copy).
+		Msg("Stored documents")
+	return nil
+}
+
+func insertBatch(ctx context.Context, tx bun.Tx, batch []Document) error {
+	q := tx.NewInsert().Model(&batch).On("CONFLICT (collection, doc_id) DO UPDATE")
+	for _, col := range upsertColumns {
+		q = q.Set("? = EXCLUDED.?", bun.Ident(col), bun.Ident(col))
+	}
+	q = q.Set("updated_at = current_timestamp")
+	_, err := q.Exec(ctx)
+	return err
+}
+
+func copyBatch(ctx context.Context, conn bun.Conn, tx bun.Tx, batch []Document) error {
+	data, err := copyData(batch)
+	if err != nil {
+		return err
+	}
+
+	if _, err := tx.ExecContext(ctx, `TRUNCATE documents_load`); err != nil {
+		return err
+	}
+	columns := strings.Join(loadColumns, ", ")
+	if _, err := pgdriver.CopyFrom(ctx, conn, bytes.NewReader(data), "COPY documents_load ("+columns+") FROM STDIN"); err != nil {
+		return err
+	}
+
+	set := make([]string, 0, len(upsertColumns)+1)
+	for _, col := range upsertColumns {
+		set = append(set, col+" = EXCLUDED."+col)
+	}
+	set = append(set, "updated_at = current_timestamp")
+	_, err = tx.ExecContext(ctx, `INSERT INTO documents (`+columns+`)
+		SELECT `+columns+` FROM documents_load
+		ON CONFLICT (collection, doc_id) DO UPDATE SET `+strings.Join(set, ", "))
+	return err
+}
+
+// copyEscaper escapes the characters with a meaning in the COPY text format
+var copyEscaper = strings.NewReplacer(`\`, `\\`, "\t", `\t`, "\n", `\n`, "\r", `\r`)
+
+// copyData encodes documents as COPY text rows in loadColumns order
+func copyData(batch []Document) ([]byte, error) {
+	var buf bytes.Buffer
+	for _, doc := range batch {
+		metadata := doc.Metadata
+		if metadata == nil {
+			metadata = map[string]string{}
+		}
+		metadataJSON, err := json.Marshal(metadata)
+		if err != nil {
+			return nil, fmt.Errorf("failed to encode metadata of %s: %w", doc.DocID, err)
+		}
+
+		fields := []string{
+			collectionOrDefault(doc.Collection),
+			doc.DocID,
+			doc.Content,
+			vectorText(doc.Embedding),
+			strconv.FormatInt(doc.SourceID, 10),
+			strconv.Itoa(doc.PageNumber),
+			strconv.Itoa(doc.ChunkID),
+			string(metadataJSON),
+			doc.ContentHash,
+		}
+		for i, field := range fields {
+			if i > 0 {
+				buf.WriteByte('\t')
+			}
+			copyEscaper.WriteString(&buf, field)
+		}
+		buf.WriteByte('\n')
+	}
+	return buf.Bytes(), nil
+}
+
+// vectorText formats an embedding as a pgvector literal
+func vectorText(embedding []float32) string {
+	var b strings.Builder
+	b.WriteByte('[')
+	for i, v := range embedding {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		b.WriteString(strconv.FormatFloat(float64(v), 'f', -1, 32))
+	}
+	b.WriteByte(']')
+	return b.String()
+}
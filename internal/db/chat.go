@@ -0,0 +1,96 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"document-rag/internal/models"
+
+	"github.com/uptrace/bun"
+)
+
+// ChatSession is a stored conversation, see models.ChatSession
+type ChatSession struct {
+	bun.BaseModel `bun:"table:chat_sessions,alias:cs"`
+	ID            string            `bun:"id,pk"`
+	Turns         []models.ChatTurn `bun:"turns,type:jsonb,notnull"`
+	Summary       string            `bun:"summary,notnull"`
+	Summarized    int               `bun:"summarized,notnull"`
+	CreatedAt     time.Time         `bun:"created_at,nullzero,notnull,default:current_timestamp"`
+	UpdatedAt     time.Time         `bun:"updated_at,nullzero,notnull,default:current_timestamp"`
+}
+
+// GetChatSession returns a session, models.ErrSessionNotFound if it does not exist
+func GetChatSession(ctx context.Context, db *bun.DB, id string) (*models.ChatSession, error) {
+	var row ChatSession
+	err := db.NewSelect().Model(&row).Where("id = ?", id).Scan(ctx)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, models.ErrSessionNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return row.session(), nil
+}
+
+// SaveChatSession creates or replaces a session
+func SaveChatSession(ctx context.Context, db *bun.DB, session *models.ChatSession) error {
+	turns := session.Turns
+	if turns == nil {
+		turns = []models.ChatTurn{}
+	}
+	_, err := db.NewInsert().
+		Model(&ChatSession{
+			ID:         session.ID,
+			Turns:      turns,
+			Summary:    session.Summary,
+			Summarized: session.Summarized,
+			CreatedAt:  session.CreatedAt,
+			UpdatedAt:  session.UpdatedAt,
+		}).
+		On("CONFLICT (id) DO UPDATE").
+		Set("turns = EXCLUDED.turns").
+		Set("summary = EXCLUDED.summary").
+		Set("summarized = EXCLUDED.summarized").
+		Set("updated_at = EXCLUDED.updated_at").
+		Exec(ctx)
+	return err
+}
+
+// ListChatSessions returns every session, most recently updated first
+func ListChatSessions(ctx context.Context, db *bun.DB) ([]models.ChatSession, error) {
+	var rows []ChatSession
+	if err := db.NewSelect().Model(&rows).Order("updated_at DESC").Scan(ctx); err != nil {
+		return nil, err
+	}
+	sessions := make([]models.ChatSession, len(rows))
+	for i, row := range rows {
+		sessions[i] = *row.session()
+	}
+	return sessions, nil
+}
+
+// DeleteChatSession removes a session, models.ErrSessionNotFound if it does not exist
+func DeleteChatSession(ctx context.Context, db *bun.DB, id string) error {
+	res, err := db.NewDelete().Model((*ChatSession)(nil)).Where("id = ?", id).Exec(ctx)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return models.ErrSessionNotFound
+	}
+	return nil
+}
+
+func (s ChatSession) session() *models.ChatSession {
+	return &models.ChatSession{
+		ID:         s.ID,
+		Turns:      s.Turns,
+		Summary:    s.Summary,
+		Summarized: s.Summarized,
+		CreatedAt:  s.CreatedAt,
+		UpdatedAt:  s.UpdatedAt,
+	}
+}
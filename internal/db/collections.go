@@ -0,0 +1,146 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"document-rag/internal/models"
+
+	"github.com/uptrace/bun"
+)
+
+// DefaultCollection holds the rows stored before collections existed
+const DefaultCollection = "default"
+
+// Collection registers a named collection of the documents table with the
+// embedding model and dimension its rows were embedded with
+type Collection struct {
+	bun.BaseModel  `bun:"table:collections,alias:c"`
+	Name           string    `bun:"name,pk"`
+	EmbeddingModel string    `bun:"embedding_model,notnull,default:''"`
+	Dimension      int       `bun:"dimension,notnull"`
+	CreatedAt      time.Time `bun:"created_at,notnull,default:current_timestamp"`
+	Chunks         int       `bun:"chunks,scanonly"`
+}
+
+func (c Collection) info() models.CollectionInfo {
+	return models.CollectionInfo{
+		Name:           c.Name,
+		EmbeddingModel: c.EmbeddingModel,
+		Dimension:      c.Dimension,
+		Chunks:         c.Chunks,
+		CreatedAt:      c.CreatedAt,
+	}
+}
+
+// ensureCollection registers a collection for the given embedding model and
+// dimension, or checks them against the registered ones, and builds its
+// vector index
+func ensureCollection(ctx context.Context, db *bun.DB, name, embeddingModel string, dimension int, s indexSettings) (models.CollectionInfo, error) {
+	existing, err := GetCollection(ctx, db, name)
+	if err != nil && !errors.Is(err, models.ErrCollectionNotFound) {
+		return models.CollectionInfo{}, err
+	}
+
+	if existing == nil {
+		_, err = db.NewInsert().
+			Model(&Collection{Name: name, EmbeddingModel: embeddingModel, Dimension: dimension}).
+			On("CONFLICT (name) DO NOTHING").
+			Exec(ctx)
+		if err != nil {
+			return models.CollectionInfo{}, fmt.Errorf("failed to register collection %s: %w", name, err)
+		}
+		if existing, err = GetCollection(ctx, db, name); err != nil {
+			return models.CollectionInfo{}, err
+		}
+	}
+
+	if existing.Dimension != dimension {
+		return models.CollectionInfo{}, fmt.Errorf("%w: collection %s holds %d-dimensional embeddings but the embedding model produces %d, "+
+			"re-ingest with -reset to rebuild the collection for the new model",
+			models.ErrDimensionMismatch, name, existing.Dimension, dimension)
+	}
+
+	// collections migrated from the single table do not know their model yet
+	if existing.EmbeddingModel == "" && embeddingModel != "" {
+		_, err = db.NewUpdate().
+			Model((*Collection)(nil)).
+			Set("embedding_model = ?", embeddingModel).
+			Where("name = ?", name).
+			Exec(ctx)
+		if err != nil {
+			return models.CollectionInfo{}, fmt.Errorf("failed to record embedding model of %s: %w", name, err)
+		}
+		existing.EmbeddingModel = embeddingModel
+	}
+
+	if err := ensureVectorIndex(ctx, db, name, dimension, s); err != nil {
+		return models.CollectionInfo{}, err
+	}
+	return *existing, nil
+}
+
+// GetCollection returns a registered collection with its chunk count
+func GetCollection(ctx context.Context, db *bun.DB, name string) (*models.CollectionInfo, error) {
+	var c Collection
+	err := collectionQuery(db).Where("c.name = ?", name).Scan(ctx, &c)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, fmt.Errorf("%w: %s", models.ErrCollectionNotFound, name)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read collection %s: %w", name, err)
+	}
+	info := c.info()
+	return &info, nil
+}
+
+// ListCollections returns every registered collection with its chunk count
+func ListCollections(ctx context.Context, db *bun.DB) ([]models.CollectionInfo, error) {
+	var rows []Collection
+	if err := collectionQuery(db).Order("c.name").Scan(ctx, &rows); err != nil {
+		return nil, fmt.Errorf("failed to list collections: %w", err)
+	}
+	infos := make([]models.CollectionInfo, len(rows))
+	for i, c := range rows {
+		infos[i] = c.info()
+	}
+	return infos, nil
+}
+
+func collectionQuery(db *bun.DB) *bun.SelectQuery {
+	return db.NewSelect().
+		Model((*Collection)(nil)).
+		Column("c.name", "c.embedding_model", "c.dimension", "c.created_at").
+		ColumnExpr("(SELECT count(*) FROM documents AS d WHERE d.collection = c.name) AS chunks")
+}
+
+// DropCollection deletes a collection's documents, vector index and registration
+func DropCollection(ctx context.Context, db *bun.DB, name string) error {
+	if err := dropVectorIndexes(ctx, db, collectionIndexPrefix(name), ""); err != nil {
+		return err
+	}
+	return db.RunInTx(ctx, nil, func(ctx context.Context, tx bun.Tx) error {
+		// documents go with their sources, see the foreign key
+		_, err := tx.NewDelete().
+			Model((*Source)(nil)).
+			Where("collection = ?", name).
+			Exec(ctx)
+		if err != nil {
+			return fmt.Errorf("failed to delete documents of %s: %w", name, err)
+		}
+		res, err := tx.NewDelete().
+			Model((*Collection)(nil)).
+			Where("name = ?", name).
+			Exec(ctx)
+		if err != nil {
+			return fmt.Errorf("failed to drop collection %s: %w", name, err)
+		}
+		if n, _ := res.RowsAffected(); n == 0 {
+			return fmt.Errorf("%w: %s", models.ErrCollectionNotFound, name)
+		}
+		return nil
+	})
+}
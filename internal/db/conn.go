@@ -0,0 +1,155 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"document-rag/internal/config"
+
+	"github.com/rs/zerolog/log"
+	"github.com/uptrace/bun/driver/pgdriver"
+)
+
+const (
+	defaultPort           = "5432"
+	defaultMaxOpenConns   = 10
+	defaultMaxIdleConns   = 5
+	defaultConnLifetime   = 30 * time.Minute
+	defaultConnIdleTime   = 5 * time.Minute
+	defaultConnectTimeout = 5 * time.Second
+	defaultRetries        = 5
+	defaultRetryDelay     = time.Second
+	maxRetryDelay         = 30 * time.Second
+	// socket read deadline of the driver, raised to outlast the statement timeout
+	defaultReadTimeout = 10 * time.Second
+)
+
+// ConnectDB opens a connection pool to the database of the config and pings
+// it until it answers, retrying with a doubling delay.
+//
+// The driver formats query arguments on the client and sends every query as a
+// simple query, it never creates prepared statements, so the pool can run
+// behind pgbouncer in transaction mode. With pgbouncer set, no session
+// parameters are set on connections either: a session SET would stay on a
+// server connection other clients share.
+func ConnectDB(ctx context.Context, cfg *config.DbConfig) (*sql.DB, error) {
+	dsn, err := dataSourceName(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	connectTimeout := orDefault(cfg.ConnectTimeout, defaultConnectTimeout)
+	opts := []pgdriver.Option{
+		pgdriver.WithDSN(dsn),
+		pgdriver.WithDialTimeout(connectTimeout),
+		pgdriver.WithApplicationName("document-rag"),
+	}
+	if cfg.Password != "" {
+		opts = append(opts, pgdriver.WithPassword(cfg.Password))
+	}
+	if cfg.StatementTimeout > 0 {
+		if cfg.PgBouncer {
+			log.Warn().Msg("statement_timeout is not set per session behind pgbouncer, set it on the database role instead")
+		} else {
+			opts = append(opts, pgdriver.WithConnParams(map[string]interface{}{
+				"statement_timeout": strconv.FormatInt(cfg.StatementTimeout.Milliseconds(), 10),
+			}))
+		}
+		// let the server cancel a slow statement before the socket gives up on it
+		opts = append(opts, pgdriver.WithReadTimeout(max(defaultReadTimeout, cfg.StatementTimeout+connectTimeout)))
+	}
+
+	sqldb := sql.OpenDB(pgdriver.NewConnector(opts...))
+	sqldb.SetMaxOpenConns(orDefault(cfg.Pool.MaxOpenConns, defaultMaxOpenConns))
+	sqldb.SetMaxIdleConns(orDefault(cfg.Pool.MaxIdleConns, defaultMaxIdleConns))
+	sqldb.SetConnMaxLifetime(orDefault(cfg.Pool.ConnMaxLifetime, defaultConnLifetime))
+	sqldb.SetConnMaxIdleTime(orDefault(cfg.Pool.ConnMaxIdleTime, defaultConnIdleTime))
+
+	if err := ping(ctx, sqldb, cfg); err != nil {
+		sqldb.Close()
+		return nil, err
+	}
+	return sqldb, nil
+}
+
+// dataSourceName returns the DSN of the config, built from its fields when it
+// has none. sslmode and sslrootcert are added to a DSN that does not set them.
+func dataSourceName(cfg *config.DbConfig) (string, error) {
+	var u *url.URL
+	if cfg.DSN != "" {
+		var err error
+		if u, err = url.Parse(cfg.DSN); err != nil {
+			return "", fmt.Errorf("invalid database dsn: %w", err)
+		}
+	} else {
+		if cfg.Host == "" || cfg.User == "" || cfg.Database == "" {
+			return "", fmt.Errorf("database host, user and database are required without a dsn")
+		}
+		port := cfg.Port
+		if port == "" {
+			port = defaultPort
+		}
+		u = &url.URL{
+			Scheme: "postgresql",
+			User:   url.User(cfg.User),
+			Host:   net.JoinHostPort(cfg.Host, port),
+			Path:   "/" + cfg.Database,
+		}
+	}
+
+	q := u.Query()
+	if q.Get("sslmode") == "" {
+		host := u.Hostname()
+		if h := q.Get("host"); h != "" {
+			host = h
+		}
+		q.Set("sslmode", sslMode(cfg, host))
+	}
+	if q.Get("sslrootcert") == "" && cfg.SSLRootCert != "" {
+		q.Set("sslrootcert", cfg.SSLRootCert)
+	}
+	u.RawQuery = q.Encode()
+	return u.String(), nil
+}
+
+// sslMode returns the configured sslmode, by default TLS for every server but
+// a local one or a unix socket
+func sslMode(cfg *config.DbConfig, host string) string {
+	if cfg.SSLMode != "" {
+		return cfg.SSLMode
+	}
+	if host == "" || strings.HasPrefix(host, "/") || host == "localhost" || net.ParseIP(host).IsLoopback() {
+		return "disable"
+	}
+	return "require"
+}
+
+// ping checks the database answers, retrying while it starts up
+func ping(ctx context.Context, sqldb *sql.DB, cfg *config.DbConfig) error {
+	retries := orDefault(cfg.ConnectRetries, defaultRetries)
+	delay := orDefault(cfg.RetryDelay, defaultRetryDelay)
+
+	for attempt := 1; ; attempt++ {
+		err := sqldb.PingContext(ctx)
+		if err == nil {
+			return nil
+		}
+		if attempt > retries {
+			return fmt.Errorf("database not reachable after %d attempts: %w", attempt, err)
+		}
+		log.Warn().Err(err).Msgf("Database not reachable, retrying in %s (%d/%d)", delay, attempt, retries)
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(delay):
+		}
+		delay = min(2*delay, maxRetryDelay)
+	}
+}
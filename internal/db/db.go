@@ -4,24 +4,37 @@ import (
 	"context"
 	"database/sql"
 	"fmt"
+	"time"
 
-	"document-rag/internal/config"
+	"document-rag/internal/filter"
+	"document-rag/internal/models"
 
-	_ "github.com/lib/pq"
+	"github.com/rs/zerolog/log"
 	"github.com/uptrace/bun"
 	"github.com/uptrace/bun/dialect/pgdialect"
-	"github.com/uptrace/bun/driver/pgdriver"
 	"github.com/uptrace/bun/extra/bundebug"
 )
 
 type Document struct {
-	bun.BaseModel  `bun:"table:documents,alias:d"`
-	ID             int64     `bun:"id,pk,autoincrement"`
-	Content        string    `bun:"content,notnull"`
-	Embedding      []float32 `bun:"embedding,notnull"`
-	SourceFilename string    `bun:"source_filename,notnull"`
-	PageNumber     int       `bun:"page_number"` // Nullable for non-paged formats
-	ChunkID        int       `bun:"chunk_id,notnull"`
+	bun.BaseModel `bun:"table:documents,alias:d"`
+	ID            int64             `bun:"id,pk,autoincrement"`
+	Collection    string            `bun:"collection,notnull,default:'default'"`
+	DocID         string            `bun:"doc_id,notnull"`
+	Content       string            `bun:"content,notnull"`
+	Embedding     []float32         `bun:"embedding,notnull"`
+	SourceID      int64             `bun:"source_id,notnull"`
+	PageNumber    int               `bun:"page_number"` // Nullable for non-paged formats
+	ChunkID       int               `bun:"chunk_id,notnull"`
+	Metadata      map[string]string `bun:"metadata,type:jsonb,notnull,default:'{}'"`
+	ContentHash   string            `bun:"content_hash"`
+	CreatedAt     time.Time         `bun:"created_at,nullzero,notnull,default:current_timestamp"`
+	UpdatedAt     time.Time         `bun:"updated_at,nullzero,notnull,default:current_timestamp"`
+	Distance      float64           `bun:"distance,scanonly"`
+	Rank          float64           `bun:"rank,scanonly"`
+	// source fields joined from the sources table, SourceFilename also
+	// names the source of documents to store
+	SourceFilename string `bun:"source_filename,scanonly"`
+	SourceHash     string `bun:"source_hash,scanonly"`
 }
 
 func NewDB(sqldb *sql.DB, isVerbose bool) *bun.DB {
@@ -31,94 +44,132 @@ func NewDB(sqldb *sql.DB, isVerbose bool) *bun.DB {
 	return db
 }
 
-func ConnectDB(cfg *config.DbConfig) (*sql.DB, error) {
-	supabaseURL := fmt.Sprintf("postgresql://%s@%s:%s/%s", cfg.User, cfg.Host, cfg.Port, cfg.Database)
-	dsn := supabaseURL + "?sslmode=disable"
-
-	return sql.OpenDB(pgdriver.NewConnector(pgdriver.WithDSN(dsn), pgdriver.WithPassword(cfg.Password))), nil
-}
-
-func InitDB(ctx context.Context, db *bun.DB, vectorSize int) error {
-	// Enable vector extension
-	_, err := db.Exec("CREATE EXTENSION IF NOT EXISTS vector")
+// InitDB brings the schema up to date by applying pending migrations.
+// Vector indexes are created per collection, see ensureCollection.
+func InitDB(ctx context.Context, db *bun.DB) error {
+	group, err := Migrate(ctx, db)
 	if err != nil {
-		return fmt.Errorf("failed to enable vector extension: %w", err)
+		return err
 	}
+	if !group.IsZero() {
+		log.Info().Msgf("Migrated schema to %s", group)
+	}
+	return nil
+}
 
-	// Create documents table using Bun's schema definition
-	_, err = db.NewCreateTable().
+// DeleteDocuments removes the documents with the given doc_ids
+func DeleteDocuments(ctx context.Context, db *bun.DB, collection string, docIDs []string) error {
+	if len(docIDs) == 0 {
+		return nil
+	}
+	_, err := db.NewDelete().
 		Model((*Document)(nil)).
-		IfNotExists().
+		Where("collection = ?", collection).
+		Where("doc_id IN (?)", bun.In(docIDs)).
 		Exec(ctx)
+	return err
+}
+
+// GetEmbeddings returns the embeddings of the documents with the given
+// doc_ids by doc_id
+func GetEmbeddings(ctx context.Context, db *bun.DB, collection string, docIDs []string) (map[string][]float32, error) {
+	embeddings := map[string][]float32{}
+	if len(docIDs) == 0 {
+		return embeddings, nil
+	}
+
+	var docs []Document
+	err := db.NewSelect().
+		Model(&docs).
+		Column("d.doc_id", "d.embedding").
+		Where("d.collection = ?", collection).
+		Where("d.doc_id IN (?)", bun.In(docIDs)).
+		Scan(ctx)
 	if err != nil {
-		return fmt.Errorf("failed to create documents table: %w", err)
+		return nil, fmt.Errorf("failed to read embeddings: %w", err)
 	}
+	for _, doc := range docs {
+		embeddings[doc.DocID] = doc.Embedding
+	}
+	return embeddings, nil
+}
 
-	// Check if embedding column needs to be updated
-	var columnType string
-	err = db.QueryRow(`
-		SELECT data_type 
-		FROM information_schema.columns 
-		WHERE table_name = 'documents' AND column_name = 'embedding'
-	`).Scan(&columnType)
-	if err != nil && err != sql.ErrNoRows {
-		return fmt.Errorf("failed to check embedding column type: %w", err)
+// searchDocuments returns the documents of a collection closest to
+// queryEmbedding under the metric of settings, with Distance set. A nil where
+// matches all documents.
+func searchDocuments(ctx context.Context, db *bun.DB, collection string, queryEmbedding []float32, limit int, where filter.Expr, settings indexSettings) ([]Document, error) {
+	whereSQL, whereArgs, err := whereFilter(where)
+	if err != nil {
+		return nil, err
 	}
 
-	// If column exists but isn't VECTOR, drop and recreate it
-	if columnType != "" && columnType != "vector" {
-		_, err = db.Exec(`
-		ALTER TABLE documents 
-		DROP COLUMN embedding,
-		ADD COLUMN embedding VECTOR(?)`,
-			vectorSize,
-		)
-		if err != nil {
-			return fmt.Errorf("failed to update embedding column to VECTOR(%d): %w", vectorSize, err)
+	var docs []Document
+	// index search parameters are session settings, scope them to a transaction
+	err = db.RunInTx(ctx, nil, func(ctx context.Context, tx bun.Tx) error {
+		if err := setSearchParams(ctx, tx, settings); err != nil {
+			return fmt.Errorf("failed to set index search parameters: %w", err)
 		}
-	} else if columnType == "" {
-		// If column doesn't exist, add it
-		_, err = db.Exec(
-			`ALTER TABLE documents ADD COLUMN embedding VECTOR(?)`,
-			vectorSize,
-		)
-		if err != nil {
-			return fmt.Errorf("failed to add embedding column: %w", err)
+		// the cast has to match the collection's index expression
+		embedding := embeddingExpr(len(queryEmbedding))
+		q := documentQuery(tx.NewSelect().Model(&docs)).
+			ColumnExpr("? ? ? AS distance", embedding, bun.Safe(settings.metric.operator()), queryEmbedding).
+			Where("d.collection = ?", collection)
+		if whereSQL != "" {
+			q = q.Where(whereSQL, whereArgs...)
 		}
-	}
-
-	return err
+		return q.
+			OrderExpr("? ? ?", embedding, bun.Safe(settings.metric.operator()), queryEmbedding).
+			Limit(limit).
+			Scan(ctx)
+	})
+	return docs, err
 }
 
-func StoreDocument(ctx context.Context, db *bun.DB, content string, embedding []float32) error {
-	doc := &Document{
-		Content:   content,
-		Embedding: embedding,
-	}
-	_, err := db.NewInsert().Model(doc).Exec(ctx)
-	return err
-}
+// textQuery turns a question into a tsquery matching any of its terms, so a
+// chunk does not need every word of the question to be found
+const textQuery = "replace(plainto_tsquery('english', ?)::text, ' & ', ' | ')::tsquery"
 
-func StoreDocuments(ctx context.Context, db *bun.DB, documents []Document) error {
-	_, err := db.NewInsert().Model(&documents).Exec(ctx)
-	return err
-}
+// TextSearchDocuments returns the documents of a collection matching text in
+// full-text search, with Rank set to the normalized ts_rank_cd in [0, 1).
+// A nil where matches all documents.
+func TextSearchDocuments(ctx context.Context, db *bun.DB, collection, text string, limit int, where filter.Expr) ([]Document, error) {
+	whereSQL, whereArgs, err := whereFilter(where)
+	if err != nil {
+		return nil, err
+	}
 
-func SearchDocuments(ctx context.Context, db *bun.DB, queryEmbedding []float32, limit int) ([]Document, error) {
 	var docs []Document
-	err := db.NewSelect().
-		Model(&docs).
-		Column("id", "content", "source_filename", "page_number", "chunk_id").
-		// OrderExpr("embedding <=> ?", queryEmbedding).
-		OrderExpr("embedding <-> ?", queryEmbedding).
+	// normalization 32 scales the rank to rank/(rank+1)
+	q := documentQuery(db.NewSelect().Model(&docs)).
+		ColumnExpr("ts_rank_cd(content_tsv, "+textQuery+", 32) AS rank", text).
+		Where("d.collection = ?", collection).
+		Where("content_tsv @@ "+textQuery, text)
+	if whereSQL != "" {
+		q = q.Where(whereSQL, whereArgs...)
+	}
+	err = q.
+		OrderExpr("rank DESC").
 		Limit(limit).
 		Scan(ctx)
 	return docs, err
 }
 
-// drop table documents
+// documentQuery selects the document columns returned by searches, with the
+// file name of their source
+func documentQuery(q *bun.SelectQuery) *bun.SelectQuery {
+	return q.
+		Column("d.id", "d.doc_id", "d.content", "d.source_id", "d.page_number", "d.chunk_id", "d.metadata").
+		ColumnExpr("s.path AS source_filename").
+		Join("JOIN sources AS s ON s.id = d.source_id")
+}
 
-func DropDocuments(ctx context.Context, db *bun.DB) error {
-	_, err := db.NewDropTable().Model((*Document)(nil)).IfExists().Exec(ctx)
-	return err
+func whereFilter(where filter.Expr) (string, []interface{}, error) {
+	if where == nil {
+		return "", nil, nil
+	}
+	whereSQL, whereArgs, err := filterSQL(where)
+	if err != nil {
+		return "", nil, fmt.Errorf("%w: %w", models.ErrInvalidFilter, err)
+	}
+	return whereSQL, whereArgs, nil
 }
@@ -0,0 +1,139 @@
+package db
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"document-rag/internal/filter"
+
+	"github.com/uptrace/bun"
+)
+
+// filterColumn is a filter field stored as a table column
+type filterColumn struct {
+	name  string // qualified for the source join of search queries
+	isInt bool
+}
+
+// filter fields stored as table columns, every other field is a metadata key
+var filterColumns = map[string]filterColumn{
+	"doc_id":          {"d.doc_id", false},
+	"source_filename": {"s.path", false},
+	"content":         {"d.content", false},
+	"page_number":     {"d.page_number", true},
+	"chunk_id":        {"d.chunk_id", true},
+}
+
+// filterSQL translates a filter expression into a WHERE clause and its arguments
+func filterSQL(e filter.Expr) (string, []interface{}, error) {
+	switch e := e.(type) {
+	case filter.And:
+		return joinSQL(e.Terms, " AND ")
+	case filter.Or:
+		return joinSQL(e.Terms, " OR ")
+	case filter.Not:
+		clause, args, err := filterSQL(e.Term)
+		if err != nil {
+			return "", nil, err
+		}
+		// a comparison against a missing metadata key is NULL, count it as no match
+		return "NOT COALESCE((" + clause + "), false)", args, nil
+	case filter.Cond:
+		if column, ok := filterColumns[e.Field]; ok {
+			return columnCondSQL(e, column)
+		}
+		return metadataCondSQL(e)
+	default:
+		return "", nil, fmt.Errorf("unsupported filter expression %T", e)
+	}
+}
+
+func joinSQL(terms []filter.Expr, sep string) (string, []interface{}, error) {
+	clauses := make([]string, len(terms))
+	var args []interface{}
+	for i, t := range terms {
+		clause, termArgs, err := filterSQL(t)
+		if err != nil {
+			return "", nil, err
+		}
+		clauses[i] = "(" + clause + ")"
+		args = append(args, termArgs...)
+	}
+	return strings.Join(clauses, sep), args, nil
+}
+
+func columnCondSQL(c filter.Cond, col filterColumn) (string, []interface{}, error) {
+	column := bun.Ident(col.name)
+
+	values := make([]interface{}, len(c.Values))
+	for i, v := range c.Values {
+		if !col.isInt {
+			values[i] = v
+			continue
+		}
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			return "", nil, fmt.Errorf("filter field %s expects an integer, got %q", c.Field, v)
+		}
+		values[i] = n
+	}
+
+	switch c.Op {
+	case filter.OpIn:
+		return "? IN (?)", []interface{}{column, bun.In(values)}, nil
+	case filter.OpContains:
+		return "strpos(?::text, ?) > 0", []interface{}{column, c.Value()}, nil
+	case filter.OpEq, filter.OpNe:
+		op := string(c.Op)
+		if op == "!=" {
+			op = "<>"
+		}
+		return "? " + op + " ?", []interface{}{column, values[0]}, nil
+	case filter.OpLt, filter.OpLe, filter.OpGt, filter.OpGe:
+		switch {
+		case col.isInt:
+			return "? " + string(c.Op) + " ?", []interface{}{column, values[0]}, nil
+		case filter.IsNumber(c.Value()):
+			return numericCondSQL(column, c)
+		}
+		// text orders by bytes as in filter.Match, whatever the database collation
+		return "? COLLATE \"C\" " + string(c.Op) + " ?", []interface{}{column, values[0]}, nil
+	default:
+		return "", nil, fmt.Errorf("unsupported filter operator %s", c.Op)
+	}
+}
+
+func metadataCondSQL(c filter.Cond) (string, []interface{}, error) {
+	switch c.Op {
+	case filter.OpEq:
+		// containment can use the GIN index on metadata
+		doc, err := json.Marshal(map[string]string{c.Field: c.Value()})
+		if err != nil {
+			return "", nil, err
+		}
+		return "d.metadata @> ?::jsonb", []interface{}{string(doc)}, nil
+	case filter.OpNe:
+		return "d.metadata->>? IS DISTINCT FROM ?", []interface{}{c.Field, c.Value()}, nil
+	case filter.OpIn:
+		return "d.metadata->>? IN (?)", []interface{}{c.Field, bun.In(c.Values)}, nil
+	case filter.OpContains:
+		return "strpos(d.metadata->>?, ?) > 0", []interface{}{c.Field, c.Value()}, nil
+	case filter.OpLt, filter.OpLe, filter.OpGt, filter.OpGe:
+		value := bun.SafeQuery("d.metadata->>?", c.Field)
+		if filter.IsNumber(c.Value()) {
+			return numericCondSQL(value, c)
+		}
+		return "(?) COLLATE \"C\" " + string(c.Op) + " ?", []interface{}{value, c.Value()}, nil
+	default:
+		return "", nil, fmt.Errorf("unsupported filter operator %s", c.Op)
+	}
+}
+
+// numericCondSQL compares the numbers of filter.NumberPattern numerically,
+// any other value of the field is NULL and matches nothing as in filter.Match
+func numericCondSQL(field interface{}, c filter.Cond) (string, []interface{}, error) {
+	return "(CASE WHEN (?)::text ~ ? THEN (?)::text::numeric END) " + string(c.Op) + " ?::numeric",
+		[]interface{}{field, filter.NumberPattern, field, strings.TrimSpace(c.Value())}, nil
+}
@@ -0,0 +1,234 @@
+package db
+
+import (
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"strings"
+
+	"document-rag/internal/config"
+	"document-rag/internal/models"
+
+	"github.com/rs/zerolog/log"
+	"github.com/uptrace/bun"
+)
+
+// Metric is the distance function used to build the ANN index and order results
+type Metric string
+
+const (
+	MetricCosine       Metric = "cosine"
+	MetricL2           Metric = "l2"
+	MetricInnerProduct Metric = "inner_product"
+)
+
+const (
+	IndexHNSW    = "hnsw"
+	IndexIVFFlat = "ivfflat"
+	IndexNone    = "none"
+)
+
+// pgvector defaults
+const (
+	defaultM              = 16
+	defaultEfConstruction = 64
+	defaultLists          = 100
+	defaultEfSearch       = 40
+	defaultProbes         = 1
+)
+
+// ANN indexes of a collection are named docemb_<collection hash>_...
+const vectorIndexPrefix = "docemb_"
+
+// ParseMetric validates a metric name, empty means cosine
+func ParseMetric(name string) (Metric, error) {
+	switch Metric(strings.ToLower(name)) {
+	case MetricCosine, "":
+		return MetricCosine, nil
+	case MetricL2:
+		return MetricL2, nil
+	case MetricInnerProduct, "ip":
+		return MetricInnerProduct, nil
+	default:
+		return "", fmt.Errorf("unsupported distance metric: %s", name)
+	}
+}
+
+// operator returns the pgvector distance operator for the metric
+func (m Metric) operator() string {
+	switch m {
+	case MetricL2:
+		return "<->"
+	case MetricInnerProduct:
+		return "<#>"
+	default:
+		return "<=>"
+	}
+}
+
+// opclass returns the pgvector operator class the index has to be built with
+func (m Metric) opclass() string {
+	switch m {
+	case MetricL2:
+		return "vector_l2_ops"
+	case MetricInnerProduct:
+		return "vector_ip_ops"
+	default:
+		return "vector_cosine_ops"
+	}
+}
+
+// score turns a distance returned by the metric operator into a similarity
+// in [0, 1], higher is better
+func (m Metric) score(distance float64) float32 {
+	switch m {
+	case MetricL2:
+		return models.Similarity(1 / (1 + distance))
+	case MetricInnerProduct:
+		// <#> returns the negative inner product, the cosine for normalized embeddings
+		return models.Similarity(-distance)
+	default:
+		return models.Similarity(1 - distance)
+	}
+}
+
+// indexSettings is a VectorIndexConfig with defaults applied
+type indexSettings struct {
+	indexType      string
+	metric         Metric
+	m              int
+	efConstruction int
+	lists          int
+	efSearch       int
+	probes         int
+}
+
+func newIndexSettings(cfg *config.VectorIndexConfig) (indexSettings, error) {
+	if cfg == nil {
+		cfg = &config.VectorIndexConfig{}
+	}
+
+	metric, err := ParseMetric(cfg.Metric)
+	if err != nil {
+		return indexSettings{}, err
+	}
+
+	s := indexSettings{
+		indexType:      strings.ToLower(cfg.Type),
+		metric:         metric,
+		m:              orDefault(cfg.M, defaultM),
+		efConstruction: orDefault(cfg.EfConstruction, defaultEfConstruction),
+		lists:          orDefault(cfg.Lists, defaultLists),
+		efSearch:       orDefault(cfg.EfSearch, defaultEfSearch),
+		probes:         orDefault(cfg.Probes, defaultProbes),
+	}
+
+	switch s.indexType {
+	case "":
+		s.indexType = IndexHNSW
+	case IndexHNSW, IndexIVFFlat, IndexNone:
+	default:
+		return indexSettings{}, fmt.Errorf("unsupported vector index type: %s", cfg.Type)
+	}
+	return s, nil
+}
+
+// embeddingExpr casts the dimensionless embedding column to the collection's
+// dimension, which pgvector needs to index and order it
+func embeddingExpr(dimension int) bun.Safe {
+	return bun.Safe(fmt.Sprintf("embedding::vector(%d)", dimension))
+}
+
+// collectionIndexPrefix keeps index names of any collection within the
+// 63 byte identifier limit
+func collectionIndexPrefix(collection string) string {
+	sum := sha1.Sum([]byte(collection))
+	return vectorIndexPrefix + hex.EncodeToString(sum[:])[:10] + "_"
+}
+
+// indexName encodes collection, type, metric and build parameters, so a
+// config change yields a new name and the stale index gets replaced
+func (s indexSettings) indexName(collection string, dimension int) string {
+	prefix := collectionIndexPrefix(collection)
+	switch s.indexType {
+	case IndexHNSW:
+		return fmt.Sprintf("%shnsw_%s_d%d_m%d_ef%d", prefix, s.metric, dimension, s.m, s.efConstruction)
+	case IndexIVFFlat:
+		return fmt.Sprintf("%sivfflat_%s_d%d_l%d", prefix, s.metric, dimension, s.lists)
+	default:
+		return ""
+	}
+}
+
+// ensureVectorIndex creates the configured ANN index of a collection, a
+// partial index over its rows, and drops any other index of the collection
+func ensureVectorIndex(ctx context.Context, db *bun.DB, collection string, dimension int, s indexSettings) error {
+	name := s.indexName(collection, dimension)
+
+	if err := dropVectorIndexes(ctx, db, collectionIndexPrefix(collection), name); err != nil {
+		return err
+	}
+
+	var err error
+	switch s.indexType {
+	case IndexHNSW:
+		_, err = db.ExecContext(ctx,
+			"CREATE INDEX IF NOT EXISTS ? ON documents USING hnsw ((?) ?) WITH (m = ?, ef_construction = ?) WHERE collection = ?",
+			bun.Ident(name), embeddingExpr(dimension), bun.Safe(s.metric.opclass()), s.m, s.efConstruction, collection)
+	case IndexIVFFlat:
+		// ivfflat derives its centroids from the rows present at build time,
+		// build it after the initial load for good recall
+		_, err = db.ExecContext(ctx,
+			"CREATE INDEX IF NOT EXISTS ? ON documents USING ivfflat ((?) ?) WITH (lists = ?) WHERE collection = ?",
+			bun.Ident(name), embeddingExpr(dimension), bun.Safe(s.metric.opclass()), s.lists, collection)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to create %s vector index: %w", s.indexType, err)
+	}
+	return nil
+}
+
+// dropVectorIndexes drops the indexes on documents starting with prefix, except keep
+func dropVectorIndexes(ctx context.Context, db *bun.DB, prefix, keep string) error {
+	var existing []string
+	err := db.NewSelect().
+		Table("pg_indexes").
+		Column("indexname").
+		Where("tablename = ?", "documents").
+		Where("starts_with(indexname, ?)", prefix).
+		Scan(ctx, &existing)
+	if err != nil {
+		return fmt.Errorf("failed to list vector indexes: %w", err)
+	}
+
+	for _, idx := range existing {
+		if idx == keep {
+			continue
+		}
+		log.Info().Msgf("Dropping stale vector index %s", idx)
+		if _, err := db.ExecContext(ctx, "DROP INDEX IF EXISTS ?", bun.Ident(idx)); err != nil {
+			return fmt.Errorf("failed to drop vector index %s: %w", idx, err)
+		}
+	}
+	return nil
+}
+
+// setSearchParams applies the query-time index parameters to the transaction
+func setSearchParams(ctx context.Context, tx bun.Tx, s indexSettings) error {
+	var err error
+	switch s.indexType {
+	case IndexHNSW:
+		_, err = tx.ExecContext(ctx, "SET LOCAL hnsw.ef_search = ?", s.efSearch)
+	case IndexIVFFlat:
+		_, err = tx.ExecContext(ctx, "SET LOCAL ivfflat.probes = ?", s.probes)
+	}
+	return err
+}
+
+func orDefault[T ~int | ~int64](v, def T) T {
+	if v > 0 {
+		return v
+	}
+	return def
+}
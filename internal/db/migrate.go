@@ -0,0 +1,76 @@
+package db
+
+import (
+	"context"
+	"fmt"
+
+	"document-rag/internal/db/migrations"
+
+	"github.com/uptrace/bun"
+	"github.com/uptrace/bun/migrate"
+)
+
+// migrationLockID keys the advisory lock that serializes migration runs
+const migrationLockID = 7_204_915_308
+
+func newMigrator(db *bun.DB) *migrate.Migrator {
+	return migrate.NewMigrator(db, migrations.Migrations, migrate.WithMarkAppliedOnSuccess(true))
+}
+
+// Migrate applies every pending migration as one group
+func Migrate(ctx context.Context, db *bun.DB) (*migrate.MigrationGroup, error) {
+	var group *migrate.MigrationGroup
+	err := withMigrationLock(ctx, db, func(m *migrate.Migrator) error {
+		var err error
+		group, err = m.Migrate(ctx)
+		return err
+	})
+	if err != nil {
+		return group, fmt.Errorf("failed to migrate schema: %w", err)
+	}
+	return group, nil
+}
+
+// Rollback reverts the last applied migration group
+func Rollback(ctx context.Context, db *bun.DB) (*migrate.MigrationGroup, error) {
+	var group *migrate.MigrationGroup
+	err := withMigrationLock(ctx, db, func(m *migrate.Migrator) error {
+		var err error
+		group, err = m.Rollback(ctx)
+		return err
+	})
+	if err != nil {
+		return group, fmt.Errorf("failed to roll back schema: %w", err)
+	}
+	return group, nil
+}
+
+// MigrationStatus returns every known migration, applied ones have an ID
+func MigrationStatus(ctx context.Context, db *bun.DB) (migrate.MigrationSlice, error) {
+	m := newMigrator(db)
+	if err := m.Init(ctx); err != nil {
+		return nil, fmt.Errorf("failed to create migrations table: %w", err)
+	}
+	return m.MigrationsWithStatus(ctx)
+}
+
+// withMigrationLock runs fn while holding a session advisory lock, which
+// Postgres releases on its own if the process dies mid-migration
+func withMigrationLock(ctx context.Context, db *bun.DB, fn func(m *migrate.Migrator) error) error {
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	if _, err := conn.ExecContext(ctx, "SELECT pg_advisory_lock(?)", migrationLockID); err != nil {
+		return fmt.Errorf("failed to lock migrations: %w", err)
+	}
+	defer conn.ExecContext(context.Background(), "SELECT pg_advisory_unlock(?)", migrationLockID)
+
+	m := newMigrator(db)
+	if err := m.Init(ctx); err != nil {
+		return fmt.Errorf("failed to create migrations table: %w", err)
+	}
+	return fn(m)
+}
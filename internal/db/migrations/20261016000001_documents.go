@@ -0,0 +1,68 @@
+package migrations
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+
+	"github.com/uptrace/bun"
+)
+
+// The documents table as first created by InitDB. Databases created before
+// migrations existed already have it, so every step is conditional.
+func init() {
+	Migrations.MustRegister(func(ctx context.Context, db *bun.DB) error {
+		err := execTx(ctx, db,
+			`CREATE EXTENSION IF NOT EXISTS vector`,
+			`CREATE TABLE IF NOT EXISTS documents (
+				id BIGSERIAL PRIMARY KEY,
+				content VARCHAR NOT NULL,
+				embedding VECTOR,
+				source_filename VARCHAR NOT NULL,
+				page_number BIGINT,
+				chunk_id BIGINT NOT NULL
+			)`)
+		if err != nil {
+			return fmt.Errorf("failed to create documents table: %w", err)
+		}
+		return ensureVectorColumn(ctx, db)
+	}, func(ctx context.Context, db *bun.DB) error {
+		if err := refuseIfRows(ctx, db, "TRUE", "drop the documents table"); err != nil {
+			return err
+		}
+		return execTx(ctx, db, `DROP TABLE IF EXISTS documents`)
+	})
+}
+
+// ensureVectorColumn turns an embedding column created by bun with a generic
+// type into a vector column. That is only done while the table is empty, a
+// column holding data is never dropped.
+func ensureVectorColumn(ctx context.Context, db *bun.DB) error {
+	var typeName string
+	err := db.QueryRowContext(ctx, `
+		SELECT t.typname
+		FROM pg_attribute a
+		JOIN pg_type t ON t.oid = a.atttypid
+		WHERE a.attrelid = 'documents'::regclass
+		AND a.attname = 'embedding'
+		AND NOT a.attisdropped
+	`).Scan(&typeName)
+
+	switch {
+	case err == sql.ErrNoRows:
+		return execTx(ctx, db, `ALTER TABLE documents ADD COLUMN embedding VECTOR`)
+	case err != nil:
+		return fmt.Errorf("failed to check embedding column type: %w", err)
+	case typeName == "vector":
+		return nil
+	}
+
+	n, err := countRows(ctx, db, "TRUE")
+	if err != nil {
+		return err
+	}
+	if n > 0 {
+		return fmt.Errorf("documents.embedding has type %s and holds %d rows, convert it to vector manually", typeName, n)
+	}
+	return execTx(ctx, db, `ALTER TABLE documents DROP COLUMN embedding, ADD COLUMN embedding VECTOR`)
+}
@@ -0,0 +1,32 @@
+package migrations
+
+import (
+	"context"
+
+	"github.com/uptrace/bun"
+)
+
+// Stable chunk IDs for upserts, backfilled from the positional chunk key. A
+// row without a page, as stored for non-paged formats, gets page 0.
+func init() {
+	Migrations.MustRegister(func(ctx context.Context, db *bun.DB) error {
+		queries := []string{
+			`ALTER TABLE documents ADD COLUMN IF NOT EXISTS doc_id VARCHAR`,
+			`UPDATE documents
+			SET doc_id = coalesce(source_filename, '') || '-' || coalesce(page_number::text, '0') || '-' || coalesce(chunk_id::text, '0')
+			WHERE doc_id IS NULL`,
+			`ALTER TABLE documents ALTER COLUMN doc_id SET NOT NULL`,
+		}
+		// a schema that already has collections keys chunks per collection
+		scoped, err := indexExists(ctx, db, "documents_collection_doc_id_key")
+		if err != nil {
+			return err
+		}
+		if !scoped {
+			queries = append(queries, `CREATE UNIQUE INDEX IF NOT EXISTS documents_doc_id_key ON documents (doc_id)`)
+		}
+		return execTx(ctx, db, queries...)
+	}, func(ctx context.Context, db *bun.DB) error {
+		return execTx(ctx, db, `ALTER TABLE documents DROP COLUMN IF EXISTS doc_id`)
+	})
+}
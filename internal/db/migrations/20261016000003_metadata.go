@@ -0,0 +1,20 @@
+package migrations
+
+import (
+	"context"
+
+	"github.com/uptrace/bun"
+)
+
+// Free-form chunk metadata, indexed for containment filters
+func init() {
+	Migrations.MustRegister(func(ctx context.Context, db *bun.DB) error {
+		return execTx(ctx, db,
+			`ALTER TABLE documents ADD COLUMN IF NOT EXISTS metadata JSONB NOT NULL DEFAULT '{}'`,
+			`CREATE INDEX IF NOT EXISTS documents_metadata_idx ON documents USING gin (metadata jsonb_path_ops)`)
+	}, func(ctx context.Context, db *bun.DB) error {
+		return execTx(ctx, db,
+			`DROP INDEX IF EXISTS documents_metadata_idx`,
+			`ALTER TABLE documents DROP COLUMN IF EXISTS metadata`)
+	})
+}
@@ -0,0 +1,22 @@
+package migrations
+
+import (
+	"context"
+
+	"github.com/uptrace/bun"
+)
+
+// Content and source hashes for incremental re-ingestion, looked up per source
+func init() {
+	Migrations.MustRegister(func(ctx context.Context, db *bun.DB) error {
+		return execTx(ctx, db,
+			`ALTER TABLE documents
+			ADD COLUMN IF NOT EXISTS content_hash VARCHAR,
+			ADD COLUMN IF NOT EXISTS source_hash VARCHAR`,
+			`CREATE INDEX IF NOT EXISTS documents_source_filename_idx ON documents (source_filename)`)
+	}, func(ctx context.Context, db *bun.DB) error {
+		return execTx(ctx, db,
+			`DROP INDEX IF EXISTS documents_source_filename_idx`,
+			`ALTER TABLE documents DROP COLUMN IF EXISTS content_hash, DROP COLUMN IF EXISTS source_hash`)
+	})
+}
@@ -0,0 +1,134 @@
+package migrations
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+
+	"github.com/uptrace/bun"
+)
+
+// Named collections sharing the documents table. The embedding column loses
+// its fixed dimension in place, the dimension moves to the collections
+// table, and rows stored before belong to the default collection.
+func init() {
+	Migrations.MustRegister(func(ctx context.Context, db *bun.DB) error {
+		dimension, err := embeddingDimension(ctx, db)
+		if err != nil {
+			return err
+		}
+
+		return db.RunInTx(ctx, nil, func(ctx context.Context, tx bun.Tx) error {
+			queries := []string{
+				`CREATE TABLE IF NOT EXISTS collections (
+					name VARCHAR PRIMARY KEY,
+					embedding_model VARCHAR NOT NULL DEFAULT '',
+					dimension BIGINT NOT NULL,
+					created_at TIMESTAMPTZ NOT NULL DEFAULT current_timestamp
+				)`,
+				`ALTER TABLE documents ADD COLUMN IF NOT EXISTS collection VARCHAR NOT NULL DEFAULT 'default'`,
+				`ALTER TABLE documents DROP CONSTRAINT IF EXISTS documents_doc_id_key`,
+				`DROP INDEX IF EXISTS documents_doc_id_key`,
+				`CREATE UNIQUE INDEX IF NOT EXISTS documents_collection_doc_id_key ON documents (collection, doc_id)`,
+				`DROP INDEX IF EXISTS documents_source_filename_idx`,
+				`CREATE INDEX IF NOT EXISTS documents_collection_source_filename_idx ON documents (collection, source_filename)`,
+			}
+			for _, q := range queries {
+				if _, err := tx.ExecContext(ctx, q); err != nil {
+					return err
+				}
+			}
+
+			if dimension > 0 {
+				_, err := tx.ExecContext(ctx, `
+					INSERT INTO collections (name, dimension) VALUES ('default', ?)
+					ON CONFLICT (name) DO NOTHING`, dimension)
+				if err != nil {
+					return fmt.Errorf("failed to register the default collection: %w", err)
+				}
+				if _, err := tx.ExecContext(ctx, `ALTER TABLE documents ALTER COLUMN embedding TYPE VECTOR`); err != nil {
+					return fmt.Errorf("failed to relax embedding column dimension: %w", err)
+				}
+			}
+
+			// table-wide ANN indexes cannot serve per-collection queries,
+			// collections build their own on open
+			return dropIndexes(ctx, tx, "documents_embedding_")
+		})
+	}, func(ctx context.Context, db *bun.DB) error {
+		if err := refuseIfRows(ctx, db, "collection <> 'default'", "merge collections back into one table"); err != nil {
+			return err
+		}
+
+		var dimension int
+		err := db.NewSelect().
+			Table("collections").
+			Column("dimension").
+			Where("name = 'default'").
+			Scan(ctx, &dimension)
+		if err != nil && !errors.Is(err, sql.ErrNoRows) {
+			return err
+		}
+
+		return db.RunInTx(ctx, nil, func(ctx context.Context, tx bun.Tx) error {
+			if err := dropIndexes(ctx, tx, "docemb_"); err != nil {
+				return err
+			}
+			queries := []string{
+				`DROP INDEX IF EXISTS documents_collection_source_filename_idx`,
+				`CREATE INDEX IF NOT EXISTS documents_source_filename_idx ON documents (source_filename)`,
+				`DROP INDEX IF EXISTS documents_collection_doc_id_key`,
+				`CREATE UNIQUE INDEX IF NOT EXISTS documents_doc_id_key ON documents (doc_id)`,
+				`ALTER TABLE documents DROP COLUMN IF EXISTS collection`,
+				`DROP TABLE IF EXISTS collections`,
+			}
+			for _, q := range queries {
+				if _, err := tx.ExecContext(ctx, q); err != nil {
+					return err
+				}
+			}
+			if dimension > 0 {
+				_, err := tx.ExecContext(ctx, "ALTER TABLE documents ALTER COLUMN embedding TYPE VECTOR(?)", dimension)
+				return err
+			}
+			return nil
+		})
+	})
+}
+
+// embeddingDimension returns the fixed dimension of the embedding column, 0 if it has none
+func embeddingDimension(ctx context.Context, db *bun.DB) (int, error) {
+	var dimension int
+	err := db.QueryRowContext(ctx, `
+		SELECT a.atttypmod
+		FROM pg_attribute a
+		WHERE a.attrelid = 'documents'::regclass
+		AND a.attname = 'embedding'
+		AND NOT a.attisdropped
+	`).Scan(&dimension)
+	if err != nil {
+		return 0, fmt.Errorf("failed to check embedding column type: %w", err)
+	}
+	return max(dimension, 0), nil
+}
+
+// dropIndexes drops the indexes on documents whose name starts with prefix
+func dropIndexes(ctx context.Context, tx bun.Tx, prefix string) error {
+	var names []string
+	err := tx.NewSelect().
+		Table("pg_indexes").
+		Column("indexname").
+		Where("tablename = ?", "documents").
+		Where("starts_with(indexname, ?)", prefix).
+		Scan(ctx, &names)
+	if err != nil {
+		return fmt.Errorf("failed to list indexes: %w", err)
+	}
+	for _, name := range names {
+		if _, err := tx.ExecContext(ctx, "DROP INDEX IF EXISTS ?", bun.Ident(name)); err != nil {
+			return fmt.Errorf("failed to drop index %s: %w", name, err)
+		}
+	}
+	return nil
+}
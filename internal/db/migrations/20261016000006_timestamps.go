@@ -0,0 +1,22 @@
+package migrations
+
+import (
+	"context"
+
+	"github.com/uptrace/bun"
+)
+
+// When a chunk was first stored and last replaced. Existing rows get the
+// time of the migration, the default is evaluated once and adding the
+// columns does not rewrite the table.
+func init() {
+	Migrations.MustRegister(func(ctx context.Context, db *bun.DB) error {
+		return execTx(ctx, db,
+			`ALTER TABLE documents
+			ADD COLUMN IF NOT EXISTS created_at TIMESTAMPTZ NOT NULL DEFAULT current_timestamp,
+			ADD COLUMN IF NOT EXISTS updated_at TIMESTAMPTZ NOT NULL DEFAULT current_timestamp`)
+	}, func(ctx context.Context, db *bun.DB) error {
+		return execTx(ctx, db,
+			`ALTER TABLE documents DROP COLUMN IF EXISTS created_at, DROP COLUMN IF EXISTS updated_at`)
+	})
+}
@@ -0,0 +1,23 @@
+package migrations
+
+import (
+	"context"
+
+	"github.com/uptrace/bun"
+)
+
+// Full-text search over chunk content. The generated column is filled for
+// existing rows in place, which rewrites the table but keeps every embedding.
+func init() {
+	Migrations.MustRegister(func(ctx context.Context, db *bun.DB) error {
+		return execTx(ctx, db,
+			`ALTER TABLE documents
+			ADD COLUMN IF NOT EXISTS content_tsv TSVECTOR
+			GENERATED ALWAYS AS (to_tsvector('english', content)) STORED`,
+			`CREATE INDEX IF NOT EXISTS documents_content_tsv_idx ON documents USING gin (content_tsv)`)
+	}, func(ctx context.Context, db *bun.DB) error {
+		return execTx(ctx, db,
+			`DROP INDEX IF EXISTS documents_content_tsv_idx`,
+			`ALTER TABLE documents DROP COLUMN IF EXISTS content_tsv`)
+	})
+}
@@ -0,0 +1,57 @@
+package migrations
+
+import (
+	"context"
+
+	"github.com/uptrace/bun"
+)
+
+// Source files move to their own table and chunks reference them, deleting a
+// source deletes its chunks. Existing sources are created from the chunks,
+// with the hash their chunks agree on and their highest page number. The
+// down migration copies the file name and hash back onto every chunk.
+func init() {
+	Migrations.MustRegister(func(ctx context.Context, db *bun.DB) error {
+		return execTx(ctx, db,
+			`CREATE TABLE IF NOT EXISTS sources (
+				id BIGSERIAL PRIMARY KEY,
+				collection VARCHAR NOT NULL,
+				path VARCHAR NOT NULL,
+				mime_type VARCHAR NOT NULL DEFAULT '',
+				size BIGINT NOT NULL DEFAULT 0,
+				checksum VARCHAR NOT NULL DEFAULT '',
+				page_count BIGINT NOT NULL DEFAULT 0,
+				ingested_at TIMESTAMPTZ NOT NULL DEFAULT current_timestamp,
+				created_at TIMESTAMPTZ NOT NULL DEFAULT current_timestamp,
+				UNIQUE (collection, path)
+			)`,
+			`INSERT INTO sources (collection, path, checksum, page_count, ingested_at, created_at)
+			SELECT collection, source_filename,
+				CASE WHEN count(DISTINCT coalesce(source_hash, '')) = 1 THEN coalesce(min(source_hash), '') ELSE '' END,
+				coalesce(max(page_number), 0), max(updated_at), min(created_at)
+			FROM documents
+			GROUP BY collection, source_filename
+			ON CONFLICT (collection, path) DO NOTHING`,
+			`ALTER TABLE documents ADD COLUMN IF NOT EXISTS source_id BIGINT REFERENCES sources (id) ON DELETE CASCADE`,
+			`UPDATE documents AS d SET source_id = s.id
+			FROM sources AS s
+			WHERE s.collection = d.collection AND s.path = d.source_filename AND d.source_id IS NULL`,
+			`ALTER TABLE documents ALTER COLUMN source_id SET NOT NULL`,
+			`CREATE INDEX IF NOT EXISTS documents_source_id_idx ON documents (source_id)`,
+			`DROP INDEX IF EXISTS documents_collection_source_filename_idx`,
+			`ALTER TABLE documents DROP COLUMN IF EXISTS source_filename, DROP COLUMN IF EXISTS source_hash`)
+	}, func(ctx context.Context, db *bun.DB) error {
+		return execTx(ctx, db,
+			`ALTER TABLE documents
+			ADD COLUMN IF NOT EXISTS source_filename VARCHAR,
+			ADD COLUMN IF NOT EXISTS source_hash VARCHAR`,
+			`UPDATE documents AS d SET source_filename = s.path, source_hash = s.checksum
+			FROM sources AS s
+			WHERE s.id = d.source_id`,
+			`ALTER TABLE documents ALTER COLUMN source_filename SET NOT NULL`,
+			`CREATE INDEX IF NOT EXISTS documents_collection_source_filename_idx ON documents (collection, source_filename)`,
+			`DROP INDEX IF EXISTS documents_source_id_idx`,
+			`ALTER TABLE documents DROP COLUMN IF EXISTS source_id`,
+			`DROP TABLE IF EXISTS sources`)
+	})
+}
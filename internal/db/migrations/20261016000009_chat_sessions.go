@@ -0,0 +1,25 @@
+package migrations
+
+import (
+	"context"
+
+	"github.com/uptrace/bun"
+)
+
+// Chat sessions continued across queries, their turns are kept as one JSON
+// array and replaced whole on every save
+func init() {
+	Migrations.MustRegister(func(ctx context.Context, db *bun.DB) error {
+		return execTx(ctx, db,
+			`CREATE TABLE IF NOT EXISTS chat_sessions (
+				id VARCHAR PRIMARY KEY,
+				turns JSONB NOT NULL DEFAULT '[]',
+				summary TEXT NOT NULL DEFAULT '',
+				summarized BIGINT NOT NULL DEFAULT 0,
+				created_at TIMESTAMPTZ NOT NULL DEFAULT current_timestamp,
+				updated_at TIMESTAMPTZ NOT NULL DEFAULT current_timestamp
+			)`)
+	}, func(ctx context.Context, db *bun.DB) error {
+		return execTx(ctx, db, `DROP TABLE IF EXISTS chat_sessions`)
+	})
+}
@@ -0,0 +1,62 @@
+// Package migrations holds the versioned schema of the Postgres store. Each
+// file registers one migration named after its file, see bun's migrate
+// package. Migrations only ever add or alter columns in place. A down
+// migration drops what its up migration added, losing derived data such as
+// chunk IDs, hashes and timestamps, but refuses to run rather than drop
+// stored embeddings.
+package migrations
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/uptrace/bun"
+	"github.com/uptrace/bun/migrate"
+)
+
+// Migrations is the ordered set of schema migrations
+var Migrations = migrate.NewMigrations()
+
+// execTx runs queries in a single transaction, Postgres DDL is transactional
+// so a failing migration leaves the schema untouched
+func execTx(ctx context.Context, db *bun.DB, queries ...string) error {
+	return db.RunInTx(ctx, nil, func(ctx context.Context, tx bun.Tx) error {
+		for _, q := range queries {
+			if _, err := tx.ExecContext(ctx, q); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// countRows returns the number of rows in documents matching where
+func countRows(ctx context.Context, db bun.IDB, where string) (int, error) {
+	var n int
+	err := db.NewSelect().
+		Table("documents").
+		ColumnExpr("count(*)").
+		Where(where).
+		Scan(ctx, &n)
+	return n, err
+}
+
+// refuseIfRows fails a down migration that would lose stored chunks
+func refuseIfRows(ctx context.Context, db bun.IDB, where, what string) error {
+	n, err := countRows(ctx, db, where)
+	if err != nil {
+		return err
+	}
+	if n > 0 {
+		return fmt.Errorf("refusing to %s, %d documents would be lost", what, n)
+	}
+	return nil
+}
+
+func indexExists(ctx context.Context, db bun.IDB, name string) (bool, error) {
+	return db.NewSelect().
+		Table("pg_indexes").
+		Where("tablename = ?", "documents").
+		Where("indexname = ?", name).
+		Exists(ctx)
+}
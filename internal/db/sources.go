@@ -0,0 +1,245 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"document-rag/internal/models"
+
+	"github.com/uptrace/bun"
+)
+
+// Source is an ingested file of a collection, its documents reference it and
+// are deleted with it
+type Source struct {
+	bun.BaseModel `bun:"table:sources,alias:s"`
+	ID            int64     `bun:"id,pk,autoincrement"`
+	Collection    string    `bun:"collection,notnull"`
+	Path          string    `bun:"path,notnull"`
+	MIMEType      string    `bun:"mime_type,notnull"`
+	Size          int64     `bun:"size,notnull"`
+	Checksum      string    `bun:"checksum,notnull"`
+	PageCount     int       `bun:"page_count,notnull"`
+	IngestedAt    time.Time `bun:"ingested_at,nullzero,notnull,default:current_timestamp"`
+	CreatedAt     time.Time `bun:"created_at,nullzero,notnull,default:current_timestamp"`
+	Chunks        int       `bun:"chunks,scanonly"`
+}
+
+func (s Source) info() models.SourceInfo {
+	return models.SourceInfo{
+		ID:         s.ID,
+		Name:       s.Path,
+		Hash:       s.Checksum,
+		MIMEType:   s.MIMEType,
+		Size:       s.Size,
+		PageCount:  s.PageCount,
+		Chunks:     s.Chunks,
+		IngestedAt: s.IngestedAt,
+	}
+}
+
+// ensureSources sets the SourceID of documents from their SourceFilename,
+// registering sources that do not exist yet. The file metadata of a new
+// source is filled in by SaveSource once its ingest completes.
+func ensureSources(ctx context.Context, db bun.IDB, documents []Document) error {
+	type key struct{ collection, path string }
+	ids := map[key]int64{}
+	for _, doc := range documents {
+		if doc.SourceID == 0 {
+			ids[key{collectionOrDefault(doc.Collection), doc.SourceFilename}] = 0
+		}
+	}
+
+	for k := range ids {
+		var id int64
+		// the no-op update makes RETURNING yield the id of an existing row
+		err := db.NewInsert().
+			Model(&Source{Collection: k.collection, Path: k.path}).
+			On("CONFLICT (collection, path) DO UPDATE").
+			Set("path = EXCLUDED.path").
+			Returning("id").
+			Scan(ctx, &id)
+		if err != nil {
+			return fmt.Errorf("failed to register source %s: %w", k.path, err)
+		}
+		ids[k] = id
+	}
+
+	for i := range documents {
+		if documents[i].SourceID == 0 {
+			documents[i].SourceID = ids[key{collectionOrDefault(documents[i].Collection), documents[i].SourceFilename}]
+		}
+	}
+	return nil
+}
+
+func collectionOrDefault(collection string) string {
+	if collection == "" {
+		return DefaultCollection
+	}
+	return collection
+}
+
+// SaveSource records a completed ingest of a source with its file metadata
+func SaveSource(ctx context.Context, db *bun.DB, collection string, src models.SourceInfo) error {
+	_, err := db.NewInsert().
+		Model(&Source{
+			Collection: collection,
+			Path:       src.Name,
+			MIMEType:   src.MIMEType,
+			Size:       src.Size,
+			Checksum:   src.Hash,
+			PageCount:  src.PageCount,
+			IngestedAt: time.Now().UTC(),
+		}).
+		On("CONFLICT (collection, path) DO UPDATE").
+		Set("mime_type = EXCLUDED.mime_type").
+		Set("size = EXCLUDED.size").
+		Set("checksum = EXCLUDED.checksum").
+		Set("page_count = EXCLUDED.page_count").
+		Set("ingested_at = EXCLUDED.ingested_at").
+		Exec(ctx)
+	return err
+}
+
+// GetSourceState returns the recorded source hash and chunk hashes of a source
+func GetSourceState(ctx context.Context, db *bun.DB, collection, source string) (models.SourceState, error) {
+	state := models.SourceState{Chunks: map[string]string{}}
+
+	err := db.NewSelect().
+		Model((*Source)(nil)).
+		Column("checksum").
+		Where("collection = ?", collection).
+		Where("path = ?", source).
+		Scan(ctx, &state.Hash)
+	if errors.Is(err, sql.ErrNoRows) {
+		return state, nil
+	}
+	if err != nil {
+		return models.SourceState{}, err
+	}
+
+	var docs []Document
+	err = db.NewSelect().
+		Model(&docs).
+		Column("d.doc_id", "d.content_hash").
+		Join("JOIN sources AS s ON s.id = d.source_id").
+		Where("s.collection = ?", collection).
+		Where("s.path = ?", source).
+		Scan(ctx)
+	if err != nil {
+		return models.SourceState{}, err
+	}
+	for _, doc := range docs {
+		state.Chunks[doc.DocID] = doc.ContentHash
+	}
+	return state, nil
+}
+
+// ListSources returns every source of a collection with its chunk count
+func ListSources(ctx context.Context, db *bun.DB, collection string) ([]models.SourceInfo, error) {
+	var rows []Source
+	err := sourceQuery(db).
+		Where("s.collection = ?", collection).
+		Order("s.path").
+		Scan(ctx, &rows)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list sources: %w", err)
+	}
+
+	sources := make([]models.SourceInfo, len(rows))
+	for i, row := range rows {
+		sources[i] = row.info()
+	}
+	return sources, nil
+}
+
+// GetSources returns the sources with the given IDs by ID
+func GetSources(ctx context.Context, db *bun.DB, ids []int64) (map[int64]models.SourceInfo, error) {
+	sources := map[int64]models.SourceInfo{}
+	if len(ids) == 0 {
+		return sources, nil
+	}
+
+	var rows []Source
+	err := sourceQuery(db).
+		Where("s.id IN (?)", bun.In(ids)).
+		Scan(ctx, &rows)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read sources: %w", err)
+	}
+	for _, row := range rows {
+		sources[row.ID] = row.info()
+	}
+	return sources, nil
+}
+
+func sourceQuery(db *bun.DB) *bun.SelectQuery {
+	return db.NewSelect().
+		Model((*Source)(nil)).
+		Column("s.id", "s.path", "s.mime_type", "s.size", "s.checksum", "s.page_count", "s.ingested_at").
+		ColumnExpr("(SELECT count(*) FROM documents AS d WHERE d.source_id = s.id) AS chunks")
+}
+
+// GetSourceDocuments returns the documents of a source in page and chunk
+// order, without embeddings
+func GetSourceDocuments(ctx context.Context, db *bun.DB, collection, source string) ([]Document, error) {
+	var docs []Document
+	err := db.NewSelect().
+		Model(&docs).
+		Column("d.id", "d.doc_id", "d.content", "d.source_id", "d.page_number", "d.chunk_id", "d.metadata",
+			"d.content_hash", "d.created_at", "d.updated_at").
+		ColumnExpr("s.path AS source_filename").
+		ColumnExpr("s.checksum AS source_hash").
+		Join("JOIN sources AS s ON s.id = d.source_id").
+		Where("s.collection = ?", collection).
+		Where("s.path = ?", source).
+		Order("d.page_number", "d.chunk_id", "d.doc_id").
+		Scan(ctx)
+	return docs, err
+}
+
+// DeleteSource removes a source of a collection with its documents and
+// returns the number of documents removed
+func DeleteSource(ctx context.Context, db *bun.DB, collection, source string) (int, error) {
+	var id int64
+	err := db.NewSelect().
+		Model((*Source)(nil)).
+		Column("id").
+		Where("collection = ?", collection).
+		Where("path = ?", source).
+		Scan(ctx, &id)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	return DeleteSourceByID(ctx, db, id)
+}
+
+// DeleteSourceByID removes a source with its documents and returns the
+// number of documents removed
+func DeleteSourceByID(ctx context.Context, db *bun.DB, id int64) (int, error) {
+	var n int
+	err := db.RunInTx(ctx, nil, func(ctx context.Context, tx bun.Tx) error {
+		err := tx.NewSelect().
+			Model((*Document)(nil)).
+			ColumnExpr("count(*)").
+			Where("source_id = ?", id).
+			Scan(ctx, &n)
+		if err != nil {
+			return err
+		}
+		// documents go with the source, see the foreign key
+		_, err = tx.NewDelete().
+			Model((*Source)(nil)).
+			Where("id = ?", id).
+			Exec(ctx)
+		return err
+	})
+	return n, err
+}
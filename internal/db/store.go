@@ -0,0 +1,242 @@
+package db
+
+import (
+	"context"
+	"fmt"
+
+	"document-rag/internal/config"
+	"document-rag/internal/fusion"
+	"document-rag/internal/models"
+
+	"github.com/uptrace/bun"
+)
+
+// PgStore is the pgvector implementation of the vector store, scoped to one
+// collection of the documents table
+type PgStore struct {
+	db         *bun.DB
+	collection models.CollectionInfo
+	settings   indexSettings
+	load       LoadOptions
+}
+
+// OpenPgStore registers or validates a collection on an initialized database
+// and returns it as a vector store
+func OpenPgStore(ctx context.Context, db *bun.DB, name, embeddingModel string, vectorSize int, indexCfg *config.VectorIndexConfig, loadCfg *config.BulkLoadConfig) (*PgStore, error) {
+	settings, err := newIndexSettings(indexCfg)
+	if err != nil {
+		return nil, err
+	}
+	info, err := ensureCollection(ctx, db, name, embeddingModel, vectorSize, settings)
+	if err != nil {
+		return nil, err
+	}
+	return &PgStore{
+		db:         db,
+		collection: info,
+		settings:   settings,
+		load:       NewLoadOptions(loadCfg),
+	}, nil
+}
+
+// Upsert stores chunks, replacing chunks that already exist with the same ID
+func (s *PgStore) Upsert(ctx context.Context, chunks []models.ChunkEmbedding) error {
+	docs := make([]Document, len(chunks))
+	for i, ce := range chunks {
+		if err := s.checkDimension(ce.Embedding); err != nil {
+			return fmt.Errorf("chunk %s: %w", ce.ID, err)
+		}
+		docs[i] = Document{
+			Collection:     s.collection.Name,
+			DocID:          ce.ID,
+			Content:        ce.Content,
+			Embedding:      ce.Embedding,
+			SourceFilename: ce.SourceFilename,
+			PageNumber:     ce.PageNumber,
+			ChunkID:        ce.ChunkID,
+			Metadata:       ce.Metadata,
+			ContentHash:    ce.ContentHash,
+		}
+		if docs[i].Metadata == nil {
+			docs[i].Metadata = map[string]string{}
+		}
+	}
+	return UpsertDocuments(ctx, s.db, docs, s.load)
+}
+
+// Search returns the k best chunks for the request mode: closest to the
+// request embedding, best full-text matches of the request text, or both
+// merged with rank fusion
+func (s *PgStore) Search(ctx context.Context, req models.SearchRequest) ([]models.SearchResult, error) {
+	var results []models.SearchResult
+	var err error
+	switch req.Mode {
+	case models.SearchText:
+		results, err = s.textSearch(ctx, req, req.K)
+	case models.SearchHybrid:
+		results, err = fusion.Hybrid(req.K, req.Hybrid,
+			func(k int) ([]models.SearchResult, error) { return s.vectorSearch(ctx, req, k) },
+			func(k int) ([]models.SearchResult, error) { return s.textSearch(ctx, req, k) })
+	default:
+		results, err = s.vectorSearch(ctx, req, req.K)
+	}
+	if err != nil || !req.WithEmbeddings {
+		return results, err
+	}
+	return s.withEmbeddings(ctx, results)
+}
+
+func (s *PgStore) vectorSearch(ctx context.Context, req models.SearchRequest, k int) ([]models.SearchResult, error) {
+	if err := s.checkDimension(req.Embedding); err != nil {
+		return nil, fmt.Errorf("query: %w", err)
+	}
+	docs, err := searchDocuments(ctx, s.db, s.collection.Name, req.Embedding, k, req.Filter, s.settings)
+	if err != nil {
+		return nil, err
+	}
+
+	results := make([]models.SearchResult, len(docs))
+	for i, doc := range docs {
+		results[i] = s.result(doc, s.settings.metric.score(doc.Distance))
+	}
+	return s.withSources(ctx, docs, results)
+}
+
+func (s *PgStore) textSearch(ctx context.Context, req models.SearchRequest, k int) ([]models.SearchResult, error) {
+	if req.Text == "" {
+		return nil, fmt.Errorf("full-text search needs a query text")
+	}
+	docs, err := TextSearchDocuments(ctx, s.db, s.collection.Name, req.Text, k, req.Filter)
+	if err != nil {
+		return nil, err
+	}
+
+	results := make([]models.SearchResult, len(docs))
+	for i, doc := range docs {
+		results[i] = s.result(doc, float32(doc.Rank))
+	}
+	return s.withSources(ctx, docs, results)
+}
+
+func (s *PgStore) result(doc Document, score float32) models.SearchResult {
+	return models.SearchResult{
+		ID:             doc.DocID,
+		Collection:     s.collection.Name,
+		Content:        doc.Content,
+		SourceFilename: doc.SourceFilename,
+		PageNumber:     doc.PageNumber,
+		ChunkID:        doc.ChunkID,
+		Metadata:       doc.Metadata,
+		Score:          score,
+	}
+}
+
+// withSources attaches the source of every document to its result
+func (s *PgStore) withSources(ctx context.Context, docs []Document, results []models.SearchResult) ([]models.SearchResult, error) {
+	ids := make([]int64, 0, len(docs))
+	for _, doc := range docs {
+		ids = append(ids, doc.SourceID)
+	}
+	sources, err := GetSources(ctx, s.db, ids)
+	if err != nil {
+		return nil, err
+	}
+	for i, doc := range docs {
+		if src, ok := sources[doc.SourceID]; ok {
+			results[i].Source = &src
+		}
+	}
+	return results, nil
+}
+
+// withEmbeddings sets the embedding of every result, read after ranking so
+// searches do not carry them
+func (s *PgStore) withEmbeddings(ctx context.Context, results []models.SearchResult) ([]models.SearchResult, error) {
+	ids := make([]string, len(results))
+	for i, r := range results {
+		ids[i] = r.ID
+	}
+	embeddings, err := GetEmbeddings(ctx, s.db, s.collection.Name, ids)
+	if err != nil {
+		return nil, err
+	}
+	for i := range results {
+		results[i].Embedding = embeddings[results[i].ID]
+	}
+	return results, nil
+}
+
+// SourceState returns the recorded hashes of a source
+func (s *PgStore) SourceState(ctx context.Context, source string) (models.SourceState, error) {
+	return GetSourceState(ctx, s.db, s.collection.Name, source)
+}
+
+// RecordSource records a completed ingest of a source with its file metadata
+func (s *PgStore) RecordSource(ctx context.Context, src models.SourceInfo) error {
+	return SaveSource(ctx, s.db, s.collection.Name, src)
+}
+
+// Delete removes the chunks with the given IDs
+func (s *PgStore) Delete(ctx context.Context, ids ...string) error {
+	return DeleteDocuments(ctx, s.db, s.collection.Name, ids)
+}
+
+// Sources returns every source of the collection ordered by name
+func (s *PgStore) Sources(ctx context.Context) ([]models.SourceInfo, error) {
+	return ListSources(ctx, s.db, s.collection.Name)
+}
+
+// Chunks returns the stored chunks of a source, without embeddings
+func (s *PgStore) Chunks(ctx context.Context, source string) ([]models.ChunkEmbedding, error) {
+	docs, err := GetSourceDocuments(ctx, s.db, s.collection.Name, source)
+	if err != nil {
+		return nil, err
+	}
+
+	chunks := make([]models.ChunkEmbedding, len(docs))
+	for i, doc := range docs {
+		chunks[i] = models.ChunkEmbedding{
+			ID:             doc.DocID,
+			Content:        doc.Content,
+			SourceFilename: doc.SourceFilename,
+			PageNumber:     doc.PageNumber,
+			ChunkID:        doc.ChunkID,
+			Metadata:       doc.Metadata,
+			ContentHash:    doc.ContentHash,
+			SourceHash:     doc.SourceHash,
+		}
+	}
+	return chunks, nil
+}
+
+// DeleteSource removes every chunk of a source
+func (s *PgStore) DeleteSource(ctx context.Context, source string) (int, error) {
+	return DeleteSource(ctx, s.db, s.collection.Name, source)
+}
+
+// Reset removes every chunk and source of the collection, keeping its
+// registration and index
+func (s *PgStore) Reset(ctx context.Context) error {
+	_, err := s.db.NewDelete().
+		Model((*Source)(nil)).
+		Where("collection = ?", s.collection.Name).
+		Exec(ctx)
+	return err
+}
+
+// Dimension returns the vector size of the collection
+func (s *PgStore) Dimension() int {
+	return s.collection.Dimension
+}
+
+// Info returns the collection the store is scoped to
+func (s *PgStore) Info() models.CollectionInfo {
+	return s.collection
+}
+
+func (s *PgStore) checkDimension(embedding []float32) error {
+	if len(embedding) != s.collection.Dimension {
+		return fmt.Errorf("%w: got %d dimensions, collection %s holds %d", models.ErrDimensionMismatch, len(embedding), s.collection.Name, s.collection.Dimension)
+	}
+	return nil
+}
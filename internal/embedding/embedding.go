@@ -3,11 +3,8 @@ package embedding
 import (
 	"context"
 	"fmt"
-	"os"
 	"strings"
-	"time"
 
-	"github.com/rs/zerolog"
 	"github.com/rs/zerolog/log"
 
 	"document-rag/internal/config"
@@ -22,10 +19,6 @@ import (
 
 // NewEmbedder creates a new embedder
 func NewEmbedder(openRouterKey, baseURL, embeddingModel string) (*embeddings.EmbedderImpl, error) {
-	zerolog.TimeFieldFormat = zerolog.TimeFormatUnix
-	zerolog.SetGlobalLevel(zerolog.DebugLevel)
-	log.Logger = log.Output(zerolog.ConsoleWriter{Out: os.Stdout, TimeFormat: time.RFC3339}).With().Caller().Logger()
-
 	log.Debug().Interface("config", map[string]string{
 		"base_url":        baseURL,
 		"openrouter_key":  openRouterKey,
@@ -38,23 +31,17 @@ func NewEmbedder(openRouterKey, baseURL, embeddingModel string) (*embeddings.Emb
 		openai.WithModel(embeddingModel),
 	)
 	if err != nil {
-		log.Fatal().Err(err).Msg("Error initializing LLM")
-		return nil, err
+		return nil, fmt.Errorf("failed to initialize LLM: %w", err)
 	}
-	embedder, err := embeddings.NewEmbedder(llm) // Handle both return values
+	embedder, err := embeddings.NewEmbedder(llm)
 	if err != nil {
-		log.Fatal().Err(err).Msg("Error creating embedder")
-		return nil, err
+		return nil, fmt.Errorf("failed to create embedder: %w", err)
 	}
 	return embedder, nil
 }
 
-// new ollama embedder
+// NewOllamaEmbedder creates an embedder for a model served by Ollama
 func NewOllamaEmbedder(LLMconfig *config.LLMConfig) (*embeddings.EmbedderImpl, error) {
-	zerolog.TimeFieldFormat = zerolog.TimeFormatUnix
-	zerolog.SetGlobalLevel(zerolog.DebugLevel)
-	log.Logger = log.Output(zerolog.ConsoleWriter{Out: os.Stdout, TimeFormat: time.RFC3339}).With().Caller().Logger()
-
 	log.Debug().Interface("config", map[string]string{
 		"base_url":        LLMconfig.BaseURL,
 		"embedding_model": LLMconfig.Model,
@@ -65,17 +52,27 @@ func NewOllamaEmbedder(LLMconfig *config.LLMConfig) (*embeddings.EmbedderImpl, e
 		ollama.WithModel(LLMconfig.Model),
 	)
 	if err != nil {
-		log.Fatal().Err(err).Msg("Error initializing LLM")
-		return nil, err
+		return nil, fmt.Errorf("failed to initialize LLM: %w", err)
 	}
-	embedder, err := embeddings.NewEmbedder(llm) // Handle both return values
+	embedder, err := embeddings.NewEmbedder(llm)
 	if err != nil {
-		log.Fatal().Err(err).Msg("Error creating embedder")
-		return nil, err
+		return nil, fmt.Errorf("failed to create embedder: %w", err)
 	}
 	return embedder, nil
 }
 
+// ProbeDimension embeds a short text to find the vector size of the configured model
+func ProbeDimension(ctx context.Context, embedder *embeddings.EmbedderImpl) (int, error) {
+	vector, err := embedder.EmbedQuery(ctx, "dimension probe")
+	if err != nil {
+		return 0, fmt.Errorf("failed to probe embedding dimension: %w", err)
+	}
+	if len(vector) == 0 {
+		return 0, fmt.Errorf("embedding model returned an empty vector")
+	}
+	return len(vector), nil
+}
+
 // GenerateEmbedding generates embeddings for a given file
 func GenerateEmbedding(ctx context.Context, embedder *embeddings.EmbedderImpl, filename string, chunks []models.Chunk) ([]models.ChunkEmbedding, error) {
 	if len(chunks) == 0 {
@@ -90,6 +87,7 @@ func GenerateEmbedding(ctx context.Context, embedder *embeddings.EmbedderImpl, f
 			return nil, err
 		}
 		chunkEmbeddings = append(chunkEmbeddings, models.ChunkEmbedding{
+			ID:             fmt.Sprintf("%s-%d-%d", filename, chunk.PageNumber, chunk.ChunkID),
 			Content:        chunk.Content,
 			Embedding:      embedding,
 			SourceFilename: filename,
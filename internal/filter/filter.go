@@ -0,0 +1,238 @@
+package filter
+
+import (
+	"errors"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Expr is a parsed filter expression
+type Expr interface {
+	isExpr()
+}
+
+// Op is a comparison operator
+type Op string
+
+const (
+	OpEq       Op = "="
+	OpNe       Op = "!="
+	OpLt       Op = "<"
+	OpLe       Op = "<="
+	OpGt       Op = ">"
+	OpGe       Op = ">="
+	OpIn       Op = "IN"
+	OpContains Op = "~"
+)
+
+// ContentField is the pseudo field addressing the chunk text rather than its metadata
+const ContentField = "content"
+
+// And matches when every term matches
+type And struct {
+	Terms []Expr
+}
+
+// Or matches when any term matches
+type Or struct {
+	Terms []Expr
+}
+
+// Not negates its term
+type Not struct {
+	Term Expr
+}
+
+// Cond compares a field against one value, or a list of values for IN
+type Cond struct {
+	Field  string
+	Op     Op
+	Values []string
+}
+
+func (And) isExpr()  {}
+func (Or) isExpr()   {}
+func (Not) isExpr()  {}
+func (Cond) isExpr() {}
+
+// Value returns the single compared value
+func (c Cond) Value() string {
+	if len(c.Values) == 0 {
+		return ""
+	}
+	return c.Values[0]
+}
+
+// Conjuncts flattens the top level AND terms of e
+func Conjuncts(e Expr) []Expr {
+	if e == nil {
+		return nil
+	}
+	and, ok := e.(And)
+	if !ok {
+		return []Expr{e}
+	}
+	var terms []Expr
+	for _, t := range and.Terms {
+		terms = append(terms, Conjuncts(t)...)
+	}
+	return terms
+}
+
+// Match evaluates e against a flat field map. A missing field only
+// satisfies != and NOT conditions, and a range comparison with a numeric
+// value only matches numeric fields.
+func Match(e Expr, fields map[string]string) bool {
+	switch e := e.(type) {
+	case nil:
+		return true
+	case And:
+		for _, t := range e.Terms {
+			if !Match(t, fields) {
+				return false
+			}
+		}
+		return true
+	case Or:
+		for _, t := range e.Terms {
+			if Match(t, fields) {
+				return true
+			}
+		}
+		return false
+	case Not:
+		return !Match(e.Term, fields)
+	case Cond:
+		return matchCond(e, fields)
+	default:
+		return false
+	}
+}
+
+func matchCond(c Cond, fields map[string]string) bool {
+	v, ok := fields[c.Field]
+	if !ok {
+		return c.Op == OpNe
+	}
+
+	switch c.Op {
+	case OpEq:
+		return v == c.Value()
+	case OpNe:
+		return v != c.Value()
+	case OpIn:
+		for _, want := range c.Values {
+			if v == want {
+				return true
+			}
+		}
+		return false
+	case OpContains:
+		return strings.Contains(v, c.Value())
+	case OpLt, OpLe, OpGt, OpGe:
+		cmp, ok := compare(v, c.Value())
+		if !ok {
+			return false
+		}
+		switch c.Op {
+		case OpLt:
+			return cmp < 0
+		case OpLe:
+			return cmp <= 0
+		case OpGt:
+			return cmp > 0
+		default:
+			return cmp >= 0
+		}
+	default:
+		return false
+	}
+}
+
+// compare orders field against value, numerically when value is a number
+// and otherwise by bytes
+func compare(field, value string) (int, bool) {
+	want, ok := ParseNumber(value)
+	if !ok {
+		return strings.Compare(field, value), true
+	}
+	got, ok := ParseNumber(field)
+	if !ok {
+		return 0, false
+	}
+	switch {
+	case got < want:
+		return -1, true
+	case got > want:
+		return 1, true
+	default:
+		return 0, true
+	}
+}
+
+// NumberPattern is the grammar of numbers in range comparisons, decimals
+// with an optional exponent. Stores that compare in their query language
+// guard their numeric casts with it, so they agree with Match: hex, inf and
+// nan are text.
+const NumberPattern = `^\s*[-+]?([0-9]+\.?[0-9]*|\.[0-9]+)([eE][-+]?[0-9]+)?\s*$`
+
+var numberRE = regexp.MustCompile(NumberPattern)
+
+// ParseNumber returns the value of a number of NumberPattern. An exponent
+// beyond float64 yields an infinity or zero.
+func ParseNumber(value string) (float64, bool) {
+	if !numberRE.MatchString(value) {
+		return 0, false
+	}
+	n, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+	if err != nil && !errors.Is(err, strconv.ErrRange) {
+		return 0, false
+	}
+	return n, true
+}
+
+// IsNumber reports whether a compared value is treated as a number
+func IsNumber(value string) bool {
+	_, ok := ParseNumber(value)
+	return ok
+}
+
+// String renders e back into the filter syntax
+func String(e Expr) string {
+	switch e := e.(type) {
+	case nil:
+		return ""
+	case And:
+		return join(e.Terms, " AND ")
+	case Or:
+		return join(e.Terms, " OR ")
+	case Not:
+		if _, ok := e.Term.(Cond); ok {
+			return "NOT " + String(e.Term)
+		}
+		return "NOT (" + String(e.Term) + ")"
+	case Cond:
+		if e.Op == OpIn {
+			quoted := make([]string, len(e.Values))
+			for i, v := range e.Values {
+				quoted[i] = strconv.Quote(v)
+			}
+			return e.Field + " IN (" + strings.Join(quoted, ", ") + ")"
+		}
+		return e.Field + " " + string(e.Op) + " " + strconv.Quote(e.Value())
+	default:
+		return ""
+	}
+}
+
+func join(terms []Expr, sep string) string {
+	parts := make([]string, len(terms))
+	for i, t := range terms {
+		parts[i] = String(t)
+		if _, ok := t.(Cond); !ok {
+			parts[i] = "(" + parts[i] + ")"
+		}
+	}
+	return strings.Join(parts, sep)
+}
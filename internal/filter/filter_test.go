@@ -0,0 +1,211 @@
+package filter
+
+import (
+	"math"
+	"strings"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		// want is the parsed expression rendered with String
+		want string
+	}{
+		{"empty", "  ", ""},
+		{"equal", "chapter=II", `chapter = "II"`},
+		{"operators", "a!=1 AND b<2 AND c<=3 AND d>4 AND e>=5 AND f~x", `a != "1" AND b < "2" AND c <= "3" AND d > "4" AND e >= "5" AND f ~ "x"`},
+		{"quoted values", `speaker = "Lord Krishna" AND title='it\'s'`, `speaker = "Lord Krishna" AND title = "it's"`},
+		{"word characters", "source=docs/a_b-1.pdf", `source = "docs/a_b-1.pdf"`},
+		{"in", "speaker IN (Arjuna, 'Sanjaya')", `speaker IN ("Arjuna", "Sanjaya")`},
+		{"not in", "speaker NOT IN (Arjuna)", `NOT speaker IN ("Arjuna")`},
+		{"lower case keywords", "a=1 and not b=2 or c in (3)", `(a = "1" AND (NOT b = "2")) OR c IN ("3")`},
+		{"AND binds tighter than OR", "a=1 OR b=2 AND c=3", `a = "1" OR (b = "2" AND c = "3")`},
+		{"parentheses group", "(a=1 OR b=2) AND c=3", `(a = "1" OR b = "2") AND c = "3"`},
+		{"NOT binds tighter than AND", "NOT a=1 AND b=2", `(NOT a = "1") AND b = "2"`},
+		{"NOT of a group", "NOT (a=1 OR b=2)", `NOT (a = "1" OR b = "2")`},
+		{"nested", "((a=1))", `a = "1"`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e, err := Parse(tt.input)
+			if err != nil {
+				t.Fatalf("Parse(%q): %v", tt.input, err)
+			}
+			got := String(e)
+			if got != tt.want {
+				t.Fatalf("Parse(%q) = %s, want %s", tt.input, got, tt.want)
+			}
+			// the rendered form parses to the same expression
+			again, err := Parse(got)
+			if err != nil || String(again) != got {
+				t.Errorf("Parse(%q) = %s, %v, want it unchanged", got, String(again), err)
+			}
+		})
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	tests := []struct {
+		input string
+		err   string
+	}{
+		{"a=", "expected a value"},
+		{"a", "expected an operator"},
+		{"=1", "expected a field"},
+		{"a!1", "unexpected '!'"},
+		{"a=1 b=2", `unexpected "b"`},
+		{"(a=1", "missing closing parenthesis"},
+		{"a=1)", `unexpected ")"`},
+		{`a="open`, "unterminated string"},
+		{"a IN 1", "expected ( after IN"},
+		{"a IN (1 2)", "expected , or )"},
+		{"a IN (1,", "expected a value"},
+		{"a NOT 1", "expected IN after NOT"},
+		{"a=1 AND", "expected a field"},
+		{"a=1 OR OR b=2", "expected an operator"},
+		{"a=#", `unexpected '#'`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			_, err := Parse(tt.input)
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("Parse(%q) error = %v, want %q", tt.input, err, tt.err)
+			}
+		})
+	}
+}
+
+func TestMatch(t *testing.T) {
+	fields := map[string]string{
+		"chapter":     "II",
+		"speaker":     "Krishna",
+		"page_number": "12",
+		"version":     "1.5",
+		"content":     "The soul is eternal",
+	}
+	tests := []struct {
+		filter string
+		want   bool
+	}{
+		{"", true},
+		{"chapter=II", true},
+		{"chapter=ii", false},
+		{"chapter!=III", true},
+		{"speaker IN (Arjuna, Krishna)", true},
+		{"speaker NOT IN (Arjuna, Krishna)", false},
+		{"content~soul", true},
+		{"content~Soul", false},
+
+		// numbers compare numerically, not as text
+		{"page_number>9", true},
+		{"page_number<=12", true},
+		{"page_number<12", false},
+		{"page_number>=12.0", true},
+		{"version<1.10", false},
+		{"version>-2", true},
+		// text values compare as text
+		{"chapter<III", true},
+		{"speaker>=Krishna", true},
+		// a numeric value only matches numeric fields
+		{"speaker>1", false},
+		{"NOT speaker>1", true},
+		// inf, nan and hex are text
+		{"version<inf", true},
+		{"version<0x10", false},
+		{"page_number>nan", false},
+		// text orders by bytes
+		{"speaker>KRISHNA", true},
+		{"speaker<krishna", true},
+
+		// a missing field only satisfies != and NOT
+		{"missing=x", false},
+		{"missing!=x", true},
+		{"missing>1", false},
+		{"missing IN (x)", false},
+		{"NOT missing=x", true},
+
+		{"chapter=II AND speaker=Arjuna", false},
+		{"chapter=II OR speaker=Arjuna", true},
+		{"speaker=Arjuna OR chapter=I AND page_number>1", false},
+		{"(speaker=Arjuna OR chapter=II) AND page_number>1", true},
+		{"NOT (speaker=Arjuna OR chapter=I)", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.filter, func(t *testing.T) {
+			e, err := Parse(tt.filter)
+			if err != nil {
+				t.Fatalf("Parse: %v", err)
+			}
+			if got := Match(e, fields); got != tt.want {
+				t.Errorf("Match(%s) = %v, want %v", tt.filter, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestConjuncts(t *testing.T) {
+	tests := []struct {
+		filter string
+		want   []string
+	}{
+		{"", nil},
+		{"a=1", []string{`a = "1"`}},
+		{"a=1 AND (b=2 AND c=3)", []string{`a = "1"`, `b = "2"`, `c = "3"`}},
+		{"a=1 AND (b=2 OR c=3)", []string{`a = "1"`, `b = "2" OR c = "3"`}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.filter, func(t *testing.T) {
+			e, err := Parse(tt.filter)
+			if err != nil {
+				t.Fatalf("Parse: %v", err)
+			}
+			var got []string
+			for _, c := range Conjuncts(e) {
+				got = append(got, String(c))
+			}
+			if strings.Join(got, "|") != strings.Join(tt.want, "|") {
+				t.Errorf("Conjuncts = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseNumber(t *testing.T) {
+	tests := []struct {
+		value string
+		want  float64
+		ok    bool
+	}{
+		{"12", 12, true},
+		{"-1.5", -1.5, true},
+		{"+.5", 0.5, true},
+		{"3.", 3, true},
+		{"1e3", 1000, true},
+		{"2.5E-1", 0.25, true},
+		{" 7 ", 7, true},
+		{"1e400", math.Inf(1), true},
+		{"", 0, false},
+		{".", 0, false},
+		{"1e", 0, false},
+		{"inf", 0, false},
+		{"-Infinity", 0, false},
+		{"NaN", 0, false},
+		{"0x10", 0, false},
+		{"0x1p-2", 0, false},
+		{"1_000", 0, false},
+		{"12abc", 0, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			got, ok := ParseNumber(tt.value)
+			if ok != tt.ok || got != tt.want {
+				t.Errorf("ParseNumber(%q) = %v, %v, want %v, %v", tt.value, got, ok, tt.want, tt.ok)
+			}
+			if IsNumber(tt.value) != tt.ok {
+				t.Errorf("IsNumber(%q) = %v, want %v", tt.value, !tt.ok, tt.ok)
+			}
+		})
+	}
+}
@@ -0,0 +1,280 @@
+package filter
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+)
+
+// Parse reads a filter expression such as
+//
+//	chapter=II AND (speaker=Krishna OR speaker IN (Arjuna, Sanjaya)) AND NOT page_number>10
+//
+// Operators are = != < <= > >= IN and ~ (contains). Values are bare words or
+// quoted strings, the keywords AND, OR, NOT and IN are case-insensitive.
+// An empty expression parses to nil, which matches everything.
+func Parse(input string) (Expr, error) {
+	tokens, err := tokenize(input)
+	if err != nil {
+		return nil, err
+	}
+	if len(tokens) == 0 {
+		return nil, nil
+	}
+
+	p := &parser{tokens: tokens}
+	e, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if !p.done() {
+		return nil, fmt.Errorf("unexpected %q at position %d", p.peek().text, p.peek().pos)
+	}
+	return e, nil
+}
+
+type tokenKind int
+
+const (
+	tokWord tokenKind = iota
+	tokString
+	tokOp
+	tokLParen
+	tokRParen
+	tokComma
+)
+
+type token struct {
+	kind tokenKind
+	text string
+	pos  int
+}
+
+func tokenize(input string) ([]token, error) {
+	var tokens []token
+	runes := []rune(input)
+	for i := 0; i < len(runes); {
+		r := runes[i]
+		switch {
+		case unicode.IsSpace(r):
+			i++
+		case r == '(':
+			tokens = append(tokens, token{tokLParen, "(", i})
+			i++
+		case r == ')':
+			tokens = append(tokens, token{tokRParen, ")", i})
+			i++
+		case r == ',':
+			tokens = append(tokens, token{tokComma, ",", i})
+			i++
+		case r == '"' || r == '\'':
+			start := i
+			var sb strings.Builder
+			i++
+			for i < len(runes) && runes[i] != r {
+				if runes[i] == '\\' && i+1 < len(runes) {
+					i++
+				}
+				sb.WriteRune(runes[i])
+				i++
+			}
+			if i >= len(runes) {
+				return nil, fmt.Errorf("unterminated string at position %d", start)
+			}
+			i++
+			tokens = append(tokens, token{tokString, sb.String(), start})
+		case strings.ContainsRune("=!<>~", r):
+			start := i
+			op := string(r)
+			if i+1 < len(runes) && runes[i+1] == '=' && r != '=' && r != '~' {
+				op += "="
+			}
+			if op == "!" {
+				return nil, fmt.Errorf("unexpected '!' at position %d", start)
+			}
+			i += len(op)
+			tokens = append(tokens, token{tokOp, op, start})
+		case isWordRune(r):
+			start := i
+			for i < len(runes) && isWordRune(runes[i]) {
+				i++
+			}
+			tokens = append(tokens, token{tokWord, string(runes[start:i]), start})
+		default:
+			return nil, fmt.Errorf("unexpected %q at position %d", r, i)
+		}
+	}
+	return tokens, nil
+}
+
+func isWordRune(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsDigit(r) || strings.ContainsRune("_.-:/", r)
+}
+
+type parser struct {
+	tokens []token
+	pos    int
+}
+
+func (p *parser) done() bool {
+	return p.pos >= len(p.tokens)
+}
+
+func (p *parser) peek() token {
+	if p.done() {
+		return token{pos: -1}
+	}
+	return p.tokens[p.pos]
+}
+
+func (p *parser) next() token {
+	t := p.peek()
+	p.pos++
+	return t
+}
+
+// keyword reports whether the next token is the given keyword, consuming it if so
+func (p *parser) keyword(kw string) bool {
+	t := p.peek()
+	if !p.done() && t.kind == tokWord && strings.EqualFold(t.text, kw) {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+func (p *parser) parseOr() (Expr, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	terms := []Expr{left}
+	for p.keyword("OR") {
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		terms = append(terms, right)
+	}
+	if len(terms) == 1 {
+		return left, nil
+	}
+	return Or{Terms: terms}, nil
+}
+
+func (p *parser) parseAnd() (Expr, error) {
+	left, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	terms := []Expr{left}
+	for p.keyword("AND") {
+		right, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		terms = append(terms, right)
+	}
+	if len(terms) == 1 {
+		return left, nil
+	}
+	return And{Terms: terms}, nil
+}
+
+func (p *parser) parseUnary() (Expr, error) {
+	if p.keyword("NOT") {
+		term, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return Not{Term: term}, nil
+	}
+
+	if p.peek().kind == tokLParen && !p.done() {
+		p.next()
+		e, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if t := p.next(); t.kind != tokRParen || t.pos < 0 {
+			return nil, fmt.Errorf("missing closing parenthesis")
+		}
+		return e, nil
+	}
+
+	return p.parseCond()
+}
+
+func (p *parser) parseCond() (Expr, error) {
+	field := p.next()
+	if field.pos < 0 {
+		return nil, fmt.Errorf("unexpected end of filter, expected a field")
+	}
+	if field.kind != tokWord {
+		return nil, fmt.Errorf("expected a field at position %d, got %q", field.pos, field.text)
+	}
+
+	if p.keyword("NOT") {
+		if !p.keyword("IN") {
+			return nil, fmt.Errorf("expected IN after NOT at position %d", p.peek().pos)
+		}
+		values, err := p.parseList()
+		if err != nil {
+			return nil, err
+		}
+		return Not{Term: Cond{Field: field.text, Op: OpIn, Values: values}}, nil
+	}
+	if p.keyword("IN") {
+		values, err := p.parseList()
+		if err != nil {
+			return nil, err
+		}
+		return Cond{Field: field.text, Op: OpIn, Values: values}, nil
+	}
+
+	op := p.next()
+	if op.kind != tokOp || op.pos < 0 {
+		return nil, fmt.Errorf("expected an operator after %q", field.text)
+	}
+	value, err := p.parseValue()
+	if err != nil {
+		return nil, err
+	}
+	return Cond{Field: field.text, Op: Op(op.text), Values: []string{value}}, nil
+}
+
+func (p *parser) parseList() ([]string, error) {
+	if t := p.next(); t.kind != tokLParen || t.pos < 0 {
+		return nil, fmt.Errorf("expected ( after IN")
+	}
+	var values []string
+	for {
+		v, err := p.parseValue()
+		if err != nil {
+			return nil, err
+		}
+		values = append(values, v)
+
+		t := p.next()
+		if t.pos < 0 {
+			return nil, fmt.Errorf("missing closing parenthesis")
+		}
+		if t.kind == tokRParen {
+			return values, nil
+		}
+		if t.kind != tokComma {
+			return nil, fmt.Errorf("expected , or ) at position %d, got %q", t.pos, t.text)
+		}
+	}
+}
+
+func (p *parser) parseValue() (string, error) {
+	t := p.next()
+	if t.pos < 0 {
+		return "", fmt.Errorf("unexpected end of filter, expected a value")
+	}
+	if t.kind != tokWord && t.kind != tokString {
+		return "", fmt.Errorf("expected a value at position %d, got %q", t.pos, t.text)
+	}
+	return t.text, nil
+}
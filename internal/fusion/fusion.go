@@ -0,0 +1,147 @@
+// Package fusion merges ranked result lists from different retrievers, such
+// as vector and full-text search, into one ranking.
+package fusion
+
+import (
+	"sort"
+
+	"document-rag/internal/models"
+)
+
+const (
+	MethodRRF      = "rrf"
+	MethodWeighted = "weighted"
+)
+
+// DefaultRRFK is the rank constant of reciprocal rank fusion from the
+// original paper, larger values flatten the advantage of top ranks
+const DefaultRRFK = 60
+
+// List is one retriever's results in rank order, with the weight its ranks
+// or scores get in the fused ranking
+type List struct {
+	Results []models.SearchResult
+	Weight  float64
+}
+
+// Fuse merges lists with the given method, rrf when empty, and returns the
+// results ordered by fused score with Score set to it. Fused scores are
+// scaled to [0, 1], 1 for a result ranked first or scored highest by every
+// list.
+func Fuse(method string, rrfK int, lists ...List) []models.SearchResult {
+	if method == MethodWeighted {
+		return Weighted(lists...)
+	}
+	return RRF(rrfK, lists...)
+}
+
+// RRF scores each result with the sum of weight/(k+rank) over the lists it
+// appears in. Only ranks matter, so lists with incomparable scores fuse well.
+func RRF(k int, lists ...List) []models.SearchResult {
+	if k <= 0 {
+		k = DefaultRRFK
+	}
+	return fuse(lists, 1/float64(k+1), func(results []models.SearchResult) []float64 {
+		values := make([]float64, len(results))
+		for rank := range results {
+			values[rank] = 1 / float64(k+rank+1)
+		}
+		return values
+	})
+}
+
+// Weighted scores each result with the weighted sum of its scores, min-max
+// normalized per list so retrievers with different score ranges contribute
+// on the same scale
+func Weighted(lists ...List) []models.SearchResult {
+	return fuse(lists, 1, func(results []models.SearchResult) []float64 {
+		values := make([]float64, len(results))
+		if len(results) == 0 {
+			return values
+		}
+		lo, hi := results[0].Score, results[0].Score
+		for _, r := range results {
+			lo = min(lo, r.Score)
+			hi = max(hi, r.Score)
+		}
+		for i, r := range results {
+			if hi == lo {
+				values[i] = 1
+				continue
+			}
+			values[i] = float64(r.Score-lo) / float64(hi-lo)
+		}
+		return values
+	})
+}
+
+// fuse accumulates weight * value per result, where values holds a list's
+// contribution per rank, and divides it by the total of a result given the
+// best value by every list. Results are identified by collection and ID.
+func fuse(lists []List, best float64, values func(results []models.SearchResult) []float64) []models.SearchResult {
+	type key struct{ collection, id string }
+	scores := map[key]float64{}
+	var merged []models.SearchResult
+
+	var total float64
+	for _, list := range lists {
+		total += list.Weight * best
+		contribution := values(list.Results)
+		for rank, r := range list.Results {
+			k := key{r.Collection, r.ID}
+			if _, ok := scores[k]; !ok {
+				merged = append(merged, r)
+			}
+			scores[k] += list.Weight * contribution[rank]
+		}
+	}
+
+	if total == 0 {
+		total = 1
+	}
+	for i := range merged {
+		merged[i].Score = float32(scores[key{merged[i].Collection, merged[i].ID}] / total)
+	}
+	sort.SliceStable(merged, func(i, j int) bool {
+		return merged[i].Score > merged[j].Score
+	})
+	return merged
+}
+
+// Retriever returns up to k results in rank order
+type Retriever func(k int) ([]models.SearchResult, error)
+
+// Hybrid runs the vector and full-text retrievers of a hybrid search, each
+// for opts.Candidates results, and fuses them down to k. Zero weights and
+// candidates take their defaults.
+func Hybrid(k int, opts models.HybridOptions, vector, text Retriever) ([]models.SearchResult, error) {
+	candidates := opts.Candidates
+	if candidates <= 0 {
+		candidates = 4 * k
+	}
+	candidates = max(candidates, k)
+
+	vectorResults, err := vector(candidates)
+	if err != nil {
+		return nil, err
+	}
+	textResults, err := text(candidates)
+	if err != nil {
+		return nil, err
+	}
+
+	fused := Fuse(opts.Fusion, opts.RRFK,
+		List{Results: vectorResults, Weight: weightOrDefault(opts.VectorWeight)},
+		List{Results: textResults, Weight: weightOrDefault(opts.TextWeight)})
+	if len(fused) > k {
+		fused = fused[:k]
+	}
+	return fused, nil
+}
+
+func weightOrDefault(w float64) float64 {
+	if w > 0 {
+		return w
+	}
+	return 1
+}
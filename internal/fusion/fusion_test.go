@@ -0,0 +1,220 @@
+package fusion
+
+import (
+	"errors"
+	"math"
+	"strings"
+	"testing"
+
+	"document-rag/internal/models"
+)
+
+// results builds a ranked list from "collection/id:score" entries
+func results(entries ...string) []models.SearchResult {
+	var list []models.SearchResult
+	for _, e := range entries {
+		var r models.SearchResult
+		ref, score, _ := strings.Cut(e, ":")
+		r.Collection, r.ID, _ = strings.Cut(ref, "/")
+		for _, c := range score {
+			r.Score = r.Score*10 + float32(c-'0')
+		}
+		list = append(list, r)
+	}
+	return list
+}
+
+// ranking returns "collection/id" of results in order
+func ranking(results []models.SearchResult) string {
+	refs := make([]string, len(results))
+	for i, r := range results {
+		refs[i] = r.Collection + "/" + r.ID
+	}
+	return strings.Join(refs, ",")
+}
+
+func near(a, b float32) bool {
+	return math.Abs(float64(a-b)) < 1e-6
+}
+
+func TestRRF(t *testing.T) {
+	tests := []struct {
+		name  string
+		k     int
+		lists []List
+		want  string
+		// scores of the fused results in order, checked when set
+		scores []float32
+	}{
+		{
+			name:   "first in every list scores 1",
+			k:      60,
+			lists:  []List{{Results: results("c/a", "c/b"), Weight: 1}, {Results: results("c/a", "c/c"), Weight: 1}},
+			want:   "c/a,c/b,c/c",
+			scores: []float32{1, 0.5 * 61 / 62, 0.5 * 61 / 62},
+		},
+		{
+			name:  "agreement beats one top rank",
+			k:     60,
+			lists: []List{{Results: results("c/a", "c/b"), Weight: 1}, {Results: results("c/c", "c/b"), Weight: 1}},
+			want:  "c/b,c/a,c/c",
+		},
+		{
+			name:  "same ID in two collections stays apart",
+			k:     60,
+			lists: []List{{Results: results("x/a", "y/a"), Weight: 1}, {Results: results("y/a"), Weight: 1}},
+			want:  "y/a,x/a",
+		},
+		{
+			name:  "weight favours a list",
+			k:     60,
+			lists: []List{{Results: results("c/a", "c/b"), Weight: 1}, {Results: results("c/b", "c/a"), Weight: 3}},
+			want:  "c/b,c/a",
+		},
+		{
+			name:   "zero k takes the default",
+			k:      0,
+			lists:  []List{{Results: results("c/a", "c/b"), Weight: 1}},
+			want:   "c/a,c/b",
+			scores: []float32{1, float32(DefaultRRFK+1) / float32(DefaultRRFK+2)},
+		},
+		{
+			name:  "no lists",
+			k:     60,
+			lists: nil,
+			want:  "",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fused := RRF(tt.k, tt.lists...)
+			if got := ranking(fused); got != tt.want {
+				t.Fatalf("ranking %s, want %s", got, tt.want)
+			}
+			for i, want := range tt.scores {
+				if !near(fused[i].Score, want) {
+					t.Errorf("score of %s = %v, want %v", fused[i].ID, fused[i].Score, want)
+				}
+			}
+			for _, r := range fused {
+				if r.Score <= 0 || r.Score > 1 {
+					t.Errorf("score of %s = %v, want it in (0, 1]", r.ID, r.Score)
+				}
+			}
+		})
+	}
+}
+
+func TestWeighted(t *testing.T) {
+	tests := []struct {
+		name   string
+		lists  []List
+		want   string
+		scores []float32
+	}{
+		{
+			name: "scores are min-max scaled per list",
+			lists: []List{
+				{Results: results("c/a:90", "c/b:50", "c/c:10"), Weight: 1},
+				{Results: results("c/c:3", "c/a:1"), Weight: 1},
+			},
+			want:   "c/a,c/c,c/b",
+			scores: []float32{0.5, 0.5, 0.25},
+		},
+		{
+			name:   "equal scores count as the best",
+			lists:  []List{{Results: results("c/a:5", "c/b:5"), Weight: 1}},
+			want:   "c/a,c/b",
+			scores: []float32{1, 1},
+		},
+		{
+			name: "weight favours a list",
+			lists: []List{
+				{Results: results("c/a:9", "c/b:1"), Weight: 1},
+				{Results: results("c/b:9", "c/a:1"), Weight: 3},
+			},
+			want:   "c/b,c/a",
+			scores: []float32{0.75, 0.25},
+		},
+		{
+			name: "dedup by collection and ID",
+			lists: []List{
+				{Results: results("x/a:9", "y/a:1"), Weight: 1},
+				{Results: results("x/a:4"), Weight: 1},
+			},
+			want:   "x/a,y/a",
+			scores: []float32{1, 0},
+		},
+		{
+			name:  "zero weights",
+			lists: []List{{Results: results("c/a:9"), Weight: 0}},
+			want:  "c/a",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fused := Weighted(tt.lists...)
+			if got := ranking(fused); got != tt.want {
+				t.Fatalf("ranking %s, want %s", got, tt.want)
+			}
+			for i, want := range tt.scores {
+				if !near(fused[i].Score, want) {
+					t.Errorf("score of %s = %v, want %v", fused[i].ID, fused[i].Score, want)
+				}
+			}
+		})
+	}
+}
+
+func TestFuseMethod(t *testing.T) {
+	lists := []List{{Results: results("c/a:1", "c/b:9"), Weight: 1}}
+	if got := ranking(Fuse("", 0, lists...)); got != "c/a,c/b" {
+		t.Errorf("default fusion ranked %s, want rank order", got)
+	}
+	if got := ranking(Fuse(MethodWeighted, 0, lists...)); got != "c/b,c/a" {
+		t.Errorf("weighted fusion ranked %s, want score order", got)
+	}
+}
+
+func TestHybrid(t *testing.T) {
+	retriever := func(list []models.SearchResult, asked *int) Retriever {
+		return func(k int) ([]models.SearchResult, error) {
+			*asked = k
+			return list, nil
+		}
+	}
+	tests := []struct {
+		name       string
+		k          int
+		opts       models.HybridOptions
+		candidates int
+		want       string
+	}{
+		{"default candidates", 2, models.HybridOptions{}, 8, "c/b,c/a"},
+		{"candidates below k", 3, models.HybridOptions{Candidates: 1}, 3, "c/b,c/a,c/c"},
+		{"weighted fusion", 1, models.HybridOptions{Fusion: MethodWeighted, TextWeight: 2}, 4, "c/b"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var vectorK, textK int
+			fused, err := Hybrid(tt.k, tt.opts,
+				retriever(results("c/a", "c/b"), &vectorK),
+				retriever(results("c/b", "c/c"), &textK))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if vectorK != tt.candidates || textK != tt.candidates {
+				t.Errorf("retrievers asked for %d and %d, want %d", vectorK, textK, tt.candidates)
+			}
+			if got := ranking(fused); got != tt.want {
+				t.Errorf("ranking %s, want %s", got, tt.want)
+			}
+		})
+	}
+
+	failing := func(k int) ([]models.SearchResult, error) { return nil, errors.New("down") }
+	var k int
+	if _, err := Hybrid(2, models.HybridOptions{}, retriever(nil, &k), failing); err == nil {
+		t.Error("Hybrid ignored a failing retriever")
+	}
+}
@@ -0,0 +1,218 @@
+package ingest
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"document-rag/internal/models"
+	"document-rag/internal/store"
+
+	"github.com/rs/zerolog/log"
+	"github.com/tmc/langchaingo/embeddings"
+)
+
+// Source is a file to ingest. Load is only called when Hash differs from the
+// hash recorded at the last ingest, and returns chunks without embeddings.
+type Source struct {
+	Name string
+	Hash string
+	Load func() ([]models.ChunkEmbedding, error)
+
+	// file metadata recorded by stores that keep it
+	MIMEType string
+	Size     int64
+}
+
+// FileSource describes a file as a source with its hash, size and MIME type.
+// The caller sets Load.
+func FileSource(path string) (Source, error) {
+	hash, err := HashFile(path)
+	if err != nil {
+		return Source{}, err
+	}
+	fi, err := os.Stat(path)
+	if err != nil {
+		return Source{}, err
+	}
+	return Source{
+		Name:     path,
+		Hash:     hash,
+		MIMEType: mimeType(path),
+		Size:     fi.Size(),
+	}, nil
+}
+
+// mimeType guesses the type of a file from its extension, or its content
+// for unknown extensions
+func mimeType(path string) string {
+	if t := mime.TypeByExtension(filepath.Ext(path)); t != "" {
+		return t
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return ""
+	}
+	defer f.Close()
+	head := make([]byte, 512)
+	n, _ := io.ReadFull(f, head)
+	return http.DetectContentType(head[:n])
+}
+
+// Result summarizes what an ingest changed in the store
+type Result struct {
+	Source    string
+	Skipped   bool // source unchanged since the last ingest
+	Embedded  int  // new or changed chunks
+	Unchanged int
+	Deleted   int
+}
+
+// Ingest brings the stored chunks of a source in line with its current
+// content. Only new or changed chunks are embedded and written, chunks that
+// disappeared from the source are deleted and other sources are left alone.
+func Ingest(ctx context.Context, vs store.VectorStore, embedder *embeddings.EmbedderImpl, src Source) (Result, error) {
+	return ingest(ctx, vs, embedder, src, false)
+}
+
+// Reingest parses a source again and re-embeds every chunk even when nothing
+// changed, e.g. after a parser fix. Chunks are replaced in place, so the
+// source stays searchable if embedding fails halfway.
+func Reingest(ctx context.Context, vs store.VectorStore, embedder *embeddings.EmbedderImpl, src Source) (Result, error) {
+	return ingest(ctx, vs, embedder, src, true)
+}
+
+func ingest(ctx context.Context, vs store.VectorStore, embedder *embeddings.EmbedderImpl, src Source, force bool) (Result, error) {
+	res := Result{Source: src.Name}
+
+	state, err := vs.SourceState(ctx, src.Name)
+	if err != nil {
+		return res, fmt.Errorf("failed to read stored state of %s: %w", src.Name, err)
+	}
+	if !force && src.Hash != "" && state.Hash == src.Hash {
+		res.Skipped = true
+		res.Unchanged = len(state.Chunks)
+		return res, nil
+	}
+
+	chunks, err := src.Load()
+	if err != nil {
+		return res, fmt.Errorf("failed to load %s: %w", src.Name, err)
+	}
+
+	var changed []models.ChunkEmbedding
+	pageCount := 0
+	seen := make(map[string]bool, len(chunks))
+	for _, chunk := range chunks {
+		pageCount = max(pageCount, chunk.PageNumber)
+		if seen[chunk.ID] {
+			return res, fmt.Errorf("duplicate chunk ID %s in %s", chunk.ID, src.Name)
+		}
+		seen[chunk.ID] = true
+
+		chunk.SourceFilename = src.Name
+		chunk.SourceHash = src.Hash
+		chunk.ContentHash = HashChunk(chunk)
+		if !force && state.Chunks[chunk.ID] == chunk.ContentHash {
+			res.Unchanged++
+			continue
+		}
+
+		chunk.Embedding, err = embedder.EmbedQuery(ctx, chunk.Content)
+		if err != nil {
+			return res, fmt.Errorf("failed to embed chunk %s: %w", chunk.ID, err)
+		}
+		changed = append(changed, chunk)
+	}
+
+	if err := vs.Upsert(ctx, changed); err != nil {
+		return res, fmt.Errorf("failed to store chunks: %w", err)
+	}
+	res.Embedded = len(changed)
+
+	var stale []string
+	for id := range state.Chunks {
+		if !seen[id] {
+			stale = append(stale, id)
+		}
+	}
+	if err := vs.Delete(ctx, stale...); err != nil {
+		return res, fmt.Errorf("failed to delete stale chunks: %w", err)
+	}
+	res.Deleted = len(stale)
+
+	// unchanged chunks still carry the previous source hash
+	err = vs.RecordSource(ctx, models.SourceInfo{
+		Name:      src.Name,
+		Hash:      src.Hash,
+		MIMEType:  src.MIMEType,
+		Size:      src.Size,
+		PageCount: pageCount,
+	})
+	if err != nil {
+		return res, fmt.Errorf("failed to record source hash: %w", err)
+	}
+
+	log.Info().
+		Str("source", src.Name).
+		Int("embedded", res.Embedded).
+		Int("unchanged", res.Unchanged).
+		Int("deleted", res.Deleted).
+		Msg("Ingested source")
+	return res, nil
+}
+
+// HashFile returns the hex sha256 of a file's content
+func HashFile(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// HashChunk returns the hex sha256 of a chunk's content and metadata
+func HashChunk(chunk models.ChunkEmbedding) string {
+	h := sha256.New()
+	io.WriteString(h, chunk.Content)
+
+	keys := make([]string, 0, len(chunk.Metadata))
+	for k := range chunk.Metadata {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		fmt.Fprintf(h, "\x00%s=%s", k, chunk.Metadata[k])
+	}
+	fmt.Fprintf(h, "\x00%d\x00%d", chunk.PageNumber, chunk.ChunkID)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// FromChunks turns parsed chunks into chunks ready for Ingest, keyed by
+// source, page and chunk number
+func FromChunks(source string, chunks []models.Chunk) []models.ChunkEmbedding {
+	result := make([]models.ChunkEmbedding, 0, len(chunks))
+	for _, chunk := range chunks {
+		result = append(result, models.ChunkEmbedding{
+			ID:             fmt.Sprintf("%s-%d-%d", source, chunk.PageNumber, chunk.ChunkID),
+			Content:        chunk.Content,
+			SourceFilename: source,
+			PageNumber:     chunk.PageNumber,
+			ChunkID:        chunk.ChunkID,
+		})
+	}
+	return result
+}
@@ -0,0 +1,74 @@
+package ingest
+
+import (
+	"fmt"
+
+	"document-rag/internal/config"
+	"document-rag/internal/models"
+	"document-rag/internal/parser"
+
+	"github.com/rs/zerolog/log"
+)
+
+// DocumentSource returns a PDF, office or text file as a source, parsed into
+// chunks of the configured size
+func DocumentSource(cfg *config.Config, filePath string) (Source, error) {
+	src, err := FileSource(filePath)
+	if err != nil {
+		return Source{}, err
+	}
+
+	src.Load = func() ([]models.ChunkEmbedding, error) {
+		// the parser fills in default chunk sizes, keep them off the shared config
+		parseCfg := *cfg
+		chunks, err := parser.ParseToMarkdown(filePath, &parseCfg)
+		if err != nil {
+			return nil, err
+		}
+		return FromChunks(filePath, chunks), nil
+	}
+	return src, nil
+}
+
+// BGSource returns a BG text file as a source. Parsing generates chunk
+// context with the query LLM, so it only runs when the file changed since
+// the last ingest.
+func BGSource(cfg *config.Config, filePath string) (Source, error) {
+	src, err := FileSource(filePath)
+	if err != nil {
+		return Source{}, err
+	}
+
+	src.Load = func() ([]models.ChunkEmbedding, error) {
+		content := parser.ParseBGText(filePath, cfg)
+		log.Info().Msg("Parsed content")
+
+		// add context
+		// content = parser.AddContextByChapter(content, cfg)
+
+		return bgChunks(filePath, content), nil
+	}
+	return src, nil
+}
+
+// bgChunks keys BG sections by source, chapter and position within the
+// chapter, so an edit only shifts the IDs of its own chapter
+func bgChunks(filePath string, content []parser.BGSection) []models.ChunkEmbedding {
+	var docs []models.ChunkEmbedding
+	seq := map[string]int{}
+	for _, section := range content {
+		// if content is empty, skip
+		if section.Content == "" {
+			continue
+		}
+		seq[section.Chapter]++
+		docs = append(docs, models.ChunkEmbedding{
+			ID:             fmt.Sprintf("%s-%s-%d", filePath, section.Chapter, seq[section.Chapter]),
+			Content:        section.Content,
+			SourceFilename: filePath,
+			ChunkID:        section.ChunkID,
+			Metadata:       parser.CreateMetadata(section),
+		})
+	}
+	return docs
+}
@@ -14,11 +14,7 @@ import (
 // call llm
 func GenerateContent(ctx context.Context, llmConfig *config.LLMConfig, tools []llms.Tool, messages []llms.MessageContent) (*llms.ContentResponse, error) {
 	log.Debug().Interface("llmConfig", llmConfig).Msg("Generating content")
-	llm, err := openai.New(
-		openai.WithBaseURL(llmConfig.BaseURL),
-		openai.WithToken(strings.TrimPrefix(llmConfig.Key, "Bearer ")),
-		openai.WithModel(llmConfig.Model),
-	)
+	llm, err := newLLM(llmConfig)
 	if err != nil {
 		return nil, err
 	}
@@ -29,3 +25,31 @@ func GenerateContent(ctx context.Context, llmConfig *config.LLMConfig, tools []l
 
 	return llm.GenerateContent(ctx, messages)
 }
+
+// StreamContent generates content like GenerateContent and calls fn with
+// every piece of the answer as it arrives. An error of fn, or the end of ctx,
+// stops the generation and is returned.
+func StreamContent(ctx context.Context, llmConfig *config.LLMConfig, messages []llms.MessageContent, fn func(ctx context.Context, chunk string) error) (*llms.ContentResponse, error) {
+	log.Debug().Interface("llmConfig", llmConfig).Msg("Streaming content")
+	llm, err := newLLM(llmConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	return llm.GenerateContent(ctx, messages, llms.WithStreamingFunc(func(ctx context.Context, chunk []byte) error {
+		// role and finish deltas carry no text
+		if len(chunk) == 0 {
+			return nil
+		}
+		return fn(ctx, string(chunk))
+	}))
+}
+
+func newLLM(llmConfig *config.LLMConfig) (*openai.LLM, error) {
+	return openai.New(
+		openai.WithBaseURL(llmConfig.BaseURL),
+		openai.WithToken(strings.TrimPrefix(llmConfig.Key, "Bearer ")),
+		openai.WithModel(llmConfig.Model),
+		openai.WithHTTPClient(keepAliveFilter{}),
+	)
+}
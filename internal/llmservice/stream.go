@@ -0,0 +1,55 @@
+package llmservice
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// keepAliveFilter drops the comment lines of event streams, such as the
+// ": OPENROUTER PROCESSING" keep-alives OpenRouter sends while a model warms
+// up. The openai client parses every line of a stream as an event, a comment
+// would fail the whole response.
+type keepAliveFilter struct{}
+
+func (keepAliveFilter) Do(req *http.Request) (*http.Response, error) {
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if strings.HasPrefix(resp.Header.Get("Content-Type"), "text/event-stream") {
+		resp.Body = &commentFilter{body: resp.Body, r: bufio.NewReader(resp.Body)}
+	}
+	return resp, nil
+}
+
+// commentFilter reads an event stream without its comment lines
+type commentFilter struct {
+	body io.Closer
+	r    *bufio.Reader
+	buf  []byte
+}
+
+func (c *commentFilter) Read(p []byte) (int, error) {
+	for len(c.buf) == 0 {
+		line, err := c.r.ReadBytes('\n')
+		if !bytes.HasPrefix(line, []byte(":")) {
+			c.buf = line
+		}
+		if err != nil {
+			if len(c.buf) > 0 {
+				break
+			}
+			return 0, err
+		}
+	}
+	n := copy(p, c.buf)
+	c.buf = c.buf[n:]
+	return n, nil
+}
+
+func (c *commentFilter) Close() error {
+	return c.body.Close()
+}
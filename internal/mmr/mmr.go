@@ -0,0 +1,91 @@
+// Package mmr re-selects search results with maximal marginal relevance, so
+// the selection covers distinct passages instead of near-duplicate chunks.
+package mmr
+
+import (
+	"math"
+
+	"document-rag/internal/models"
+)
+
+// DefaultLambda weighs relevance and diversity equally
+const DefaultLambda = 0.5
+
+// Select picks k results from candidates in relevance order. Each pick
+// maximizes
+//
+//	lambda * relevance - (1 - lambda) * max similarity to the picks so far
+//
+// where relevance is the candidate score min-max normalized over candidates,
+// and similarity is the cosine of the embeddings. Candidates without an
+// embedding, or with one of another dimension, count as dissimilar. A lambda
+// outside (0, 1] takes DefaultLambda.
+func Select(candidates []models.SearchResult, k int, lambda float64) []models.SearchResult {
+	if lambda <= 0 || lambda > 1 {
+		lambda = DefaultLambda
+	}
+	if len(candidates) <= k {
+		return candidates
+	}
+
+	relevance := normalize(candidates)
+	// maxSim[i] is the highest similarity of candidate i to a selected result
+	maxSim := make([]float64, len(candidates))
+	picked := make([]bool, len(candidates))
+	selected := make([]models.SearchResult, 0, k)
+
+	for len(selected) < k {
+		best, bestValue := -1, math.Inf(-1)
+		for i := range candidates {
+			if picked[i] {
+				continue
+			}
+			value := lambda*relevance[i] - (1-lambda)*maxSim[i]
+			if value > bestValue {
+				best, bestValue = i, value
+			}
+		}
+		picked[best] = true
+		selected = append(selected, candidates[best])
+
+		for i := range candidates {
+			if !picked[i] {
+				maxSim[i] = max(maxSim[i], cosine(candidates[i].Embedding, candidates[best].Embedding))
+			}
+		}
+	}
+	return selected
+}
+
+func normalize(results []models.SearchResult) []float64 {
+	values := make([]float64, len(results))
+	lo, hi := results[0].Score, results[0].Score
+	for _, r := range results {
+		lo = min(lo, r.Score)
+		hi = max(hi, r.Score)
+	}
+	for i, r := range results {
+		if hi == lo {
+			values[i] = 1
+			continue
+		}
+		values[i] = float64(r.Score-lo) / float64(hi-lo)
+	}
+	return values
+}
+
+func cosine(a, b []float32) float64 {
+	if len(a) == 0 || len(a) != len(b) {
+		return 0
+	}
+	var dot, na, nb float64
+	for i := range a {
+		dot += float64(a[i]) * float64(b[i])
+		na += float64(a[i]) * float64(a[i])
+		nb += float64(b[i]) * float64(b[i])
+	}
+	if na == 0 || nb == 0 {
+		return 0
+	}
+	return dot / math.Sqrt(na*nb)
+}
@@ -0,0 +1,87 @@
+package mmr
+
+import (
+	"strings"
+	"testing"
+
+	"document-rag/internal/models"
+)
+
+// candidates in relevance order: a and its near duplicate, a distinct b, c
+// without an embedding and d with an embedding of another dimension
+func candidates() []models.SearchResult {
+	return []models.SearchResult{
+		{ID: "a", Score: 1.0, Embedding: []float32{1, 0}},
+		{ID: "a2", Score: 0.9, Embedding: []float32{1, 0.05}},
+		{ID: "b", Score: 0.5, Embedding: []float32{0, 1}},
+		{ID: "c", Score: 0.1},
+		{ID: "d", Score: 0.1, Embedding: []float32{1, 0, 0}},
+	}
+}
+
+func ids(results []models.SearchResult) string {
+	names := make([]string, len(results))
+	for i, r := range results {
+		names[i] = r.ID
+	}
+	return strings.Join(names, ",")
+}
+
+func TestSelect(t *testing.T) {
+	tests := []struct {
+		name   string
+		k      int
+		lambda float64
+		want   string
+	}{
+		{"relevance only", 2, 1, "a,a2"},
+		{"balanced skips the duplicate", 2, 0.5, "a,b"},
+		{"zero lambda takes the default", 2, 0, "a,b"},
+		{"negative lambda takes the default", 2, -1, "a,b"},
+		{"lambda above 1 takes the default", 2, 1.5, "a,b"},
+		{"missing or mismatched embeddings are dissimilar", 4, 0.1, "a,b,c,d"},
+		{"k of all candidates keeps them", 5, 0.5, "a,a2,b,c,d"},
+		{"k above the candidates keeps them", 10, 0.5, "a,a2,b,c,d"},
+		{"zero k", 0, 0.5, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := ids(Select(candidates(), tt.k, tt.lambda)); got != tt.want {
+				t.Errorf("Select(k=%d, lambda=%v) = %s, want %s", tt.k, tt.lambda, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSelectEqualScores(t *testing.T) {
+	results := []models.SearchResult{
+		{ID: "a", Score: 0.7, Embedding: []float32{1, 0}},
+		{ID: "b", Score: 0.7, Embedding: []float32{1, 0}},
+		{ID: "c", Score: 0.7, Embedding: []float32{0, 1}},
+	}
+	if got := ids(Select(results, 2, 0.5)); got != "a,c" {
+		t.Errorf("Select = %s, want a,c", got)
+	}
+}
+
+func TestCosine(t *testing.T) {
+	tests := []struct {
+		name string
+		a, b []float32
+		want float64
+	}{
+		{"same direction", []float32{1, 1}, []float32{2, 2}, 1},
+		{"orthogonal", []float32{1, 0}, []float32{0, 3}, 0},
+		{"opposite", []float32{1, 0}, []float32{-1, 0}, -1},
+		{"missing", nil, []float32{1, 0}, 0},
+		{"other dimension", []float32{1, 0}, []float32{1, 0, 0}, 0},
+		{"zero vector", []float32{0, 0}, []float32{1, 0}, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := cosine(tt.a, tt.b); got < tt.want-1e-9 || got > tt.want+1e-9 {
+				t.Errorf("cosine = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,25 @@
+package models
+
+import "time"
+
+// ChatTurn is one question of a conversation with its answer
+type ChatTurn struct {
+	Question string `json:"question"`
+	// Query is the standalone question retrieval searched for
+	Query  string    `json:"query,omitempty"`
+	Answer string    `json:"answer"`
+	At     time.Time `json:"at"`
+}
+
+// ChatSession is a conversation continued across queries by its ID. Every
+// turn is kept, the oldest ones are sent to the LLM as Summary once they no
+// longer fit the history.
+type ChatSession struct {
+	ID    string     `json:"id"`
+	Turns []ChatTurn `json:"turns"`
+	// Summary condenses the first Summarized turns
+	Summary    string    `json:"summary,omitempty"`
+	Summarized int       `json:"summarized,omitempty"`
+	CreatedAt  time.Time `json:"created_at"`
+	UpdatedAt  time.Time `json:"updated_at"`
+}
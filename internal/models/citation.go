@@ -0,0 +1,70 @@
+package models
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// metadata keys of the Bhagavad Gita chunks given their own citation fields
+const (
+	MetaChapter = "chapter"
+	MetaSpeaker = "speaker"
+)
+
+// Citation is a context passage the answer refers to with its [Number]
+// marker
+type Citation struct {
+	Number      int               `json:"number"`
+	Source      string            `json:"source"`
+	Collection  string            `json:"collection,omitempty"`
+	Page        int               `json:"page,omitempty"`
+	Chapter     string            `json:"chapter,omitempty"`
+	Speaker     string            `json:"speaker,omitempty"`
+	ChunkID     int               `json:"chunk_id"`
+	LastChunkID int               `json:"last_chunk_id,omitempty"`
+	Score       float32           `json:"score"`
+	Snippet     string            `json:"snippet"`
+	MIMEType    string            `json:"mime_type,omitempty"`
+	IngestedAt  time.Time         `json:"ingested_at"`
+	Metadata    map[string]string `json:"metadata,omitempty"` // the other metadata of the chunk
+}
+
+// String formats the citation as a reference line
+func (c Citation) String() string {
+	parts := []string{fmt.Sprintf("Source: %s", c.Source)}
+	if c.Page > 0 {
+		parts = append(parts, fmt.Sprintf("Page: %d", c.Page))
+	}
+	if c.Chapter != "" {
+		parts = append(parts, "Chapter: "+c.Chapter)
+	}
+	if c.Speaker != "" {
+		parts = append(parts, "Speaker: "+c.Speaker)
+	}
+	if c.LastChunkID != 0 {
+		parts = append(parts, fmt.Sprintf("Chunks: %d-%d", c.ChunkID, c.LastChunkID))
+	} else {
+		parts = append(parts, fmt.Sprintf("Chunk: %d", c.ChunkID))
+	}
+	parts = append(parts, fmt.Sprintf("Score: %.2f", c.Score))
+	if c.Collection != "" {
+		parts = append(parts, "Collection: "+c.Collection)
+	}
+	if c.MIMEType != "" {
+		parts = append(parts, "Type: "+c.MIMEType)
+	}
+	if !c.IngestedAt.IsZero() {
+		parts = append(parts, "Ingested: "+c.IngestedAt.Format(time.DateOnly))
+	}
+	keys := make([]string, 0, len(c.Metadata))
+	for k := range c.Metadata {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		parts = append(parts, fmt.Sprintf("%s: %s", k, c.Metadata[k]))
+	}
+	return fmt.Sprintf("[%d] %s", c.Number, strings.Join(parts, ", "))
+}
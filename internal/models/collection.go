@@ -0,0 +1,29 @@
+package models
+
+import "time"
+
+// CollectionInfo describes a named collection. Every collection keeps the
+// embedding model it was created with, so its chunks and queries share one
+// vector space.
+type CollectionInfo struct {
+	Name           string
+	EmbeddingModel string
+	Dimension      int
+	Chunks         int
+	CreatedAt      time.Time
+}
+
+// SourceInfo describes one ingested source file. Backends without a sources
+// table only know the name, hash, chunk count and ingest time.
+type SourceInfo struct {
+	ID   int64  `json:"id,omitempty"` // 0 when the backend does not number sources
+	Name string `json:"name"`
+	// Hash is the source hash recorded at the last ingest, empty when the
+	// source was never completely ingested
+	Hash       string    `json:"hash,omitempty"`
+	MIMEType   string    `json:"mime_type,omitempty"`
+	Size       int64     `json:"size,omitempty"`
+	PageCount  int       `json:"page_count,omitempty"`
+	Chunks     int       `json:"chunks"`
+	IngestedAt time.Time `json:"ingested_at"`
+}
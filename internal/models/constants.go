@@ -20,4 +20,75 @@ Here is the chunk we want to situate within the whole document
 </chunk>
 Please give a short succinct context to situate this chunk within the overall document for the purposes of improving search retrieval of the chunk. Answer only with the succinct context and nothing else.
 `
+
+	RewritePromptTemplate = `Rewrite the following question into a search query for a document collection. Keep every name, number and technical term, make implicit subjects explicit and drop filler words. Answer only with the search query.
+<question>
+%s
+</question>
+`
+
+	MultiQueryPromptTemplate = `Write %d different versions of the following question to search a document collection with. Use other words and other angles on the same need, keeping names and technical terms. Answer only with the questions, one per line, without numbering.
+<question>
+%s
+</question>
+`
+
+	HyDEPromptTemplate = `Write a short passage, as it could appear in a document, that answers the following question. It does not need to be correct, it is used to find similar passages. Answer only with the passage.
+<question>
+%s
+</question>
+`
+
+	CondensePromptTemplate = `Here is a conversation
+<conversation>
+%s
+</conversation>
+and a follow-up question
+<question>
+%s
+</question>
+Rewrite the follow-up question into a standalone question that can be understood without the conversation, resolving pronouns and references to earlier turns. If it is already standalone, repeat it unchanged. Answer only with the question.
+`
+
+	SummaryPromptTemplate = `Here is the summary of a conversation so far
+<summary>
+%s
+</summary>
+and the turns that followed it
+<conversation>
+%s
+</conversation>
+Write a new short summary of the whole conversation, keeping the topics, names and facts a later question could refer to. Answer only with the summary.
+`
+
+	CompressPromptTemplate = `Here is a query
+<query>
+%s
+</query>
+and a passage retrieved for it
+<passage>
+%s
+</passage>
+Summarize the passage in at most %d words, keeping the names, numbers and statements relevant to the query. Answer only with the summary.
+`
+
+	RerankPromptTemplate = `Here is a query
+<query>
+%s
+</query>
+and passages retrieved for it
+%s
+Grade how useful each passage is to answer the query, from 0 (unrelated) to 10 (answers it). Answer only with a JSON array of the %d grades in passage order, such as [7, 0, 3], and nothing else.
+`
+)
+
+// metadata keys used to carry chunk fields through stores that only keep a
+// string map (chromem)
+const (
+	MetaSourceFilename = "source_filename"
+	MetaPageNumber     = "page_number"
+	MetaChunkID        = "chunk_id"
+	MetaContentHash    = "content_hash"
+	MetaSourceHash     = "source_hash"
+	MetaIngestedAt     = "ingested_at"
 )
@@ -1,5 +1,12 @@
 package models
 
+import (
+	"fmt"
+	"strings"
+
+	"document-rag/internal/filter"
+)
+
 // Chunk represents a parsed chunk with metadata
 type Chunk struct {
 	Content    string
@@ -8,16 +15,124 @@ type Chunk struct {
 }
 
 type PromptResponse struct {
-	Query   string
-	Source  string
-	Content string
+	Query   string `json:"query"`
+	Source  string `json:"source"`
+	Content string `json:"content"`
+	// Citations are the context passages the answer cites with [n] markers
+	Citations []Citation `json:"citations"`
+	// UnknownCitations are the markers of the answer that number no passage
+	UnknownCitations []int `json:"unknown_citations,omitempty"`
+	// Results are the chunks used as context, with their scores. They are
+	// not serialized, the citations describe the ones the answer uses.
+	Results []SearchResult `json:"-"`
+}
+
+// StreamEvent is a piece of a streamed answer: a token of the answer as the
+// LLM generates it, then a final event with the complete response, its
+// sources and citations
+type StreamEvent struct {
+	Token    string
+	Done     bool
+	Response *PromptResponse // set on the final event
 }
 
 // ChunkEmbedding holds the content, embedding, and metadata for a single chunk
 type ChunkEmbedding struct {
+	ID             string
 	Content        string
 	Embedding      []float32
 	SourceFilename string
 	PageNumber     int // Nullable for non-paged formats
 	ChunkID        int
+	Metadata       map[string]string
+	ContentHash    string // hash of content and metadata, see ingest.HashChunk
+	SourceHash     string // hash of the source file the chunk was parsed from
+}
+
+// SourceState is what a store holds for one source, used to skip or limit re-ingestion
+type SourceState struct {
+	// Hash is the source hash recorded at the last ingest, empty if the source is unknown
+	Hash string
+	// Chunks maps every stored chunk ID of the source to its content hash
+	Chunks map[string]string
+}
+
+// SearchMode selects the retrieval a store runs for a search
+type SearchMode string
+
+const (
+	SearchVector SearchMode = "vector" // embedding similarity, the default
+	SearchText   SearchMode = "text"   // full-text match on the chunk content
+	SearchHybrid SearchMode = "hybrid" // both, merged with rank fusion
+)
+
+// ParseSearchMode validates a search mode name, empty means vector
+func ParseSearchMode(name string) (SearchMode, error) {
+	switch mode := SearchMode(strings.ToLower(name)); mode {
+	case "":
+		return SearchVector, nil
+	case SearchVector, SearchText, SearchHybrid:
+		return mode, nil
+	default:
+		return "", fmt.Errorf("unsupported search mode: %s", name)
+	}
+}
+
+// SearchRequest describes a search against a vector store
+type SearchRequest struct {
+	Embedding []float32
+	// Text is the query for full-text retrieval
+	Text string
+	K    int
+	Mode SearchMode
+	// Hybrid controls the fusion of hybrid searches
+	Hybrid HybridOptions
+	// Filter restricts results to chunks whose fields and metadata match, nil matches all
+	Filter filter.Expr
+	// WithEmbeddings sets the chunk embeddings on the results
+	WithEmbeddings bool
+}
+
+// HybridOptions controls how vector and full-text results are merged, see
+// the fusion package
+type HybridOptions struct {
+	Fusion       string // rrf (default) or weighted
+	VectorWeight float64
+	TextWeight   float64
+	RRFK         int
+	// Candidates is the number of results fetched from each retriever before fusion
+	Candidates int
+}
+
+// Similarity clamps a similarity to the [0, 1] range of SearchResult.Score,
+// opposite vectors score 0
+func Similarity(v float64) float32 {
+	return float32(min(max(v, 0), 1))
+}
+
+// SearchResult is a single scored chunk returned by a vector store.
+// Score is a similarity in [0, 1], higher means closer to the query: the
+// cosine similarity of vector search (1/(1+distance) for l2), the normalized
+// rank of text search and the scaled fused score of hybrid search, the same
+// for every backend.
+type SearchResult struct {
+	ID             string
+	Collection     string
+	Content        string
+	SourceFilename string
+	PageNumber     int
+	ChunkID        int
+	// LastChunkID is the last chunk of a passage expanded with the chunks
+	// following ChunkID, 0 for a single chunk
+	LastChunkID int
+	Metadata    map[string]string
+	Score       float32
+	// RetrievalRank and RetrievalScore are the 1-based rank and the score of
+	// a result before reranking, 0 when it was not reranked
+	RetrievalRank  int
+	RetrievalScore float32
+	// Embedding is the chunk embedding, only set for requests WithEmbeddings
+	Embedding []float32
+	// Source holds the file level information of backends that keep it, nil otherwise
+	Source *SourceInfo
 }
@@ -0,0 +1,17 @@
+package models
+
+import "errors"
+
+// ErrDimensionMismatch is returned when embeddings do not match the vector
+// size a store was created with
+var ErrDimensionMismatch = errors.New("embedding dimension mismatch")
+
+// ErrCollectionNotFound is returned when a named collection does not exist
+var ErrCollectionNotFound = errors.New("collection not found")
+
+// ErrSessionNotFound is returned when a chat session does not exist
+var ErrSessionNotFound = errors.New("chat session not found")
+
+// ErrInvalidFilter is returned when a filter expression cannot be applied by
+// a store
+var ErrInvalidFilter = errors.New("invalid filter")
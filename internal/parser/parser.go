@@ -141,6 +141,7 @@ func parseDOCX(filePath string) ([]models.Chunk, error) {
 		}
 		chunk.Content = markdown
 		if strings.TrimSpace(chunk.Content) != "" {
+			chunk.ChunkID = len(chunks) + 1
 			chunks = append(chunks, chunk)
 		}
 	}
@@ -179,6 +180,7 @@ func parsePPTX(filePath string) ([]models.Chunk, error) {
 			if strings.TrimSpace(chunk.Content) != "" {
 				slideNumCopy := slideNum + 1 // 1-based indexing
 				chunk.PageNumber = slideNumCopy
+				chunk.ChunkID = 1
 				chunks = append(chunks, chunk)
 			}
 		}
@@ -214,6 +216,7 @@ func parseXLSX(filePath string) ([]models.Chunk, error) {
 		if strings.TrimSpace(chunk.Content) != "" {
 			sheetNumCopy := sheetNum + 1 // 1-based indexing
 			chunk.PageNumber = sheetNumCopy
+			chunk.ChunkID = 1
 			chunks = append(chunks, chunk)
 		}
 	}
@@ -253,6 +256,7 @@ func parseODS(filePath string) ([]models.Chunk, error) {
 		if strings.TrimSpace(chunk.Content) != "" {
 			sheetNumCopy := sheetNum + 1 // 1-based indexing
 			chunk.PageNumber = sheetNumCopy
+			chunk.ChunkID = 1
 			chunks = append(chunks, chunk)
 		}
 	}
@@ -267,6 +271,7 @@ func parseText(filePath string) ([]models.Chunk, error) {
 	chunk := models.Chunk{
 		Content:    string(data),
 		PageNumber: defaultPageNumber, // TXT has no pages
+		ChunkID:    1,
 	}
 	markdown, err := convertToMarkdown(chunk.Content)
 	if err != nil {
@@ -390,21 +395,33 @@ func (p *ParserConfig) getChunks(content string, pageNumber int) []models.Chunk
 	return chunks
 }
 
-// return complete content from chunks based on related chunkIDs and overlapCharLen
-//
-//	logic is to trim the content of each chunk to the last overlapCharLen characters
-//	and then append the content of the next chunk, last chunk is appended as is
-func getCompleteContent(chunks []models.Chunk, overlapCharLen int) string {
+// CompleteContent stitches consecutive chunks of a text back together. The
+// start of each chunk that repeats the end of the previous one, at most
+// overlapCharLen characters as cut by chunkContent, is dropped, chunks that
+// do not overlap are joined with a space.
+func CompleteContent(chunks []models.Chunk, overlapCharLen int) string {
 	var content strings.Builder
 	for i, chunk := range chunks {
 		chunkContent := chunk.Content
-		if i < len(chunks)-1 {
-			contentLen := len(chunkContent)
-			if contentLen > 0 && contentLen > overlapCharLen {
-				chunkContent = chunkContent[contentLen-overlapCharLen:]
+		if i > 0 {
+			n := OverlapLen(chunks[i-1].Content, chunkContent, overlapCharLen)
+			if n == 0 {
+				content.WriteString(" ")
 			}
+			chunkContent = chunkContent[n:]
 		}
 		content.WriteString(chunkContent)
 	}
 	return content.String()
 }
+
+// OverlapLen returns the length of the longest end of prev, at most maxLen
+// bytes, that next starts with
+func OverlapLen(prev, next string, maxLen int) int {
+	for n := min(maxLen, len(prev), len(next)); n > 0; n-- {
+		if strings.HasSuffix(prev, next[:n]) {
+			return n
+		}
+	}
+	return 0
+}
@@ -0,0 +1,229 @@
+// Package prompt renders the messages of an answer from named templates, so
+// the answer style can be tuned without recompiling.
+//
+// A template is in text/template syntax and defines two blocks, the system
+// message and the user message with the question and the context:
+//
+//	{{define "system"}}You answer for the support team ...{{end}}
+//	{{define "user"}}Question: {{.Query}}
+//	{{range .Chunks}}[{{.Number}}] {{.Content}}
+//	{{end}}{{end}}
+//
+// Templates are the <name>.tmpl files of the prompts directory and the ones
+// of the config, both rendered with Data. The system block is optional.
+package prompt
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/template"
+
+	"document-rag/internal/config"
+)
+
+// Default is the name of the built-in template
+const Default = "default"
+
+const defaultDir = "./prompts"
+
+// ErrUnknownTemplate is returned for a template name that is not loaded
+var ErrUnknownTemplate = errors.New("unknown prompt template")
+
+const (
+	defaultSystem = "You are a helpful assistant. Answer the query based only on the provided context. If the context does not contain the answer, respond with 'I don't know.' The context passages are numbered, cite the passages a statement is based on with their numbers in square brackets right after it, such as [1] or [2][3]. Only cite numbers of the context."
+	defaultUser   = "Based on the following context, answer the query: {{.Query}}\n\nContext:\n{{range .Chunks}}[{{.Number}}] {{.Content}}\n\n{{end}}"
+)
+
+// Data is what templates render
+type Data struct {
+	Query  string
+	Chunks []Chunk
+	// History is the previous turns of a chat session, oldest first, and
+	// Summary the summary of older ones. They are also sent as messages.
+	History []Turn
+	Summary string
+}
+
+// Chunk is a context passage, numbered for the answer to cite it
+type Chunk struct {
+	Number      int
+	Content     string
+	Source      string
+	Collection  string
+	Page        int
+	ChunkID     int
+	LastChunkID int
+	Score       float32
+	Metadata    map[string]string
+}
+
+// Turn is a question and its answer
+type Turn struct {
+	Question string
+	Answer   string
+}
+
+// Template renders the messages of an answer
+type Template struct {
+	Name string
+	tmpl *template.Template
+}
+
+// Render returns the system message, empty without a system block, and the
+// user message
+func (t *Template) Render(data Data) (string, string, error) {
+	var system, user strings.Builder
+	if s := t.tmpl.Lookup("system"); s != nil {
+		if err := s.Execute(&system, data); err != nil {
+			return "", "", fmt.Errorf("failed to render prompt %s: %w", t.Name, err)
+		}
+	}
+	if err := t.tmpl.ExecuteTemplate(&user, "user", data); err != nil {
+		return "", "", fmt.Errorf("failed to render prompt %s: %w", t.Name, err)
+	}
+	return system.String(), user.String(), nil
+}
+
+// Set holds the templates by name
+type Set struct {
+	templates map[string]*Template
+	cfg       *config.PromptsConfig
+}
+
+// Load reads the built-in template, the files of the prompts directory and
+// the templates of the config, which replace files of the same name. Every
+// template is rendered once with sample data, and the default and collection
+// templates have to exist, so mistakes fail at startup.
+func Load(cfg *config.PromptsConfig) (*Set, error) {
+	set := &Set{templates: map[string]*Template{}, cfg: cfg}
+	builtin, err := parse(Default, `{{define "system"}}`+defaultSystem+`{{end}}{{define "user"}}`+defaultUser+`{{end}}`)
+	if err != nil {
+		return nil, err
+	}
+	set.templates[Default] = builtin
+
+	dir := cfg.Dir
+	if dir == "" {
+		dir = defaultDir
+	}
+	files, err := filepath.Glob(filepath.Join(dir, "*.tmpl"))
+	if err != nil {
+		return nil, err
+	}
+	if len(files) == 0 && cfg.Dir != "" {
+		if _, err := os.Stat(cfg.Dir); errors.Is(err, os.ErrNotExist) {
+			return nil, fmt.Errorf("prompts directory %s does not exist", cfg.Dir)
+		}
+	}
+	for _, file := range files {
+		text, err := os.ReadFile(file)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read prompt: %w", err)
+		}
+		name := strings.TrimSuffix(filepath.Base(file), ".tmpl")
+		if set.templates[name], err = parse(name, string(text)); err != nil {
+			return nil, err
+		}
+	}
+	for name, t := range cfg.Templates {
+		text := `{{define "user"}}` + t.User + `{{end}}`
+		if t.System != "" {
+			text = `{{define "system"}}` + t.System + `{{end}}` + text
+		}
+		if set.templates[name], err = parse(name, text); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, name := range set.Names() {
+		if err := validate(set.templates[name]); err != nil {
+			return nil, err
+		}
+	}
+	if _, err := set.Get(cfg.Default); err != nil {
+		return nil, err
+	}
+	for collection, name := range cfg.Collections {
+		if _, err := set.Get(name); err != nil {
+			return nil, fmt.Errorf("prompt of collection %s: %w", collection, err)
+		}
+	}
+	return set, nil
+}
+
+// Get returns the template of the name, the default for an empty name
+func (s *Set) Get(name string) (*Template, error) {
+	if name == "" {
+		name = s.cfg.Default
+	}
+	if name == "" {
+		name = Default
+	}
+	t, ok := s.templates[name]
+	if !ok {
+		return nil, fmt.Errorf("%w %q, have %s", ErrUnknownTemplate, name, strings.Join(s.Names(), ", "))
+	}
+	return t, nil
+}
+
+// ForCollections returns the template of the name, else the template of
+// the collections when they all have the same, else the default
+func (s *Set) ForCollections(name string, collections []string) (*Template, error) {
+	if name == "" && len(collections) > 0 {
+		name = s.cfg.Collections[collections[0]]
+		for _, c := range collections[1:] {
+			if s.cfg.Collections[c] != name {
+				name = ""
+				break
+			}
+		}
+	}
+	return s.Get(name)
+}
+
+// Names returns the template names in order
+func (s *Set) Names() []string {
+	names := make([]string, 0, len(s.templates))
+	for name := range s.templates {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func parse(name, text string) (*Template, error) {
+	tmpl, err := template.New(name).Parse(text)
+	if err != nil {
+		return nil, fmt.Errorf("invalid prompt template %s: %w", name, err)
+	}
+	if tmpl.Lookup("user") == nil {
+		return nil, fmt.Errorf("prompt template %s has no user block", name)
+	}
+	return &Template{Name: name, tmpl: tmpl}, nil
+}
+
+// validate renders a template with sample data, text/template only finds
+// unknown fields when it executes them
+func validate(t *Template) error {
+	sample := Data{
+		Query: "the sample query",
+		Chunks: []Chunk{{
+			Number: 1, Content: "passage", Source: "file.txt", Collection: "collection",
+			Page: 1, ChunkID: 1, Score: 1, Metadata: map[string]string{"key": "value"},
+		}},
+		History: []Turn{{Question: "previous question", Answer: "previous answer"}},
+		Summary: "summary",
+	}
+	system, user, err := t.Render(sample)
+	if err != nil {
+		return err
+	}
+	if !strings.Contains(system+user, sample.Query) {
+		return fmt.Errorf("prompt template %s does not use the query", t.Name)
+	}
+	return nil
+}
@@ -0,0 +1,137 @@
+package rag
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"document-rag/internal/models"
+	"document-rag/internal/prompt"
+
+	"github.com/rs/zerolog/log"
+	"github.com/tmc/langchaingo/llms"
+)
+
+const defaultMaxTurns = 6
+
+// Chat answers the next question of a session and appends the turn to it,
+// the caller saves the session. A follow-up question is condensed into a
+// standalone query for retrieval, and the recent turns, with a summary of
+// older ones when chat.summarize is set, are sent to the LLM as history.
+func (r *RAG) Chat(ctx context.Context, session *models.ChatSession, question string, opts QueryOptions) (models.PromptResponse, error) {
+	searchQuery := r.condense(ctx, session, question)
+	rsp, err := r.answer(ctx, question, searchQuery, conversation{Summary: session.Summary, Turns: r.recentTurns(session)}, opts)
+	if err != nil {
+		return rsp, err
+	}
+
+	turn := models.ChatTurn{Question: question, Answer: rsp.Content, At: time.Now().UTC()}
+	if searchQuery != question {
+		turn.Query = searchQuery
+	}
+	session.Turns = append(session.Turns, turn)
+	session.UpdatedAt = turn.At
+
+	if err := r.summarize(ctx, session); err != nil {
+		// the turns are still there, the next question tries again
+		log.Warn().Err(err).Msg("Failed to summarize chat history")
+	}
+	return rsp, nil
+}
+
+// condense rewrites a follow-up question into a standalone one. The first
+// question of a session is searched as is, as is a follow-up the LLM fails
+// to condense.
+func (r *RAG) condense(ctx context.Context, session *models.ChatSession, question string) string {
+	if len(session.Turns) == 0 {
+		return question
+	}
+	conversation := session.Summary
+	if recent := transcript(r.recentTurns(session)); recent != "" {
+		conversation = strings.TrimSpace(conversation + "\n\n" + recent)
+	}
+	standalone, err := r.complete(ctx, fmt.Sprintf(models.CondensePromptTemplate, conversation, question))
+	if err != nil {
+		log.Warn().Err(err).Msg("Failed to condense follow-up question, searching the question")
+		return question
+	}
+	if standalone == "" {
+		return question
+	}
+	log.Debug().Str("question", question).Str("query", standalone).Msg("Condensed follow-up question")
+	return standalone
+}
+
+// conversation is the chat history an answer continues: the summary of
+// older turns and the recent turns
+type conversation struct {
+	Summary string
+	Turns   []models.ChatTurn
+}
+
+// messages returns the history sent to the LLM between the system prompt and
+// the question
+func (c conversation) messages() []llms.MessageContent {
+	var messages []llms.MessageContent
+	if c.Summary != "" {
+		messages = append(messages, llms.TextParts(llms.ChatMessageTypeSystem, "Summary of the earlier conversation: "+c.Summary))
+	}
+	for _, turn := range c.Turns {
+		messages = append(messages,
+			llms.TextParts(llms.ChatMessageTypeHuman, turn.Question),
+			llms.TextParts(llms.ChatMessageTypeAI, stripCitations(turn.Answer)))
+	}
+	return messages
+}
+
+// promptData returns the conversation as template data
+func (c conversation) promptData(query string) prompt.Data {
+	data := prompt.Data{Query: query, Summary: c.Summary}
+	for _, turn := range c.Turns {
+		data.History = append(data.History, prompt.Turn{Question: turn.Question, Answer: stripCitations(turn.Answer)})
+	}
+	return data
+}
+
+// recentTurns returns the last max_turns turns not covered by the summary
+func (r *RAG) recentTurns(session *models.ChatSession) []models.ChatTurn {
+	turns := session.Turns[min(session.Summarized, len(session.Turns)):]
+	if n := r.maxTurns(); len(turns) > n {
+		turns = turns[len(turns)-n:]
+	}
+	return turns
+}
+
+// summarize folds the turns that no longer fit the history into the summary
+func (r *RAG) summarize(ctx context.Context, session *models.ChatSession) error {
+	if !r.cfg.Chat.Summarize {
+		return nil
+	}
+	end := len(session.Turns) - r.maxTurns()
+	if end <= session.Summarized {
+		return nil
+	}
+	summary, err := r.complete(ctx, fmt.Sprintf(models.SummaryPromptTemplate, session.Summary, transcript(session.Turns[session.Summarized:end])))
+	if err != nil {
+		return err
+	}
+	session.Summary, session.Summarized = summary, end
+	log.Debug().Int("turns", end).Str("summary", summary).Msg("Summarized chat history")
+	return nil
+}
+
+func (r *RAG) maxTurns() int {
+	if r.cfg.Chat.MaxTurns > 0 {
+		return r.cfg.Chat.MaxTurns
+	}
+	return defaultMaxTurns
+}
+
+func transcript(turns []models.ChatTurn) string {
+	var b strings.Builder
+	for _, turn := range turns {
+		fmt.Fprintf(&b, "User: %s\nAssistant: %s\n", turn.Question, stripCitations(turn.Answer))
+	}
+	return strings.TrimSpace(b.String())
+}
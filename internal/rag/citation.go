@@ -0,0 +1,100 @@
+package rag
+
+import (
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"document-rag/internal/models"
+)
+
+const snippetLen = 200
+
+// citationMarker matches the [n] markers of an answer, also grouped as [1, 3]
+var citationMarker = regexp.MustCompile(`\[(\d+(?:\s*,\s*\d+)*)\]`)
+
+// markerSpace is a marker with the space before it
+var markerSpace = regexp.MustCompile(`\s*` + citationMarker.String())
+
+// citations returns the citations of the passages the answer refers to, in
+// marker order, and the markers it uses that number no passage
+func citations(answer string, docs []models.SearchResult) ([]models.Citation, []int) {
+	seen := map[int]bool{}
+	var cited []models.Citation
+	var unknown []int
+	for _, m := range citationMarker.FindAllStringSubmatch(answer, -1) {
+		for _, field := range strings.Split(m[1], ",") {
+			n, err := strconv.Atoi(strings.TrimSpace(field))
+			if err != nil || seen[n] {
+				continue
+			}
+			seen[n] = true
+			if n < 1 || n > len(docs) {
+				unknown = append(unknown, n)
+				continue
+			}
+			cited = append(cited, newCitation(n, docs[n-1]))
+		}
+	}
+	sort.Ints(unknown)
+	return cited, unknown
+}
+
+// newCitation describes the context passage numbered n
+func newCitation(n int, doc models.SearchResult) models.Citation {
+	c := models.Citation{
+		Number:      n,
+		Source:      doc.SourceFilename,
+		Collection:  doc.Collection,
+		Page:        doc.PageNumber,
+		ChunkID:     doc.ChunkID,
+		LastChunkID: doc.LastChunkID,
+		Score:       doc.Score,
+		Snippet:     snippet(doc.Content),
+	}
+	if doc.Source != nil {
+		c.MIMEType = doc.Source.MIMEType
+		c.IngestedAt = doc.Source.IngestedAt
+	}
+	for k, v := range doc.Metadata {
+		switch k {
+		case models.MetaChapter:
+			c.Chapter = v
+		case models.MetaSpeaker:
+			c.Speaker = v
+		case models.MetaChunkID:
+			// the chunk ID of the passage
+		default:
+			if v == "" {
+				continue
+			}
+			if c.Metadata == nil {
+				c.Metadata = map[string]string{}
+			}
+			c.Metadata[k] = v
+		}
+	}
+	return c
+}
+
+// snippet returns the first snippetLen characters of a passage, cut at a word
+// boundary
+func snippet(content string) string {
+	s := strings.Join(strings.Fields(content), " ")
+	runes := []rune(s)
+	if len(runes) <= snippetLen {
+		return s
+	}
+	s = string(runes[:snippetLen])
+	if i := strings.LastIndexByte(s, ' '); i > 0 {
+		s = s[:i]
+	}
+	return s + "..."
+}
+
+// stripCitations removes the markers of an answer, they number the passages
+// of its own turn
+func stripCitations(answer string) string {
+	return markerSpace.ReplaceAllString(answer, "")
+}
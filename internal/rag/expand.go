@@ -0,0 +1,188 @@
+package rag
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	"document-rag/internal/models"
+	"document-rag/internal/parser"
+	"document-rag/internal/store"
+)
+
+// expand widens every result with up to n chunks before and after it from
+// the same source, page and group, stitched with parser.CompleteContent.
+// Results that meet merge into one passage. Sources keep the order of their
+// first result, passages of a source come in document order.
+func (r *RAG) expand(ctx context.Context, docs []models.SearchResult) ([]models.SearchResult, error) {
+	n := r.cfg.RAG.Expand.Neighbours
+	if n <= 0 || len(docs) == 0 {
+		return docs, nil
+	}
+
+	stores := map[string]store.VectorStore{}
+	for _, c := range r.collections {
+		stores[c.Store.Info().Name] = c.Store
+	}
+
+	type sourceKey struct{ collection, source string }
+	hits := map[sourceKey][]models.SearchResult{}
+	var sources []sourceKey
+	for _, doc := range docs {
+		k := sourceKey{doc.Collection, doc.SourceFilename}
+		if _, ok := hits[k]; !ok {
+			sources = append(sources, k)
+		}
+		hits[k] = append(hits[k], doc)
+	}
+
+	var passages []models.SearchResult
+	for _, k := range sources {
+		vs, ok := stores[k.collection]
+		if !ok {
+			passages = append(passages, hits[k]...)
+			continue
+		}
+		chunks, err := vs.Chunks(ctx, k.source)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read chunks of %s: %w", k.source, err)
+		}
+		passages = append(passages, r.sourcePassages(chunks, hits[k], n)...)
+	}
+	return passages, nil
+}
+
+// sourcePassages expands the hits of one source over its chunks, in the page
+// and chunk order of VectorStore.Chunks
+func (r *RAG) sourcePassages(chunks []models.ChunkEmbedding, hits []models.SearchResult, n int) []models.SearchResult {
+	index := make(map[string]int, len(chunks))
+	positions := make(map[chunkPosition][]int, len(chunks))
+	for i, c := range chunks {
+		index[c.ID] = i
+		pos := chunkPosition{c.PageNumber, c.ChunkID}
+		positions[pos] = append(positions[pos], i)
+	}
+
+	// next links every included chunk to the chunk following it
+	included := map[int]bool{}
+	next := map[int]int{}
+	best := map[int]models.SearchResult{}
+	var passages []models.SearchResult
+	for _, hit := range hits {
+		h, ok := index[hit.ID]
+		if !ok {
+			passages = append(passages, hit)
+			continue
+		}
+		included[h] = true
+		best[h] = hit
+		for i, steps := h, 0; steps < n; steps++ {
+			prev := r.neighbour(chunks, positions, i, -1)
+			if prev < 0 {
+				break
+			}
+			included[prev], next[prev] = true, i
+			i = prev
+		}
+		for i, steps := h, 0; steps < n; steps++ {
+			following := r.neighbour(chunks, positions, i, 1)
+			if following < 0 {
+				break
+			}
+			included[following], next[i] = true, following
+			i = following
+		}
+	}
+
+	isNext := map[int]bool{}
+	for i, j := range next {
+		if included[i] {
+			isNext[j] = true
+		}
+	}
+	heads := make([]int, 0, len(included))
+	for i := range included {
+		if !isNext[i] {
+			heads = append(heads, i)
+		}
+	}
+	sort.Ints(heads)
+
+	overlap := r.cfg.RAG.ChunkOverlap
+	for _, head := range heads {
+		var run []models.Chunk
+		var top *models.SearchResult
+		last := head
+		for i, ok := head, true; ok; i, ok = next[i] {
+			run = append(run, models.Chunk{Content: chunks[i].Content, PageNumber: chunks[i].PageNumber, ChunkID: chunks[i].ChunkID})
+			if hit, isHit := best[i]; isHit && (top == nil || hit.Score > top.Score) {
+				top = &hit
+			}
+			last = i
+		}
+
+		if top == nil {
+			// the neighbour of an ambiguous chunk, linked to another run
+			continue
+		}
+		passage := *top
+		passage.ID = chunks[head].ID
+		passage.Content = parser.CompleteContent(run, overlap)
+		passage.PageNumber = chunks[head].PageNumber
+		passage.ChunkID = chunks[head].ChunkID
+		passage.LastChunkID = 0
+		if last != head {
+			passage.LastChunkID = chunks[last].ChunkID
+		}
+		passage.Embedding = nil
+		passages = append(passages, passage)
+	}
+	return passages
+}
+
+// chunkPosition is the page and chunk ID of a chunk, several chunks of a
+// source can share one
+type chunkPosition struct{ page, chunkID int }
+
+// neighbour returns the index of the chunk before (dir -1) or after (dir 1)
+// chunk i on its page and in its group, -1 if there is none. positions maps
+// the positions of the source to its chunks. A group can hold several runs
+// of text numbered from 1, such as the speeches of one speaker in a chapter,
+// so with a chunk overlap only the chunk overlapping chunk i is its
+// neighbour. Without, chunk IDs have to be unique in a group.
+func (r *RAG) neighbour(chunks []models.ChunkEmbedding, positions map[chunkPosition][]int, i, dir int) int {
+	c := chunks[i]
+	var candidates []int
+	for _, j := range positions[chunkPosition{c.PageNumber, c.ChunkID + dir}] {
+		if r.sameGroup(c, chunks[j]) {
+			candidates = append(candidates, j)
+		}
+	}
+	if r.cfg.RAG.ChunkOverlap <= 0 {
+		if len(candidates) == 1 {
+			return candidates[0]
+		}
+		return -1
+	}
+
+	found, longest := -1, 0
+	for _, j := range candidates {
+		prev, following := chunks[j].Content, c.Content
+		if dir > 0 {
+			prev, following = c.Content, chunks[j].Content
+		}
+		if n := parser.OverlapLen(prev, following, r.cfg.RAG.ChunkOverlap); n > longest {
+			found, longest = j, n
+		}
+	}
+	return found
+}
+
+func (r *RAG) sameGroup(a, b models.ChunkEmbedding) bool {
+	for _, key := range r.cfg.RAG.Expand.GroupBy {
+		if a.Metadata[key] != b.Metadata[key] {
+			return false
+		}
+	}
+	return true
+}
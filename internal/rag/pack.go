@@ -0,0 +1,223 @@
+package rag
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	"document-rag/internal/models"
+	"document-rag/internal/prompt"
+	"document-rag/internal/tokens"
+
+	"github.com/rs/zerolog/log"
+	"github.com/tmc/langchaingo/llms"
+)
+
+const (
+	defaultContextWindow = 8192
+	defaultAnswerTokens  = 1024
+	defaultMinTokens     = 64
+
+	overflowTruncate  = "truncate"
+	overflowSummarize = "summarize"
+	overflowDrop      = "drop"
+)
+
+// packPlan is how the context window of a query is spent, in tokens
+type packPlan struct {
+	Window, Answer, System, Question, History, Context int
+	Passages, Truncated, Summarized, Dropped           int
+	DroppedTurns                                       int
+}
+
+func (p packPlan) log() {
+	log.Info().
+		Int("window", p.Window).Int("answer", p.Answer).Int("system", p.System).
+		Int("question", p.Question).Int("history", p.History).Int("context", p.Context).
+		Int("free", p.Window-p.Answer-p.System-p.Question-p.History-p.Context).
+		Int("passages", p.Passages).Int("truncated", p.Truncated).Int("summarized", p.Summarized).
+		Int("dropped", p.Dropped).Int("dropped_turns", p.DroppedTurns).
+		Msg("Token plan")
+}
+
+// pack fits the passages and the history into the room the context window
+// leaves next to the answer and the messages tmpl renders without context.
+// The oldest turns of the history, then its summary, go first when it does
+// not leave room for a passage.
+// Passages are taken greedily by score, each counted with what tmpl renders
+// around it, such as its number, source and metadata. One that does not fit
+// is shortened to the room left or dropped, see PackingConfig. The kept
+// passages stay in their order.
+func (r *RAG) pack(ctx context.Context, query string, tmpl *prompt.Template, conv conversation, docs []models.SearchResult) ([]models.SearchResult, conversation, packPlan, error) {
+	plan := packPlan{
+		Window: r.cfg.QueryLLM.ContextWindow,
+		Answer: r.cfg.QueryLLM.MaxTokens,
+	}
+	if plan.Window <= 0 {
+		plan.Window = defaultContextWindow
+	}
+	if plan.Answer <= 0 {
+		plan.Answer = defaultAnswerTokens
+	}
+	minTokens := r.cfg.RAG.Packing.MinTokens
+	if minTokens <= 0 {
+		minTokens = defaultMinTokens
+	}
+
+	// templates can render the history too, so the messages are measured
+	// again after dropping a turn
+	var err error
+	for {
+		if plan.System, plan.Question, err = promptTokens(tmpl, conv.promptData(query)); err != nil {
+			return nil, conv, plan, err
+		}
+		plan.History = tokens.CountMessages(conv.messages())
+		if plan.Window-plan.Answer-plan.System-plan.Question-plan.History >= minTokens {
+			break
+		}
+		if len(conv.Turns) > 0 {
+			conv.Turns = conv.Turns[1:]
+			plan.DroppedTurns++
+		} else if conv.Summary != "" {
+			conv.Summary = ""
+		} else {
+			break
+		}
+	}
+	budget := plan.Window - plan.Answer - plan.History
+	base := plan.System + plan.Question
+	room := budget - base
+
+	order := make([]int, len(docs))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(a, b int) bool { return docs[order[a]].Score > docs[order[b]].Score })
+
+	keep := make([]bool, len(docs))
+	packed := make([]models.SearchResult, len(docs))
+	for _, i := range order {
+		doc := docs[i]
+		overhead, err := passageOverhead(tmpl, conv.promptData(query), doc, i+1, base)
+		if err != nil {
+			return nil, conv, plan, err
+		}
+		need := tokens.Count(doc.Content) + overhead
+		if need <= room {
+			keep[i], packed[i] = true, doc
+			room -= need
+			continue
+		}
+		limit := room - overhead
+		if limit < minTokens || r.cfg.RAG.Packing.Overflow == overflowDrop {
+			plan.Dropped++
+			continue
+		}
+		content, summarized := r.shorten(ctx, query, doc.Content, limit)
+		if content == "" {
+			plan.Dropped++
+			continue
+		}
+		if summarized {
+			plan.Summarized++
+		} else {
+			plan.Truncated++
+		}
+		doc.Content = content
+		keep[i], packed[i] = true, doc
+		room -= tokens.Count(content) + overhead
+	}
+
+	var kept []models.SearchResult
+	for i := range docs {
+		if keep[i] {
+			kept = append(kept, packed[i])
+		}
+	}
+
+	// counts are estimates that do not add up exactly, so the rendered prompt
+	// is measured as a whole and the weakest passages go while it overruns
+	for {
+		data := conv.promptData(query)
+		data.Chunks = promptChunks(kept)
+		system, user, err := promptTokens(tmpl, data)
+		if err != nil {
+			return nil, conv, plan, err
+		}
+		if system+user <= budget || len(kept) == 0 {
+			plan.Context = system + user - base
+			break
+		}
+		weakest := 0
+		for i, doc := range kept {
+			if doc.Score < kept[weakest].Score {
+				weakest = i
+			}
+		}
+		kept = append(kept[:weakest], kept[weakest+1:]...)
+		plan.Dropped++
+	}
+	plan.Passages = len(kept)
+	return kept, conv, plan, nil
+}
+
+// passageOverhead returns the tokens tmpl renders for doc as passage number
+// besides its content, base being the tokens of the messages without context
+func passageOverhead(tmpl *prompt.Template, data prompt.Data, doc models.SearchResult, number, base int) (int, error) {
+	doc.Content = ""
+	data.Chunks = promptChunks([]models.SearchResult{doc})
+	data.Chunks[0].Number = number
+	system, user, err := promptTokens(tmpl, data)
+	if err != nil {
+		return 0, err
+	}
+	return max(system+user-base, 0), nil
+}
+
+// promptTokens counts the system and user messages tmpl renders for data,
+// the system message being 0 when it is empty
+func promptTokens(tmpl *prompt.Template, data prompt.Data) (int, int, error) {
+	system, user, err := tmpl.Render(data)
+	if err != nil {
+		return 0, 0, err
+	}
+	systemTokens := 0
+	if system != "" {
+		systemTokens = tokens.CountMessages([]llms.MessageContent{llms.TextParts(llms.ChatMessageTypeSystem, system)})
+	}
+	return systemTokens, tokens.CountMessages([]llms.MessageContent{llms.TextParts(llms.ChatMessageTypeHuman, user)}), nil
+}
+
+// promptChunks numbers passages for the template data
+func promptChunks(docs []models.SearchResult) []prompt.Chunk {
+	chunks := make([]prompt.Chunk, len(docs))
+	for i, doc := range docs {
+		chunks[i] = prompt.Chunk{
+			Number:      i + 1,
+			Content:     doc.Content,
+			Source:      doc.SourceFilename,
+			Collection:  doc.Collection,
+			Page:        doc.PageNumber,
+			ChunkID:     doc.ChunkID,
+			LastChunkID: doc.LastChunkID,
+			Score:       doc.Score,
+			Metadata:    doc.Metadata,
+		}
+	}
+	return chunks
+}
+
+// shorten fits a passage into limit tokens, summarized by the query LLM with
+// overflow summarize and cut otherwise, or when the summary fails. It reports
+// whether the passage was summarized.
+func (r *RAG) shorten(ctx context.Context, query, content string, limit int) (string, bool) {
+	if r.cfg.RAG.Packing.Overflow == overflowSummarize {
+		// words run a little over a token each
+		summary, err := r.complete(ctx, fmt.Sprintf(models.CompressPromptTemplate, query, content, limit*3/4))
+		if err == nil && summary != "" {
+			return tokens.Truncate(summary, limit), true
+		}
+		log.Warn().Err(err).Msg("Failed to summarize passage, truncating it")
+	}
+	return tokens.Truncate(content, limit), false
+}
@@ -3,137 +3,354 @@ package rag
 import (
 	"context"
 	"fmt"
+	"sort"
 	"strings"
 
 	"document-rag/internal/config"
-	"document-rag/internal/db"
+	"document-rag/internal/filter"
+	"document-rag/internal/fusion"
+	"document-rag/internal/mmr"
 	"document-rag/internal/models"
+	"document-rag/internal/prompt"
+	"document-rag/internal/rerank"
+	"document-rag/internal/store"
 
-	"document-rag/internal/chromemdb"
 	"document-rag/internal/llmservice"
 
-	"github.com/philippgille/chromem-go"
+	"github.com/rs/zerolog/log"
 	"github.com/tmc/langchaingo/embeddings"
 	"github.com/tmc/langchaingo/llms"
-	"github.com/uptrace/bun"
 )
 
 type RAG struct {
-	db         *bun.DB
-	chromemdb  *chromemdb.VectorDBManager
-	embedder   *embeddings.EmbedderImpl
-	cfg        *config.Config
-	maxResults int
+	collections []Collection
+	cfg         *config.Config
+	maxResults  int
+	reranker    rerank.Reranker
+	prompts     *prompt.Set
+}
+
+// Collection is a vector store queried together with the embedder of the
+// model its chunks were embedded with
+type Collection struct {
+	Store    store.VectorStore
+	Embedder *embeddings.EmbedderImpl
+}
+
+// OpenCollections opens existing collections for querying, each with the
+// embedder of its model. A missing collection is models.ErrCollectionNotFound.
+func OpenCollections(ctx context.Context, backend store.Backend, names []string, embedCfg config.LLMConfig) ([]Collection, error) {
+	var collections []Collection
+	for _, name := range names {
+		name = strings.TrimSpace(name)
+		if _, err := backend.Describe(ctx, name); err != nil {
+			return nil, err
+		}
+		vectorStore, embedder, err := store.OpenCollection(ctx, backend, name, embedCfg, false)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open collection %s: %w", name, err)
+		}
+		collections = append(collections, Collection{Store: vectorStore, Embedder: embedder})
+	}
+	return collections, nil
 }
 
 const defaultMaxResults = 5
 
-func NewRAG(db *bun.DB, chromemdb *chromemdb.VectorDBManager, embedder *embeddings.EmbedderImpl, cfg *config.Config) *RAG {
+// NotFoundAnswer is the answer to a query no chunk is relevant enough for
+const NotFoundAnswer = "No relevant documents found."
+
+// NewRAG answers queries from one or several collections, results of all
+// collections are merged by score and reranked when a reranker is configured
+func NewRAG(collections []Collection, cfg *config.Config) (*RAG, error) {
+	prompts, err := prompt.Load(&cfg.Prompts)
+	if err != nil {
+		return nil, err
+	}
+	return NewRAGWithPrompts(collections, cfg, prompts)
+}
+
+// NewRAGWithPrompts is NewRAG with prompt templates loaded by the caller
+func NewRAGWithPrompts(collections []Collection, cfg *config.Config, prompts *prompt.Set) (*RAG, error) {
+	reranker, err := rerank.New(&cfg.RAG.Rerank, &cfg.QueryLLM)
+	if err != nil {
+		return nil, err
+	}
+	switch cfg.RAG.Packing.Overflow {
+	case "", overflowTruncate, overflowSummarize, overflowDrop:
+	default:
+		return nil, fmt.Errorf("unsupported packing overflow: %s", cfg.RAG.Packing.Overflow)
+	}
 	return &RAG{
-		db:        db,
-		chromemdb: chromemdb,
-		embedder:  embedder,
-		cfg:       cfg,
+		collections: collections,
+		cfg:         cfg,
 		maxResults: func() int {
 			if cfg.RAG.MaxResults > 0 {
 				return cfg.RAG.MaxResults
 			}
 			return defaultMaxResults
 		}(),
-	}
+		reranker: reranker,
+		prompts:  prompts,
+	}, nil
+}
+
+// WithCollections returns a RAG answering from other collections that shares
+// the reranker and prompt templates of r
+func (r *RAG) WithCollections(collections []Collection) *RAG {
+	copied := *r
+	copied.collections = collections
+	return &copied
 }
 
+// QueryOptions narrows the retrieval done for a single query
+type QueryOptions struct {
+	// Filter restricts retrieval to chunks matching the expression, see filter.Parse
+	Filter filter.Expr
+	// Mode overrides the configured search mode
+	Mode models.SearchMode
+	// Prompt names the prompt template, by default the one of the queried
+	// collections or prompts.default
+	Prompt string
+	// Stream receives the answer token by token as it is generated, then
+	// the final event. An error it returns stops the generation.
+	Stream StreamFunc
+}
+
+// StreamFunc receives the events of a streamed answer
+type StreamFunc func(ctx context.Context, event models.StreamEvent) error
+
 func (r *RAG) Query(ctx context.Context, query string) (models.PromptResponse, error) {
-	rsp := models.PromptResponse{
-		Query:   query,
-		Source:  "",
-		Content: "",
-	}
-	queryEmbedding, err := r.embedder.EmbedQuery(ctx, query)
-	if err != nil {
-		return rsp, err
+	return r.QueryWithOptions(ctx, query, QueryOptions{})
+}
+
+// QueryStream answers like QueryWithOptions and streams the answer to fn as
+// it is generated, see QueryOptions.Stream. Cancelling ctx stops the
+// generation.
+func (r *RAG) QueryStream(ctx context.Context, query string, opts QueryOptions, fn StreamFunc) (models.PromptResponse, error) {
+	opts.Stream = fn
+	return r.QueryWithOptions(ctx, query, opts)
+}
+
+// search retrieves the best chunks of every collection and keeps the overall
+// best that pass the score cutoff. With query transforms configured, every
+// variant of the query is searched and the rankings are merged with rank
+// fusion. With MMR enabled the k chunks are re-selected from the fetch_k best
+// for diversity.
+func (r *RAG) search(ctx context.Context, query string, k int, opts QueryOptions) ([]models.SearchResult, error) {
+	mode := opts.Mode
+	if mode == "" {
+		var err error
+		if mode, err = models.ParseSearchMode(r.cfg.RAG.SearchMode); err != nil {
+			return nil, err
+		}
 	}
 
-	// if maxResults is 0, use default value
-	if r.maxResults == 0 {
-		r.maxResults = defaultMaxResults
+	mmrCfg := r.cfg.RAG.MMR
+	fetch := k
+	if mmrCfg.Enabled {
+		fetch = mmrCfg.FetchK
+		if fetch <= 0 {
+			fetch = 4 * k
+		}
+		fetch = max(fetch, k)
+	}
+	req := models.SearchRequest{
+		K:    fetch,
+		Mode: mode,
+		Hybrid: models.HybridOptions{
+			Fusion:       r.cfg.RAG.Hybrid.Fusion,
+			VectorWeight: r.cfg.RAG.Hybrid.VectorWeight,
+			TextWeight:   r.cfg.RAG.Hybrid.TextWeight,
+			RRFK:         r.cfg.RAG.Hybrid.RRFK,
+			Candidates:   r.cfg.RAG.Hybrid.Candidates,
+		},
+		Filter:         opts.Filter,
+		WithEmbeddings: mmrCfg.Enabled,
 	}
 
-	var qContext strings.Builder
-	var references []string
-	if r.db != nil {
-		docs, err := db.SearchDocuments(ctx, r.db, queryEmbedding, r.maxResults)
+	var lists []fusion.List
+	for _, variant := range r.queryVariants(ctx, query) {
+		docs, err := r.searchCollections(ctx, variant, req)
 		if err != nil {
-			return rsp, err
+			return nil, err
 		}
+		docs = cutoff(docs, r.cfg.RAG.MinScore, r.cfg.RAG.ScoreGap)
+		if len(docs) > fetch {
+			docs = docs[:fetch]
+		}
+		lists = append(lists, fusion.List{Results: docs, Weight: 1})
+	}
+	docs := lists[0].Results
+	if len(lists) > 1 {
+		docs = fusion.RRF(r.cfg.RAG.Hybrid.RRFK, lists...)
+	}
 
-		for i, doc := range docs {
-			qContext.WriteString(doc.Content + "\n\n")
-
-			// Build reference string
-			ref := fmt.Sprintf("Source: %s, Page: %d, Chunk: %d", doc.SourceFilename, doc.PageNumber, doc.ChunkID)
-			references = append(references, fmt.Sprintf("[%d] %s", i+1, ref))
+	if mmrCfg.Enabled {
+		if len(docs) > fetch {
+			docs = docs[:fetch]
 		}
-	} else if r.chromemdb != nil {
-		queryOptions := chromem.QueryOptions{
-			QueryText:      query,
-			QueryEmbedding: queryEmbedding,
-			NResults:       r.maxResults,
+		return mmr.Select(docs, k, mmrCfg.Lambda), nil
+	}
+	if len(docs) > k {
+		docs = docs[:k]
+	}
+	return docs, nil
+}
+
+// searchCollections runs a search for query in every collection and merges
+// the results by score. The query is embedded once per embedding model.
+func (r *RAG) searchCollections(ctx context.Context, query string, req models.SearchRequest) ([]models.SearchResult, error) {
+	req.Text = query
+	queryEmbeddings := map[string][]float32{}
+	var docs []models.SearchResult
+	for _, c := range r.collections {
+		model := c.Store.Info().EmbeddingModel
+		queryEmbedding, ok := queryEmbeddings[model]
+		if !ok && req.Mode != models.SearchText {
+			var err error
+			queryEmbedding, err = c.Embedder.EmbedQuery(ctx, query)
+			if err != nil {
+				return nil, err
+			}
+			queryEmbeddings[model] = queryEmbedding
 		}
-		docs, err := r.chromemdb.SearchWithQueryOptions(ctx, queryOptions)
+
+		req.Embedding = queryEmbedding
+		results, err := c.Store.Search(ctx, req)
 		if err != nil {
-			return rsp, err
+			return nil, fmt.Errorf("collection %s: %w", c.Store.Info().Name, err)
 		}
+		docs = append(docs, results...)
+	}
 
-		for _, doc := range docs {
-			qContext.WriteString(doc.Content + "\n\n")
+	sort.SliceStable(docs, func(i, j int) bool {
+		return docs[i].Score > docs[j].Score
+	})
+	return docs, nil
+}
 
-			// Build reference string
-			// ref := fmt.Sprintf("Source: %s, Page: %d, Chunk: %d", doc.Metadata["source_filename"], doc.Metadata["page_number"], doc.Metadata["chunk_id"])
-			references = append(references, fmt.Sprintf("%v", doc.Metadata))
+// retrieve searches the chunks used as context, over-fetching for the
+// reranker when there is one
+func (r *RAG) retrieve(ctx context.Context, query string, opts QueryOptions) ([]models.SearchResult, error) {
+	if r.reranker == nil {
+		return r.search(ctx, query, r.maxResults, opts)
+	}
+
+	rerankCfg := r.cfg.RAG.Rerank
+	topK := rerankCfg.TopK
+	if topK <= 0 {
+		topK = r.maxResults
+	}
+	fetchK := rerankCfg.FetchK
+	if fetchK <= 0 {
+		fetchK = 4 * topK
+	}
+	docs, err := r.search(ctx, query, max(fetchK, topK), opts)
+	if err != nil {
+		return nil, err
+	}
+	return rerank.Apply(ctx, r.reranker, query, docs, topK)
+}
+
+// cutoff drops the results of a ranking scoring below minScore and, with a
+// gap set, ends it at the first drop between neighbours at least that large
+func cutoff(docs []models.SearchResult, minScore, gap float64) []models.SearchResult {
+	for i, doc := range docs {
+		if float64(doc.Score) < minScore {
+			return docs[:i]
+		}
+		if gap > 0 && i > 0 && float64(docs[i-1].Score-doc.Score) >= gap {
+			log.Debug().Msgf("Score gap after %d results, %.3f to %.3f", i, docs[i-1].Score, doc.Score)
+			return docs[:i]
 		}
 	}
+	return docs
+}
 
-	if qContext.String() == "" {
-		return rsp, fmt.Errorf("no documents found")
+func (r *RAG) QueryWithOptions(ctx context.Context, query string, opts QueryOptions) (models.PromptResponse, error) {
+	return r.answer(ctx, query, query, conversation{}, opts)
+}
+
+// answer retrieves context for searchQuery and answers query with it. The
+// history of a conversation goes between the system prompt and the query.
+func (r *RAG) answer(ctx context.Context, query, searchQuery string, conv conversation, opts QueryOptions) (models.PromptResponse, error) {
+	rsp := models.PromptResponse{
+		Query:   query,
+		Source:  "",
+		Content: "",
+	}
+
+	names := make([]string, len(r.collections))
+	for i, c := range r.collections {
+		names[i] = c.Store.Info().Name
+	}
+	tmpl, err := r.prompts.ForCollections(opts.Prompt, names)
+	if err != nil {
+		return rsp, err
+	}
+
+	var qContext strings.Builder
+	docs, err := r.retrieve(ctx, searchQuery, opts)
+	if err != nil {
+		return rsp, err
+	}
+	if docs, err = r.expand(ctx, docs); err != nil {
+		return rsp, err
+	}
+	if len(docs) > 0 {
+		var plan packPlan
+		if docs, conv, plan, err = r.pack(ctx, query, tmpl, conv, docs); err != nil {
+			return rsp, err
+		}
+		plan.log()
+	}
+	rsp.Results = docs
+	// weak matches would only mislead the LLM, answer without it
+	if len(docs) == 0 {
+		rsp.Content = NotFoundAnswer
+		if opts.Stream != nil {
+			if err := opts.Stream(ctx, models.StreamEvent{Token: NotFoundAnswer}); err != nil {
+				return rsp, err
+			}
+		}
+		return rsp, finishStream(ctx, opts, &rsp)
+	}
+
+	// passages are numbered for the answer to cite them
+	for i, doc := range docs {
+		fmt.Fprintf(&qContext, "[%d] %s\n\n", i+1, doc.Content)
 	}
 
 	rsp.Source = qContext.String()
 
-	// llm, err := openai.New(
-	// 	openai.WithBaseURL(r.cfg.QueryLLM.BaseURL),
-	// 	openai.WithToken(strings.TrimPrefix(r.cfg.QueryLLM.Key, "Bearer ")),
-	// 	openai.WithModel(r.cfg.QueryLLM.Model),
-	// )
-	// if err != nil {
-	// 	return rsp, err
-	// }
-
-	var response strings.Builder
-	prompt := fmt.Sprintf("Based on the following context, answer the query: %s\n\nContext:\n%s", query, qContext.String())
-	msgContent := []llms.MessageContent{
-		llms.MessageContent{
+	data := conv.promptData(query)
+	data.Chunks = promptChunks(docs)
+	system, userPrompt, err := tmpl.Render(data)
+	if err != nil {
+		return rsp, err
+	}
+	var msgContent []llms.MessageContent
+	if system != "" {
+		msgContent = append(msgContent, llms.MessageContent{
 			Role:  llms.ChatMessageTypeSystem,
-			Parts: []llms.ContentPart{llms.TextContent{Text: "You are a helpful assistant. Answer the query based only on the provided context. If the context does not contain the answer, respond with 'I don't know.'"}},
-		},
-		llms.MessageContent{
-			Role:  llms.ChatMessageTypeHuman,
-			Parts: []llms.ContentPart{llms.TextContent{Text: prompt}},
-		},
+			Parts: []llms.ContentPart{llms.TextContent{Text: system}},
+		})
 	}
+	msgContent = append(msgContent, conv.messages()...)
+	msgContent = append(msgContent, llms.MessageContent{
+		Role:  llms.ChatMessageTypeHuman,
+		Parts: []llms.ContentPart{llms.TextContent{Text: userPrompt}},
+	})
 
-	// Stream the response
-	// _, err = llm.GenerateContent(ctx, msgContent, llms.WithStreamingFunc(func(ctx context.Context, chunk []byte) error {
-	// 	chunkStr := string(chunk)
-	// 	if strings.Contains(chunkStr, ": OPENROUTER PROCESSING") {
-	// 		return nil
-	// 	}
-	// 	response.WriteString(chunkStr)
-	// 	return nil
-	// }))
-
-	// res, err := llm.GenerateContent(ctx, msgContent)
-	res, err := llmservice.GenerateContent(ctx, &r.cfg.QueryLLM, nil, msgContent)
+	var res *llms.ContentResponse
+	if opts.Stream != nil {
+		res, err = llmservice.StreamContent(ctx, &r.cfg.QueryLLM, msgContent, func(ctx context.Context, chunk string) error {
+			return opts.Stream(ctx, models.StreamEvent{Token: chunk})
+		})
+	} else {
+		res, err = llmservice.GenerateContent(ctx, &r.cfg.QueryLLM, nil, msgContent)
+	}
 	if err != nil {
 		return rsp, err
 	}
@@ -141,15 +358,19 @@ func (r *RAG) Query(ctx context.Context, query string) (models.PromptResponse, e
 	if len(res.Choices) == 0 {
 		return rsp, fmt.Errorf("no response from LLM")
 	}
-	response.WriteString(res.Choices[0].Content)
-
-	// Append references to the response
-	response.WriteString("\n\nReferences:\n")
-	for _, ref := range references {
-		response.WriteString(ref + "\n")
+	rsp.Content = res.Choices[0].Content
+	rsp.Citations, rsp.UnknownCitations = citations(rsp.Content, docs)
+	if len(rsp.UnknownCitations) > 0 {
+		log.Warn().Ints("markers", rsp.UnknownCitations).Msg("Answer cites passages that are not in the context")
 	}
 
-	rsp.Content = response.String()
+	return rsp, finishStream(ctx, opts, &rsp)
+}
 
-	return rsp, nil
+// finishStream sends the final event of a streamed answer
+func finishStream(ctx context.Context, opts QueryOptions, rsp *models.PromptResponse) error {
+	if opts.Stream == nil {
+		return nil
+	}
+	return opts.Stream(ctx, models.StreamEvent{Done: true, Response: rsp})
 }
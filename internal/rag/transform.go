@@ -0,0 +1,79 @@
+package rag
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"document-rag/internal/llmservice"
+	"document-rag/internal/models"
+
+	"github.com/rs/zerolog/log"
+	"github.com/tmc/langchaingo/llms"
+)
+
+var (
+	thinkTag   = regexp.MustCompile(models.ThinkTag)
+	listMarker = regexp.MustCompile(`^\s*(?:[-*•]|\d+[.)])\s*`)
+)
+
+// queryVariants returns the queries searched for a question: the question
+// first, then its rewrite, its paraphrases and a hypothetical answer, as
+// configured. A transform that fails is skipped, the question is always
+// searched.
+func (r *RAG) queryVariants(ctx context.Context, query string) []string {
+	transform := r.cfg.RAG.Transform
+	variants := []string{query}
+
+	if transform.Rewrite {
+		rewritten, err := r.complete(ctx, fmt.Sprintf(models.RewritePromptTemplate, query))
+		if err != nil {
+			log.Warn().Err(err).Msg("Query rewrite failed")
+		} else if rewritten != "" && rewritten != query {
+			variants = append(variants, rewritten)
+			log.Debug().Str("query", rewritten).Msg("Rewrote query")
+		}
+	}
+
+	if transform.MultiQuery > 0 {
+		answer, err := r.complete(ctx, fmt.Sprintf(models.MultiQueryPromptTemplate, transform.MultiQuery, query))
+		if err != nil {
+			log.Warn().Err(err).Msg("Multi-query expansion failed")
+		}
+		var paraphrases []string
+		for _, line := range strings.Split(answer, "\n") {
+			line = strings.TrimSpace(listMarker.ReplaceAllString(line, ""))
+			if line != "" && len(paraphrases) < transform.MultiQuery {
+				paraphrases = append(paraphrases, line)
+			}
+		}
+		log.Debug().Strs("queries", paraphrases).Msg("Expanded query")
+		variants = append(variants, paraphrases...)
+	}
+
+	if transform.HyDE {
+		passage, err := r.complete(ctx, fmt.Sprintf(models.HyDEPromptTemplate, query))
+		if err != nil {
+			log.Warn().Err(err).Msg("Hypothetical document generation failed")
+		} else if passage != "" {
+			log.Debug().Str("passage", passage).Msg("Generated hypothetical document")
+			variants = append(variants, passage)
+		}
+	}
+	return variants
+}
+
+// complete returns the query LLM answer to a prompt without its reasoning
+func (r *RAG) complete(ctx context.Context, prompt string) (string, error) {
+	res, err := llmservice.GenerateContent(ctx, &r.cfg.QueryLLM, nil, []llms.MessageContent{
+		llms.TextParts(llms.ChatMessageTypeHuman, prompt),
+	})
+	if err != nil {
+		return "", err
+	}
+	if len(res.Choices) == 0 {
+		return "", fmt.Errorf("no response from LLM")
+	}
+	return strings.TrimSpace(thinkTag.ReplaceAllString(res.Choices[0].Content, "")), nil
+}
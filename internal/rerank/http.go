@@ -0,0 +1,95 @@
+package rerank
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"document-rag/internal/config"
+	"document-rag/internal/models"
+)
+
+// HTTPReranker calls a Cohere style /rerank endpoint, as served by Cohere,
+// Jina, Voyage and local rerank servers
+type HTTPReranker struct {
+	cfg    *config.LLMConfig
+	client *http.Client
+}
+
+func NewHTTPReranker(cfg *config.LLMConfig) *HTTPReranker {
+	return &HTTPReranker{cfg: cfg, client: &http.Client{Timeout: 60 * time.Second}}
+}
+
+type rerankRequest struct {
+	Model     string   `json:"model,omitempty"`
+	Query     string   `json:"query"`
+	Documents []string `json:"documents"`
+	TopN      int      `json:"top_n"`
+}
+
+type rerankResponse struct {
+	Results []struct {
+		Index          int     `json:"index"`
+		RelevanceScore float32 `json:"relevance_score"`
+	} `json:"results"`
+}
+
+// Rerank posts the query with every document and reads back a relevance
+// score per document index
+func (h *HTTPReranker) Rerank(ctx context.Context, query string, docs []models.SearchResult) ([]float32, error) {
+	req := rerankRequest{Model: h.cfg.Model, Query: query, TopN: len(docs)}
+	for _, doc := range docs {
+		req.Documents = append(req.Documents, doc.Content)
+	}
+	body, err := json.Marshal(req)
+	if err != nil {
+		return nil, err
+	}
+
+	url := strings.TrimSuffix(h.cfg.BaseURL, "/")
+	if !strings.HasSuffix(url, "/rerank") {
+		url += "/rerank"
+	}
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+	if key := strings.TrimPrefix(h.cfg.Key, "Bearer "); key != "" {
+		httpReq.Header.Set("Authorization", "Bearer "+key)
+	}
+
+	resp, err := h.client.Do(httpReq)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return nil, fmt.Errorf("rerank endpoint returned %s: %s", resp.Status, strings.TrimSpace(string(msg)))
+	}
+
+	var rsp rerankResponse
+	if err := json.NewDecoder(resp.Body).Decode(&rsp); err != nil {
+		return nil, fmt.Errorf("invalid rerank response: %w", err)
+	}
+	scores := make([]float32, len(docs))
+	seen := make([]bool, len(docs))
+	for _, r := range rsp.Results {
+		if r.Index < 0 || r.Index >= len(docs) {
+			return nil, fmt.Errorf("rerank response has unknown document index %d", r.Index)
+		}
+		scores[r.Index], seen[r.Index] = r.RelevanceScore, true
+	}
+	for i, ok := range seen {
+		if !ok {
+			return nil, fmt.Errorf("rerank response has no score for document %d", i)
+		}
+	}
+	return scores, nil
+}
@@ -0,0 +1,131 @@
+package rerank
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"document-rag/internal/config"
+	"document-rag/internal/models"
+)
+
+// rerankServer stands in for a rerank endpoint answering status with body,
+// and records the path, authorization header and body of the last request
+type rerankServer struct {
+	*httptest.Server
+	status int
+	body   string
+
+	path string
+	auth string
+	req  rerankRequest
+}
+
+func newRerankServer(t *testing.T, status int, body string) *rerankServer {
+	s := &rerankServer{status: status, body: body}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.path = r.URL.Path
+		s.auth = r.Header.Get("Authorization")
+		if err := json.NewDecoder(r.Body).Decode(&s.req); err != nil {
+			t.Errorf("invalid request body: %v", err)
+		}
+		w.WriteHeader(s.status)
+		w.Write([]byte(s.body))
+	}))
+	t.Cleanup(s.Close)
+	return s
+}
+
+func searchResults(contents ...string) []models.SearchResult {
+	docs := make([]models.SearchResult, len(contents))
+	for i, c := range contents {
+		docs[i] = models.SearchResult{Content: c}
+	}
+	return docs
+}
+
+func TestHTTPRerankerScoresByIndex(t *testing.T) {
+	s := newRerankServer(t, http.StatusOK, `{"results": [
+		{"index": 2, "relevance_score": 0.9},
+		{"index": 0, "relevance_score": 0.2},
+		{"index": 1, "relevance_score": 0.5}
+	]}`)
+	reranker := NewHTTPReranker(&config.LLMConfig{BaseURL: s.URL, Model: "rerank-model"})
+
+	scores, err := reranker.Rerank(context.Background(), "the query", searchResults("a", "b", "c"))
+	if err != nil {
+		t.Fatalf("Rerank: %v", err)
+	}
+	want := []float32{0.2, 0.5, 0.9}
+	for i := range want {
+		if scores[i] != want[i] {
+			t.Fatalf("scores = %v, want %v", scores, want)
+		}
+	}
+	if s.req.Query != "the query" || s.req.Model != "rerank-model" || s.req.TopN != 3 ||
+		strings.Join(s.req.Documents, ",") != "a,b,c" {
+		t.Errorf("unexpected request %+v", s.req)
+	}
+}
+
+func TestHTTPRerankerBadIndex(t *testing.T) {
+	tests := []struct {
+		name string
+		body string
+	}{
+		{"missing", `{"results": [{"index": 0, "relevance_score": 0.2}]}`},
+		{"out of range", `{"results": [{"index": 0, "relevance_score": 0.2}, {"index": 2, "relevance_score": 0.5}]}`},
+		{"negative", `{"results": [{"index": -1, "relevance_score": 0.2}, {"index": 1, "relevance_score": 0.5}]}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newRerankServer(t, http.StatusOK, tt.body)
+			reranker := NewHTTPReranker(&config.LLMConfig{BaseURL: s.URL})
+			if _, err := reranker.Rerank(context.Background(), "q", searchResults("a", "b")); err == nil {
+				t.Fatal("expected an error")
+			}
+		})
+	}
+}
+
+func TestHTTPRerankerErrorStatus(t *testing.T) {
+	s := newRerankServer(t, http.StatusTooManyRequests, "rate limited")
+	reranker := NewHTTPReranker(&config.LLMConfig{BaseURL: s.URL})
+
+	_, err := reranker.Rerank(context.Background(), "q", searchResults("a"))
+	if err == nil || !strings.Contains(err.Error(), "429") || !strings.Contains(err.Error(), "rate limited") {
+		t.Fatalf("err = %v, want the status and body", err)
+	}
+}
+
+func TestHTTPRerankerRequest(t *testing.T) {
+	tests := []struct {
+		name     string
+		suffix   string
+		key      string
+		wantPath string
+		wantAuth string
+	}{
+		{"base url", "", "secret", "/rerank", "Bearer secret"},
+		{"trailing slash", "/v1/", "Bearer secret", "/v1/rerank", "Bearer secret"},
+		{"full url", "/v1/rerank", "", "/v1/rerank", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newRerankServer(t, http.StatusOK, `{"results": [{"index": 0, "relevance_score": 1}]}`)
+			reranker := NewHTTPReranker(&config.LLMConfig{BaseURL: s.URL + tt.suffix, Key: tt.key})
+			if _, err := reranker.Rerank(context.Background(), "q", searchResults("a")); err != nil {
+				t.Fatalf("Rerank: %v", err)
+			}
+			if s.path != tt.wantPath {
+				t.Errorf("path = %q, want %q", s.path, tt.wantPath)
+			}
+			if s.auth != tt.wantAuth {
+				t.Errorf("Authorization = %q, want %q", s.auth, tt.wantAuth)
+			}
+		})
+	}
+}
@@ -0,0 +1,68 @@
+package rerank
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"document-rag/internal/config"
+	"document-rag/internal/llmservice"
+	"document-rag/internal/models"
+
+	"github.com/tmc/langchaingo/llms"
+)
+
+// LLMReranker asks a chat model to grade every document from 0 to 10 in a
+// single call
+type LLMReranker struct {
+	cfg *config.LLMConfig
+}
+
+func NewLLMReranker(cfg *config.LLMConfig) *LLMReranker {
+	return &LLMReranker{cfg: cfg}
+}
+
+var thinkTag = regexp.MustCompile(models.ThinkTag)
+
+// Rerank returns the grades divided by 10
+func (l *LLMReranker) Rerank(ctx context.Context, query string, docs []models.SearchResult) ([]float32, error) {
+	var passages strings.Builder
+	for i, doc := range docs {
+		fmt.Fprintf(&passages, "<passage %d>\n%s\n</passage %d>\n", i+1, strings.TrimSpace(doc.Content), i+1)
+	}
+	prompt := fmt.Sprintf(models.RerankPromptTemplate, query, passages.String(), len(docs))
+
+	res, err := llmservice.GenerateContent(ctx, l.cfg, nil, []llms.MessageContent{
+		llms.TextParts(llms.ChatMessageTypeHuman, prompt),
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(res.Choices) == 0 {
+		return nil, fmt.Errorf("no response from LLM")
+	}
+	return parseGrades(res.Choices[0].Content, len(docs))
+}
+
+// parseGrades reads the JSON array of grades out of an answer, ignoring
+// reasoning and text around it
+func parseGrades(answer string, n int) ([]float32, error) {
+	answer = thinkTag.ReplaceAllString(answer, "")
+	start, end := strings.Index(answer, "["), strings.LastIndex(answer, "]")
+	if start < 0 || end < start {
+		return nil, fmt.Errorf("no grades in LLM answer: %q", answer)
+	}
+	var grades []float32
+	if err := json.Unmarshal([]byte(answer[start:end+1]), &grades); err != nil {
+		return nil, fmt.Errorf("invalid grades in LLM answer: %w", err)
+	}
+	if len(grades) != n {
+		return nil, fmt.Errorf("LLM graded %d of %d passages", len(grades), n)
+	}
+	for i := range grades {
+		grades[i] = min(max(grades[i], 0), 10) / 10
+	}
+	return grades, nil
+}
@@ -0,0 +1,43 @@
+package rerank
+
+import "testing"
+
+func TestParseGrades(t *testing.T) {
+	tests := []struct {
+		name    string
+		answer  string
+		n       int
+		want    []float32
+		wantErr bool
+	}{
+		{"plain", "[10, 5, 0]", 3, []float32{1, 0.5, 0}, false},
+		{"think tags", "<think>maybe [1, 2] or [3]</think>[7, 3]", 2, []float32{0.7, 0.3}, false},
+		{"surrounding text", "Here are the grades: [4, 8].\nDone.", 2, []float32{0.4, 0.8}, false},
+		{"clamped", "[-2, 15]", 2, []float32{0, 1}, false},
+		{"count mismatch", "[1, 2]", 3, nil, true},
+		{"no array", "I cannot grade these", 1, nil, true},
+		{"invalid json", "[1, two]", 2, nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseGrades(tt.answer, tt.n)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("expected an error, got %v", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("parseGrades: %v", err)
+			}
+			if len(got) != len(tt.want) {
+				t.Fatalf("got %v, want %v", got, tt.want)
+			}
+			for i := range tt.want {
+				if got[i] != tt.want[i] {
+					t.Fatalf("got %v, want %v", got, tt.want)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,91 @@
+// Package rerank reorders retrieved chunks with a model that reads the query
+// and each chunk together, which ranks better than embedding similarity but
+// is too slow to run over a whole collection.
+package rerank
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	"document-rag/internal/config"
+	"document-rag/internal/models"
+
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	TypeNone = "none"
+	TypeHTTP = "http"
+	TypeLLM  = "llm"
+)
+
+// Reranker scores how relevant each document is to the query, higher is more
+// relevant. Scores are returned in document order.
+type Reranker interface {
+	Rerank(ctx context.Context, query string, docs []models.SearchResult) ([]float32, error)
+}
+
+// New returns the reranker of the config, nil when reranking is off. The
+// llm reranker judges with queryLLM unless the config names its own model.
+func New(cfg *config.RerankConfig, queryLLM *config.LLMConfig) (Reranker, error) {
+	switch strings.ToLower(cfg.Type) {
+	case "", TypeNone:
+		return nil, nil
+	case TypeHTTP:
+		if cfg.LLM.BaseURL == "" {
+			return nil, fmt.Errorf("the http reranker needs rerank.llm.llm_base_url")
+		}
+		return NewHTTPReranker(&cfg.LLM), nil
+	case TypeLLM:
+		llmCfg := cfg.LLM
+		if llmCfg.Model == "" {
+			llmCfg = *queryLLM
+		}
+		return NewLLMReranker(&llmCfg), nil
+	default:
+		return nil, fmt.Errorf("unsupported reranker: %s", cfg.Type)
+	}
+}
+
+// Apply reranks docs and returns the best k with Score set to the reranker
+// score. The rank and score each result had before are kept in
+// RetrievalRank and RetrievalScore.
+func Apply(ctx context.Context, rr Reranker, query string, docs []models.SearchResult, k int) ([]models.SearchResult, error) {
+	if len(docs) == 0 {
+		return docs, nil
+	}
+	scores, err := rr.Rerank(ctx, query, docs)
+	if err != nil {
+		return nil, fmt.Errorf("failed to rerank: %w", err)
+	}
+	if len(scores) != len(docs) {
+		return nil, fmt.Errorf("failed to rerank: got %d scores for %d documents", len(scores), len(docs))
+	}
+
+	reranked := make([]models.SearchResult, len(docs))
+	for i, doc := range docs {
+		doc.RetrievalRank = i + 1
+		doc.RetrievalScore = doc.Score
+		doc.Score = scores[i]
+		reranked[i] = doc
+	}
+	sort.SliceStable(reranked, func(i, j int) bool {
+		return reranked[i].Score > reranked[j].Score
+	})
+	if len(reranked) > k {
+		reranked = reranked[:k]
+	}
+
+	for i, doc := range reranked {
+		log.Debug().
+			Str("id", doc.ID).
+			Int("retrieval_rank", doc.RetrievalRank).
+			Float32("retrieval_score", doc.RetrievalScore).
+			Int("rank", i+1).
+			Float32("score", doc.Score).
+			Msg("Reranked")
+	}
+	return reranked, nil
+}
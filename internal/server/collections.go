@@ -0,0 +1,71 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"document-rag/internal/config"
+	"document-rag/internal/rag"
+	"document-rag/internal/store"
+)
+
+// collectionCache keeps the collections the server opened with the embedders
+// of their models, so requests do not probe the embedding model and set up
+// the collection again
+type collectionCache struct {
+	backend  store.Backend
+	embedCfg config.LLMConfig
+
+	mu   sync.Mutex
+	open map[string]rag.Collection
+}
+
+func newCollectionCache(backend store.Backend, embedCfg config.LLMConfig) *collectionCache {
+	return &collectionCache{backend: backend, embedCfg: embedCfg, open: map[string]rag.Collection{}}
+}
+
+// get returns an open collection, opening it on first use. With create a
+// missing collection is created, otherwise it is models.ErrCollectionNotFound.
+func (c *collectionCache) get(ctx context.Context, name string, create bool) (rag.Collection, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if collection, ok := c.open[name]; ok {
+		return collection, nil
+	}
+
+	if !create {
+		if _, err := c.backend.Describe(ctx, name); err != nil {
+			return rag.Collection{}, err
+		}
+	}
+	vs, embedder, err := store.OpenCollection(ctx, c.backend, name, c.embedCfg, false)
+	if err != nil {
+		return rag.Collection{}, fmt.Errorf("failed to open collection %s: %w", name, err)
+	}
+	collection := rag.Collection{Store: vs, Embedder: embedder}
+	c.open[name] = collection
+	return collection, nil
+}
+
+// cached returns a collection if it is open
+func (c *collectionCache) cached(name string) (rag.Collection, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	collection, ok := c.open[name]
+	return collection, ok
+}
+
+// openAll opens every collection of the backend
+func (c *collectionCache) openAll(ctx context.Context) error {
+	infos, err := c.backend.Collections(ctx)
+	if err != nil {
+		return err
+	}
+	for _, info := range infos {
+		if _, err := c.get(ctx, info.Name, false); err != nil {
+			return err
+		}
+	}
+	return nil
+}
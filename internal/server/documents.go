@@ -0,0 +1,245 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+
+	"document-rag/internal/ingest"
+	"document-rag/internal/models"
+	"document-rag/internal/store"
+)
+
+const (
+	formatDocument = "document"
+	formatBG       = "bg"
+
+	// multipart parts above this size are buffered on disk
+	maxUploadMemory = 8 << 20
+)
+
+// handleUpload stores the uploaded file of the multipart field "file" as a
+// source of the collection and queues its ingest. The form field "format"
+// picks the parser: document (PDF, office and text files, the default) or
+// bg for Bhagavad Gita texts. It answers 202 with the job to poll.
+func (s *Server) handleUpload(w http.ResponseWriter, r *http.Request) {
+	collection, err := s.collection(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	maxMB := s.cfg.Server.MaxUploadMB
+	if maxMB <= 0 {
+		maxMB = defaultMaxUploadMB
+	}
+	r.Body = http.MaxBytesReader(w, r.Body, maxMB<<20)
+	if err := r.ParseMultipartForm(maxUploadMemory); err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			writeError(w, http.StatusRequestEntityTooLarge, fmt.Errorf("upload larger than %d MB", maxMB))
+			return
+		}
+		writeError(w, http.StatusBadRequest, fmt.Errorf("invalid multipart form: %w", err))
+		return
+	}
+	defer r.MultipartForm.RemoveAll()
+
+	format := r.FormValue("format")
+	switch format {
+	case "":
+		format = formatDocument
+	case formatDocument, formatBG:
+	default:
+		writeError(w, http.StatusBadRequest, fmt.Errorf("unsupported format %q, use %s or %s", format, formatDocument, formatBG))
+		return
+	}
+
+	file, header, err := r.FormFile("file")
+	if err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("missing file: %w", err))
+		return
+	}
+	defer file.Close()
+
+	name := filepath.Base(header.Filename)
+	if name == "." || name == ".." || name == string(filepath.Separator) {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("invalid file name %q", header.Filename))
+		return
+	}
+	path, upload, err := s.saveUpload(collection, name, file)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	j, err := s.jobs.add(&job{Kind: jobIngest, Collection: collection, Source: path, Format: format, upload: upload})
+	if err != nil {
+		os.Remove(upload)
+		writeError(w, http.StatusServiceUnavailable, err)
+		return
+	}
+	w.Header().Set("Location", "/v1/jobs/"+j.ID)
+	writeJSON(w, http.StatusAccepted, j)
+}
+
+// saveUpload writes an upload to a file of its own in
+// <upload_dir>/<collection> and returns it with the path that names the
+// source, <upload_dir>/<collection>/<name>. The job moves it there when it
+// runs, so uploads of the same name never overwrite a file queued for ingest.
+func (s *Server) saveUpload(collection, name string, file io.Reader) (path, upload string, err error) {
+	dir := s.cfg.Server.UploadDir
+	if dir == "" {
+		dir = defaultUploadDir
+	}
+	dir = filepath.Join(dir, collection)
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return "", "", fmt.Errorf("failed to create upload directory: %w", err)
+	}
+
+	tmp, err := os.CreateTemp(dir, ".upload-*")
+	if err != nil {
+		return "", "", fmt.Errorf("failed to store upload: %w", err)
+	}
+	if _, err := io.Copy(tmp, file); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return "", "", fmt.Errorf("failed to store upload: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return "", "", fmt.Errorf("failed to store upload: %w", err)
+	}
+	return filepath.Join(dir, name), tmp.Name(), nil
+}
+
+// runJob runs a job against its collection
+func (s *Server) runJob(ctx context.Context, j *job) (*jobResult, error) {
+	if j.upload != "" {
+		defer os.Remove(j.upload)
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	if j.Kind == jobDelete {
+		return s.deleteSource(ctx, j)
+	}
+	return s.ingestUpload(ctx, j)
+}
+
+// ingestUpload moves the upload of a job to its source path and ingests it
+func (s *Server) ingestUpload(ctx context.Context, j *job) (*jobResult, error) {
+	collection, err := s.collections.get(ctx, j.Collection, true)
+	if err != nil {
+		return nil, err
+	}
+	vs := collection.Store
+
+	if err := os.Rename(j.upload, j.Source); err != nil {
+		return nil, fmt.Errorf("failed to store upload: %w", err)
+	}
+	var src ingest.Source
+	if j.Format == formatBG {
+		src, err = ingest.BGSource(s.cfg, j.Source)
+	} else {
+		src, err = ingest.DocumentSource(s.cfg, j.Source)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := ingest.Ingest(ctx, vs, collection.Embedder, src)
+	if err != nil {
+		return nil, err
+	}
+	if err := store.Persist(ctx, s.cfg, vs); err != nil {
+		return nil, fmt.Errorf("failed to export collection: %w", err)
+	}
+	return &jobResult{Skipped: res.Skipped, Embedded: res.Embedded, Unchanged: res.Unchanged, Deleted: res.Deleted}, nil
+}
+
+// deleteSource removes the chunks of the source of a job
+func (s *Server) deleteSource(ctx context.Context, j *job) (*jobResult, error) {
+	collection, err := s.collections.get(ctx, j.Collection, false)
+	if err != nil {
+		return nil, err
+	}
+	vs := collection.Store
+
+	n, err := vs.DeleteSource(ctx, j.Source)
+	if err != nil {
+		return nil, err
+	}
+	if n == 0 {
+		return nil, fmt.Errorf("source %s has no chunks in %s", j.Source, j.Collection)
+	}
+	if err := store.Persist(ctx, s.cfg, vs); err != nil {
+		return nil, fmt.Errorf("failed to export collection: %w", err)
+	}
+	return &jobResult{Deleted: n}, nil
+}
+
+// handleJob answers the state of a job
+func (s *Server) handleJob(w http.ResponseWriter, r *http.Request) {
+	j, ok := s.jobs.get(r.PathValue("id"))
+	if !ok {
+		writeError(w, http.StatusNotFound, errors.New("job not found"))
+		return
+	}
+	writeJSON(w, http.StatusOK, j)
+}
+
+// handleListSources answers the sources of a collection
+func (s *Server) handleListSources(w http.ResponseWriter, r *http.Request) {
+	collection, err := s.collection(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	vs, err := s.openExisting(r.Context(), collection)
+	if err != nil {
+		writeError(w, errorStatus(err), err)
+		return
+	}
+	sources, err := vs.Sources(r.Context())
+	if err != nil {
+		writeError(w, errorStatus(err), err)
+		return
+	}
+	if sources == nil {
+		sources = []models.SourceInfo{}
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{"collection": collection, "sources": sources})
+}
+
+// handleDeleteSource queues the removal of the chunks of the source named
+// by the query parameter "source". It runs on the ingest worker, so it never
+// races an ingest of the same collection, and answers 202 with the job to poll.
+func (s *Server) handleDeleteSource(w http.ResponseWriter, r *http.Request) {
+	collection, err := s.collection(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	source := r.URL.Query().Get("source")
+	if source == "" {
+		writeError(w, http.StatusBadRequest, errors.New("missing source query parameter"))
+		return
+	}
+	if _, err := s.backend.Describe(r.Context(), collection); err != nil {
+		writeError(w, errorStatus(err), err)
+		return
+	}
+
+	j, err := s.jobs.add(&job{Kind: jobDelete, Collection: collection, Source: source})
+	if err != nil {
+		writeError(w, http.StatusServiceUnavailable, err)
+		return
+	}
+	w.Header().Set("Location", "/v1/jobs/"+j.ID)
+	writeJSON(w, http.StatusAccepted, j)
+}
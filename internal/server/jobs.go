@@ -0,0 +1,173 @@
+package server
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	jobQueued  = "queued"
+	jobRunning = "running"
+	jobDone    = "done"
+	jobFailed  = "failed"
+
+	jobIngest = "ingest"
+	jobDelete = "delete"
+
+	// jobs waiting for the worker before new ones are refused
+	maxQueuedJobs = 100
+	// finished jobs kept for polling, the oldest are forgotten first
+	maxFinishedJobs = 1000
+)
+
+var errQueueFull = errors.New("too many jobs queued, retry later")
+
+// job is an ingest of an uploaded file or the delete of a source, run in
+// the background
+type job struct {
+	ID         string     `json:"id"`
+	Kind       string     `json:"kind"`
+	Status     string     `json:"status"`
+	Collection string     `json:"collection"`
+	Source     string     `json:"source"`
+	Format     string     `json:"format,omitempty"`
+	Result     *jobResult `json:"result,omitempty"`
+	Error      string     `json:"error,omitempty"`
+	CreatedAt  time.Time  `json:"created_at"`
+	FinishedAt *time.Time `json:"finished_at,omitempty"`
+
+	// upload is the file saved for an ingest, moved to Source when it runs
+	upload string
+}
+
+// jobResult is what the job changed in the store, see ingest.Result
+type jobResult struct {
+	Skipped   bool `json:"skipped"`
+	Embedded  int  `json:"embedded"`
+	Unchanged int  `json:"unchanged"`
+	Deleted   int  `json:"deleted"`
+}
+
+// jobQueue runs jobs one at a time, so two requests never write to a
+// collection at once. Jobs are kept in memory and lost on restart, finished
+// ones up to maxFinishedJobs.
+type jobQueue struct {
+	mu   sync.Mutex
+	jobs map[string]*job
+	// finished holds the IDs of finished jobs, oldest first
+	finished []string
+	queue    chan *job
+	closed   bool
+	// run is called for every job, with a canceled context once the queue
+	// is closed, so it can drop what the job holds
+	run    func(ctx context.Context, j *job) (*jobResult, error)
+	ctx    context.Context
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+func newJobQueue(run func(ctx context.Context, j *job) (*jobResult, error)) *jobQueue {
+	ctx, cancel := context.WithCancel(context.Background())
+	q := &jobQueue{
+		jobs:   map[string]*job{},
+		queue:  make(chan *job, maxQueuedJobs),
+		run:    run,
+		ctx:    ctx,
+		cancel: cancel,
+		done:   make(chan struct{}),
+	}
+	go q.work()
+	return q
+}
+
+// add queues a job and returns a copy of it
+func (q *jobQueue) add(j *job) (job, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.closed {
+		return job{}, errors.New("server is shutting down")
+	}
+	j.ID = newJobID()
+	j.Status = jobQueued
+	j.CreatedAt = time.Now().UTC()
+	select {
+	case q.queue <- j:
+	default:
+		return job{}, errQueueFull
+	}
+	q.jobs[j.ID] = j
+	return *j, nil
+}
+
+// get returns a copy of a job
+func (q *jobQueue) get(id string) (job, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	j, ok := q.jobs[id]
+	if !ok {
+		return job{}, false
+	}
+	return *j, true
+}
+
+func (q *jobQueue) work() {
+	defer close(q.done)
+	for j := range q.queue {
+		q.setStatus(j, jobRunning, nil, nil)
+		res, err := q.run(q.ctx, j)
+		if err != nil {
+			log.Error().Err(err).Str("job", j.ID).Str("kind", j.Kind).Str("source", j.Source).Msg("Job failed")
+			q.setStatus(j, jobFailed, nil, err)
+			continue
+		}
+		log.Info().Str("job", j.ID).Str("kind", j.Kind).Str("source", j.Source).Msg("Job done")
+		q.setStatus(j, jobDone, res, nil)
+	}
+}
+
+func (q *jobQueue) setStatus(j *job, status string, res *jobResult, err error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	j.Status = status
+	j.Result = res
+	if err != nil {
+		j.Error = err.Error()
+	}
+	if status == jobDone || status == jobFailed {
+		now := time.Now().UTC()
+		j.FinishedAt = &now
+		q.finished = append(q.finished, j.ID)
+		for len(q.finished) > maxFinishedJobs {
+			delete(q.jobs, q.finished[0])
+			q.finished = q.finished[1:]
+		}
+	}
+}
+
+// close cancels the running job, fails the queued ones and waits for the
+// worker to stop
+func (q *jobQueue) close() {
+	q.mu.Lock()
+	if q.closed {
+		q.mu.Unlock()
+		return
+	}
+	q.closed = true
+	close(q.queue)
+	q.mu.Unlock()
+
+	q.cancel()
+	<-q.done
+}
+
+func newJobID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,153 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+// waitStatus polls a job until it has the status
+func waitStatus(t *testing.T, q *jobQueue, id, status string) job {
+	t.Helper()
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(time.Millisecond) {
+		if j, ok := q.get(id); ok && j.Status == status {
+			return j
+		}
+	}
+	j, _ := q.get(id)
+	t.Fatalf("job %s is %s, want %s", id, j.Status, status)
+	return job{}
+}
+
+func TestJobQueueStatus(t *testing.T) {
+	release := make(chan error)
+	q := newJobQueue(func(ctx context.Context, j *job) (*jobResult, error) {
+		if err := <-release; err != nil {
+			return nil, err
+		}
+		return &jobResult{Embedded: 1}, nil
+	})
+	defer q.close()
+
+	first, err := q.add(&job{Kind: jobIngest, Source: "a.txt"})
+	if err != nil {
+		t.Fatalf("add: %v", err)
+	}
+	second, err := q.add(&job{Kind: jobDelete, Source: "b.txt"})
+	if err != nil {
+		t.Fatalf("add: %v", err)
+	}
+	if first.ID == second.ID || first.CreatedAt.IsZero() {
+		t.Fatalf("unexpected jobs %+v %+v", first, second)
+	}
+
+	waitStatus(t, q, first.ID, jobRunning)
+	if j, _ := q.get(second.ID); j.Status != jobQueued {
+		t.Errorf("second job is %s while the first runs, want queued", j.Status)
+	}
+
+	release <- nil
+	j := waitStatus(t, q, first.ID, jobDone)
+	if j.Result == nil || j.Result.Embedded != 1 || j.FinishedAt == nil || j.Error != "" {
+		t.Errorf("unexpected done job %+v", j)
+	}
+
+	waitStatus(t, q, second.ID, jobRunning)
+	release <- errors.New("no chunks")
+	j = waitStatus(t, q, second.ID, jobFailed)
+	if j.Result != nil || j.FinishedAt == nil || j.Error != "no chunks" {
+		t.Errorf("unexpected failed job %+v", j)
+	}
+}
+
+func TestJobQueueClose(t *testing.T) {
+	started := make(chan struct{})
+	var canceled []string
+	q := newJobQueue(func(ctx context.Context, j *job) (*jobResult, error) {
+		if j.Source == "running" {
+			close(started)
+		}
+		<-ctx.Done()
+		canceled = append(canceled, j.Source)
+		return nil, ctx.Err()
+	})
+
+	running, _ := q.add(&job{Source: "running"})
+	queued, _ := q.add(&job{Source: "queued"})
+	<-started
+	q.close()
+
+	for _, id := range []string{running.ID, queued.ID} {
+		if j, _ := q.get(id); j.Status != jobFailed {
+			t.Errorf("job %s is %s after close, want failed", j.Source, j.Status)
+		}
+	}
+	// every job is handed to run, so it can drop what it holds
+	if len(canceled) != 2 {
+		t.Errorf("run saw %v", canceled)
+	}
+	if _, err := q.add(&job{}); err == nil {
+		t.Error("a closed queue accepted a job")
+	}
+}
+
+func TestJobQueueFull(t *testing.T) {
+	release := make(chan struct{})
+	q := newJobQueue(func(ctx context.Context, j *job) (*jobResult, error) {
+		<-release
+		return &jobResult{}, nil
+	})
+	defer q.close()
+	defer close(release)
+
+	// one job runs, maxQueuedJobs wait
+	for i := 0; i <= maxQueuedJobs; i++ {
+		j, err := q.add(&job{})
+		if err != nil {
+			t.Fatalf("add %d: %v", i, err)
+		}
+		if i == 0 {
+			waitStatus(t, q, j.ID, jobRunning)
+		}
+	}
+	if _, err := q.add(&job{}); !errors.Is(err, errQueueFull) {
+		t.Errorf("add to a full queue: %v, want errQueueFull", err)
+	}
+}
+
+func TestSessionLocks(t *testing.T) {
+	l := newSessionLocks()
+	unlock, err := l.lock(context.Background(), "s1")
+	if err != nil {
+		t.Fatalf("lock: %v", err)
+	}
+
+	// another session is not blocked
+	other, err := l.lock(context.Background(), "s2")
+	if err != nil {
+		t.Fatalf("lock of another session: %v", err)
+	}
+	other()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if _, err := l.lock(ctx, "s1"); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("lock of a held session: %v, want the context error", err)
+	}
+
+	acquired := make(chan func())
+	go func() {
+		next, err := l.lock(context.Background(), "s1")
+		if err != nil {
+			t.Errorf("lock: %v", err)
+		}
+		acquired <- next
+	}()
+	unlock()
+	(<-acquired)()
+
+	if len(l.locks) != 0 {
+		t.Errorf("%d unused locks kept", len(l.locks))
+	}
+}
@@ -0,0 +1,221 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"document-rag/internal/chat"
+	"document-rag/internal/filter"
+	"document-rag/internal/models"
+	"document-rag/internal/rag"
+	"document-rag/internal/store"
+
+	"github.com/rs/zerolog/log"
+)
+
+const maxQueryBody = 1 << 20
+
+// queryRequest is the body of POST /v1/query
+type queryRequest struct {
+	Query       string   `json:"query"`
+	Collections []string `json:"collections"` // the default collection when empty
+	Filter      string   `json:"filter"`      // see filter.Parse
+	Mode        string   `json:"mode"`        // vector, text or hybrid
+	Prompt      string   `json:"prompt"`      // prompt template name
+	// SessionID continues a chat session, "new" starts one. The session ID
+	// is answered in the X-Session-ID header.
+	SessionID string `json:"session_id"`
+	// Stream answers with server-sent events, as does an Accept header of
+	// text/event-stream
+	Stream bool `json:"stream"`
+}
+
+// handleQuery answers a query with a models.PromptResponse. Streamed, the
+// answer comes as "token" events with {"token": ...}, then a "done" event
+// with the response, or an "error" event with {"error": ...}. A client that
+// disconnects stops the generation.
+func (s *Server) handleQuery(w http.ResponseWriter, r *http.Request) {
+	var req queryRequest
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxQueryBody)).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("invalid request body: %w", err))
+		return
+	}
+	if strings.TrimSpace(req.Query) == "" {
+		writeError(w, http.StatusBadRequest, errors.New("query is required"))
+		return
+	}
+
+	opts, err := queryOptions(req)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	names := req.Collections
+	if len(names) == 0 {
+		names = []string{store.DefaultCollection(s.cfg)}
+	}
+	for _, name := range names {
+		if err := store.ValidateCollectionName(name); err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+	}
+
+	ctx := r.Context()
+	targets := make([]rag.Collection, 0, len(names))
+	for _, name := range names {
+		collection, err := s.collections.get(ctx, name, false)
+		if err != nil {
+			writeError(w, errorStatus(err), err)
+			return
+		}
+		targets = append(targets, collection)
+	}
+	ragInstance := s.rag.WithCollections(targets)
+
+	// a turn holds its session from loading to saving, so concurrent turns
+	// do not overwrite each other
+	var session *models.ChatSession
+	if req.SessionID != "" {
+		id := req.SessionID
+		if id == "new" {
+			id = chat.NewID()
+		}
+		if err := chat.ValidateID(id); err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+		unlock, err := s.sessionLocks.lock(ctx, id)
+		if err != nil {
+			log.Info().Msg("Query cancelled by the client")
+			return
+		}
+		defer unlock()
+		if session, err = chat.LoadOrCreate(ctx, s.sessions, id); err != nil {
+			writeError(w, errorStatus(err), err)
+			return
+		}
+		w.Header().Set("X-Session-ID", id)
+	}
+
+	// the done event is held back until the turn is saved, so a client never
+	// gets an error after the final response
+	var events *eventStream
+	if req.Stream || strings.Contains(r.Header.Get("Accept"), "text/event-stream") {
+		if events, err = newEventStream(w); err != nil {
+			writeError(w, http.StatusInternalServerError, err)
+			return
+		}
+		opts.Stream = func(ctx context.Context, event models.StreamEvent) error {
+			if event.Done {
+				return nil
+			}
+			return events.send(ctx, event)
+		}
+	}
+
+	var rsp models.PromptResponse
+	if session != nil {
+		rsp, err = ragInstance.Chat(ctx, session, req.Query, opts)
+		if err == nil {
+			err = s.sessions.Save(ctx, session)
+		}
+	} else {
+		rsp, err = ragInstance.QueryWithOptions(ctx, req.Query, opts)
+	}
+
+	if ctx.Err() != nil {
+		log.Info().Msg("Query cancelled by the client")
+		return
+	}
+	if events != nil {
+		if err != nil {
+			events.fail(err)
+			return
+		}
+		if err := events.send(ctx, models.StreamEvent{Done: true, Response: &rsp}); err != nil {
+			log.Warn().Err(err).Msg("Failed to write done event")
+		}
+		return
+	}
+	if err != nil {
+		writeError(w, errorStatus(err), err)
+		return
+	}
+	writeJSON(w, http.StatusOK, rsp)
+}
+
+func queryOptions(req queryRequest) (rag.QueryOptions, error) {
+	where, err := filter.Parse(req.Filter)
+	if err != nil {
+		return rag.QueryOptions{}, fmt.Errorf("%w: %w", models.ErrInvalidFilter, err)
+	}
+	opts := rag.QueryOptions{Filter: where, Prompt: req.Prompt}
+	if req.Mode != "" {
+		if opts.Mode, err = models.ParseSearchMode(req.Mode); err != nil {
+			return rag.QueryOptions{}, err
+		}
+	}
+	return opts, nil
+}
+
+// eventStream writes server-sent events. The response status and headers
+// are only written with the first event, so an error before it still gets
+// its status code.
+type eventStream struct {
+	w       http.ResponseWriter
+	flusher http.Flusher
+	started bool
+}
+
+func newEventStream(w http.ResponseWriter) (*eventStream, error) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		return nil, errors.New("streaming is not supported by the connection")
+	}
+	return &eventStream{w: w, flusher: flusher}, nil
+}
+
+// send writes a token event, or the done event with the response
+func (e *eventStream) send(ctx context.Context, event models.StreamEvent) error {
+	if event.Done {
+		return e.write("done", event.Response)
+	}
+	return e.write("token", map[string]string{"token": event.Token})
+}
+
+// fail answers an error, as an event once the stream started
+func (e *eventStream) fail(err error) {
+	if !e.started {
+		writeError(e.w, errorStatus(err), err)
+		return
+	}
+	log.Error().Err(err).Msg("Streamed query failed")
+	if err := e.write("error", map[string]string{"error": err.Error()}); err != nil {
+		log.Warn().Err(err).Msg("Failed to write error event")
+	}
+}
+
+func (e *eventStream) write(name string, v interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	if !e.started {
+		h := e.w.Header()
+		h.Set("Content-Type", "text/event-stream")
+		h.Set("Cache-Control", "no-cache")
+		h.Set("X-Accel-Buffering", "no")
+		e.w.WriteHeader(http.StatusOK)
+		e.started = true
+	}
+	if _, err := fmt.Fprintf(e.w, "event: %s\ndata: %s\n\n", name, data); err != nil {
+		return err
+	}
+	e.flusher.Flush()
+	return nil
+}
@@ -0,0 +1,222 @@
+// Package server exposes ingest, query and document management as a JSON
+// HTTP API. Handlers call the same packages as the CLI and answer errors with
+// a status code and a JSON body instead of exiting.
+package server
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"document-rag/internal/chat"
+	"document-rag/internal/config"
+	"document-rag/internal/models"
+	"document-rag/internal/prompt"
+	"document-rag/internal/rag"
+	"document-rag/internal/store"
+
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	defaultAddr        = ":8080"
+	defaultUploadDir   = "./uploads"
+	defaultMaxUploadMB = 32
+	readyTimeout       = 2 * time.Second
+)
+
+// Server handles the API requests
+type Server struct {
+	cfg          *config.Config
+	backend      store.Backend
+	collections  *collectionCache
+	rag          *rag.RAG // the reranker and prompt templates of all queries
+	sessions     chat.SessionStore
+	sessionLocks *sessionLocks
+	jobs         *jobQueue
+}
+
+// New opens the vector store with its collections and the session store of
+// the config and starts the ingest worker. Queries use the prompt templates
+// loaded with prompt.Load.
+func New(ctx context.Context, cfg *config.Config, prompts *prompt.Set) (*Server, error) {
+	ragInstance, err := rag.NewRAGWithPrompts(nil, cfg, prompts)
+	if err != nil {
+		return nil, err
+	}
+	backend, err := store.OpenBackend(ctx, cfg)
+	if err != nil {
+		return nil, err
+	}
+	collections := newCollectionCache(backend, cfg.EmbedLLM)
+	if err := collections.openAll(ctx); err != nil {
+		backend.Close()
+		return nil, fmt.Errorf("failed to open collections: %w", err)
+	}
+	sessions, err := chat.OpenStore(ctx, cfg)
+	if err != nil {
+		backend.Close()
+		return nil, err
+	}
+	s := &Server{
+		cfg:          cfg,
+		backend:      backend,
+		collections:  collections,
+		rag:          ragInstance,
+		sessions:     sessions,
+		sessionLocks: newSessionLocks(),
+	}
+	s.jobs = newJobQueue(s.runJob)
+	return s, nil
+}
+
+// Addr returns the configured listen address
+func (s *Server) Addr() string {
+	if s.cfg.Server.Addr != "" {
+		return s.cfg.Server.Addr
+	}
+	return defaultAddr
+}
+
+// Handler returns the routes of the API
+func (s *Server) Handler() http.Handler {
+	api := http.NewServeMux()
+	api.HandleFunc("POST /v1/query", s.handleQuery)
+	api.HandleFunc("POST /v1/collections/{collection}/documents", s.handleUpload)
+	api.HandleFunc("GET /v1/collections/{collection}/sources", s.handleListSources)
+	api.HandleFunc("DELETE /v1/collections/{collection}/sources", s.handleDeleteSource)
+	api.HandleFunc("GET /v1/jobs/{id}", s.handleJob)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /healthz", s.handleHealth)
+	mux.HandleFunc("GET /readyz", s.handleReady)
+	mux.Handle("/v1/", s.authorize(api))
+	return logRequests(mux)
+}
+
+// Close cancels the running job, fails the queued ones and closes
+// the stores
+func (s *Server) Close() error {
+	s.jobs.close()
+	s.sessions.Close()
+	return s.backend.Close()
+}
+
+// handleHealth reports the process is up
+func (s *Server) handleHealth(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]string{"status": "ok"})
+}
+
+// handleReady reports whether the vector store answers
+func (s *Server) handleReady(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), readyTimeout)
+	defer cancel()
+	if _, err := s.backend.Collections(ctx); err != nil {
+		log.Warn().Err(err).Msg("Vector store not ready")
+		writeJSON(w, http.StatusServiceUnavailable, map[string]string{"status": "unavailable", "error": err.Error()})
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]string{"status": "ready"})
+}
+
+// authorize requires the configured API key as a bearer token
+func (s *Server) authorize(next http.Handler) http.Handler {
+	key := s.cfg.Server.APIKey
+	if key == "" {
+		return next
+	}
+	want := []byte("Bearer " + key)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if subtle.ConstantTimeCompare([]byte(r.Header.Get("Authorization")), want) != 1 {
+			writeError(w, http.StatusUnauthorized, errors.New("missing or invalid API key"))
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// collection returns the collection of the path, the default for "default"
+func (s *Server) collection(r *http.Request) (string, error) {
+	name := r.PathValue("collection")
+	if name == "default" {
+		name = store.DefaultCollection(s.cfg)
+	}
+	return name, store.ValidateCollectionName(name)
+}
+
+// openExisting opens a collection for reading and deleting, with the model
+// and dimension it was created with
+func (s *Server) openExisting(ctx context.Context, name string) (store.VectorStore, error) {
+	if collection, ok := s.collections.cached(name); ok {
+		return collection.Store, nil
+	}
+	info, err := s.backend.Describe(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	return s.backend.Collection(ctx, name, info.EmbeddingModel, info.Dimension)
+}
+
+// errorStatus maps the errors of the internal packages to status codes
+func errorStatus(err error) int {
+	switch {
+	case errors.Is(err, models.ErrCollectionNotFound), errors.Is(err, models.ErrSessionNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, prompt.ErrUnknownTemplate), errors.Is(err, models.ErrInvalidFilter):
+		return http.StatusBadRequest
+	case errors.Is(err, models.ErrDimensionMismatch):
+		return http.StatusConflict
+	case errors.Is(err, context.DeadlineExceeded):
+		return http.StatusGatewayTimeout
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Warn().Err(err).Msg("Failed to write response")
+	}
+}
+
+// writeError answers {"error": "..."}, server errors are logged
+func writeError(w http.ResponseWriter, status int, err error) {
+	if status >= http.StatusInternalServerError {
+		log.Error().Err(err).Msg("Request failed")
+	}
+	writeJSON(w, status, map[string]string{"error": err.Error()})
+}
+
+// statusRecorder keeps the status code of a response for the request log
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	r.status = status
+	r.ResponseWriter.WriteHeader(status)
+}
+
+// Flush lets streamed responses through the recorder
+func (r *statusRecorder) Flush() {
+	if f, ok := r.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func logRequests(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(rec, r)
+		log.Info().Str("method", r.Method).Str("path", r.URL.Path).Int("status", rec.status).
+			Dur("duration", time.Since(start)).Msg("Request")
+	})
+}
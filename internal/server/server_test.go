@@ -0,0 +1,555 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"document-rag/internal/config"
+	"document-rag/internal/models"
+	"document-rag/internal/prompt"
+)
+
+const testAnswer = "The soul is eternal [1]."
+
+// embed hashes the words of a text into a vector, so texts sharing words are
+// similar
+func embed(text string) []float32 {
+	v := make([]float32, 32)
+	for _, w := range strings.Fields(strings.ToLower(text)) {
+		h := fnv.New32a()
+		h.Write([]byte(w))
+		v[h.Sum32()%32]++
+	}
+	v[0] += 0.01
+	return v
+}
+
+// newOllamaServer stands in for the Ollama embedding API
+func newOllamaServer(t *testing.T) *httptest.Server {
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			Input  interface{} `json:"input"`
+			Prompt string      `json:"prompt"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			t.Errorf("invalid embed request: %v", err)
+		}
+		switch r.URL.Path {
+		case "/api/embed":
+			var embeddings [][]float32
+			switch in := req.Input.(type) {
+			case string:
+				embeddings = append(embeddings, embed(in))
+			case []interface{}:
+				for _, text := range in {
+					embeddings = append(embeddings, embed(fmt.Sprint(text)))
+				}
+			}
+			json.NewEncoder(w).Encode(map[string]interface{}{"embeddings": embeddings})
+		case "/api/embeddings":
+			json.NewEncoder(w).Encode(map[string]interface{}{"embedding": embed(req.Prompt)})
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	t.Cleanup(s.Close)
+	return s
+}
+
+// newChatServer stands in for an OpenAI compatible chat API answering
+// testAnswer, streamed word by word when asked to
+func newChatServer(t *testing.T) *httptest.Server {
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			Stream bool `json:"stream"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			t.Errorf("invalid chat request: %v", err)
+		}
+		if !req.Stream {
+			json.NewEncoder(w).Encode(map[string]interface{}{"choices": []interface{}{map[string]interface{}{
+				"index":         0,
+				"message":       map[string]string{"role": "assistant", "content": testAnswer},
+				"finish_reason": "stop",
+			}}})
+			return
+		}
+		w.Header().Set("Content-Type", "text/event-stream")
+		for _, token := range strings.SplitAfter(testAnswer, " ") {
+			data, _ := json.Marshal(map[string]interface{}{"choices": []interface{}{map[string]interface{}{
+				"index": 0,
+				"delta": map[string]string{"content": token},
+			}}})
+			fmt.Fprintf(w, "data: %s\n\n", data)
+		}
+		fmt.Fprint(w, "data: [DONE]\n\n")
+	}))
+	t.Cleanup(s.Close)
+	return s
+}
+
+// testServer is the API over a chromem database in a temporary folder
+type testServer struct {
+	*httptest.Server
+	s   *Server
+	cfg *config.Config
+}
+
+func newTestServer(t *testing.T, apiKey string) *testServer {
+	t.Helper()
+	dir := t.TempDir()
+	cfg := &config.Config{}
+	cfg.EmbedLLM = config.LLMConfig{BaseURL: newOllamaServer(t).URL, Model: "embed-model"}
+	cfg.QueryLLM = config.LLMConfig{BaseURL: newChatServer(t).URL + "/v1", Key: "key", Model: "chat-model"}
+	cfg.VectorStore.Path = filepath.Join(dir, "db")
+	cfg.Server.UploadDir = filepath.Join(dir, "uploads")
+	cfg.Server.APIKey = apiKey
+	cfg.Chat.Dir = filepath.Join(dir, "sessions")
+	cfg.Prompts.Dir = dir
+
+	prompts, err := prompt.Load(&cfg.Prompts)
+	if err != nil {
+		t.Fatalf("prompt.Load: %v", err)
+	}
+	s, err := New(context.Background(), cfg, prompts)
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	ts := &testServer{Server: httptest.NewServer(s.Handler()), s: s, cfg: cfg}
+	t.Cleanup(func() {
+		ts.Close()
+		s.Close()
+	})
+	return ts
+}
+
+type response struct {
+	status int
+	header http.Header
+	body   string
+}
+
+func (ts *testServer) do(t *testing.T, method, path, contentType string, body io.Reader, header ...string) response {
+	t.Helper()
+	req, err := http.NewRequest(method, ts.URL+path, body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	for i := 0; i+1 < len(header); i += 2 {
+		req.Header.Set(header[i], header[i+1])
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("%s %s: %v", method, path, err)
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return response{status: resp.StatusCode, header: resp.Header, body: string(data)}
+}
+
+func (ts *testServer) query(t *testing.T, body string, header ...string) response {
+	t.Helper()
+	return ts.do(t, http.MethodPost, "/v1/query", "application/json", strings.NewReader(body), header...)
+}
+
+func (ts *testServer) upload(t *testing.T, collection, name, format, content string) response {
+	t.Helper()
+	var buf bytes.Buffer
+	mw := multipart.NewWriter(&buf)
+	if format != "" {
+		mw.WriteField("format", format)
+	}
+	if name != "" {
+		fw, err := mw.CreateFormFile("file", name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		fw.Write([]byte(content))
+	}
+	mw.Close()
+	return ts.do(t, http.MethodPost, "/v1/collections/"+collection+"/documents", mw.FormDataContentType(), &buf)
+}
+
+// wait polls a job until it finished
+func (ts *testServer) wait(t *testing.T, rsp response) job {
+	t.Helper()
+	if rsp.status != http.StatusAccepted {
+		t.Fatalf("status %d %s, want 202", rsp.status, rsp.body)
+	}
+	location := rsp.header.Get("Location")
+	for deadline := time.Now().Add(10 * time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		var j job
+		decode(t, ts.do(t, http.MethodGet, location, "", nil), &j)
+		if j.Status == jobDone || j.Status == jobFailed {
+			return j
+		}
+	}
+	t.Fatalf("job %s did not finish", location)
+	return job{}
+}
+
+func decode(t *testing.T, rsp response, v interface{}) {
+	t.Helper()
+	if err := json.Unmarshal([]byte(rsp.body), v); err != nil {
+		t.Fatalf("invalid response %q: %v", rsp.body, err)
+	}
+}
+
+// errorBody returns the message of an error response
+func errorBody(t *testing.T, rsp response) string {
+	t.Helper()
+	var body struct {
+		Error string `json:"error"`
+	}
+	decode(t, rsp, &body)
+	return body.Error
+}
+
+// ingest uploads a text file to the default collection and waits for it
+func (ts *testServer) ingest(t *testing.T, name, content string) job {
+	t.Helper()
+	j := ts.wait(t, ts.upload(t, "default", name, "", content))
+	if j.Status != jobDone {
+		t.Fatalf("ingest of %s failed: %s", name, j.Error)
+	}
+	return j
+}
+
+func TestRoutes(t *testing.T) {
+	ts := newTestServer(t, "")
+	ts.ingest(t, "soul.txt", "The soul is eternal and cannot be slain by weapons.")
+
+	tests := []struct {
+		name   string
+		method string
+		path   string
+		body   string
+		status int
+		err    string
+	}{
+		{"health", http.MethodGet, "/healthz", "", http.StatusOK, ""},
+		{"ready", http.MethodGet, "/readyz", "", http.StatusOK, ""},
+		{"unknown job", http.MethodGet, "/v1/jobs/nope", "", http.StatusNotFound, "job not found"},
+		{"query method", http.MethodGet, "/v1/query", "", http.StatusMethodNotAllowed, ""},
+		{"invalid body", http.MethodPost, "/v1/query", "{", http.StatusBadRequest, "invalid request body"},
+		{"empty query", http.MethodPost, "/v1/query", `{"query": " "}`, http.StatusBadRequest, "query is required"},
+		{"invalid filter", http.MethodPost, "/v1/query", `{"query": "soul", "filter": "page >"}`, http.StatusBadRequest, "invalid filter"},
+		{"invalid mode", http.MethodPost, "/v1/query", `{"query": "soul", "mode": "fuzzy"}`, http.StatusBadRequest, "fuzzy"},
+		{"invalid collection", http.MethodPost, "/v1/query", `{"query": "soul", "collections": ["a/b"]}`, http.StatusBadRequest, "a/b"},
+		{"missing collection", http.MethodPost, "/v1/query", `{"query": "soul", "collections": ["missing"]}`, http.StatusNotFound, "missing"},
+		{"unknown prompt", http.MethodPost, "/v1/query", `{"query": "soul", "prompt": "missing"}`, http.StatusBadRequest, "missing"},
+		{"invalid session", http.MethodPost, "/v1/query", `{"query": "soul", "session_id": "../x"}`, http.StatusBadRequest, ""},
+		{"sources", http.MethodGet, "/v1/collections/default/sources", "", http.StatusOK, ""},
+		{"sources of missing collection", http.MethodGet, "/v1/collections/missing/sources", "", http.StatusNotFound, "missing"},
+		{"delete without source", http.MethodDelete, "/v1/collections/default/sources", "", http.StatusBadRequest, "missing source"},
+		{"delete in missing collection", http.MethodDelete, "/v1/collections/missing/sources?source=a.txt", "", http.StatusNotFound, "missing"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rsp := ts.do(t, tt.method, tt.path, "application/json", strings.NewReader(tt.body))
+			if rsp.status != tt.status {
+				t.Fatalf("status %d %s, want %d", rsp.status, rsp.body, tt.status)
+			}
+			if rsp.status >= http.StatusBadRequest && rsp.status != http.StatusMethodNotAllowed {
+				if got := errorBody(t, rsp); !strings.Contains(got, tt.err) {
+					t.Errorf("error %q, want it to contain %q", got, tt.err)
+				}
+			}
+		})
+	}
+}
+
+func TestUploadRejects(t *testing.T) {
+	ts := newTestServer(t, "")
+	tests := []struct {
+		name       string
+		collection string
+		file       string
+		format     string
+		err        string
+	}{
+		{"missing file", "default", "", "", "missing file"},
+		{"unknown format", "default", "a.txt", "csv", "unsupported format"},
+		{"invalid file name", "default", "..", "", "invalid file name"},
+		{"invalid collection", "a.b", "a.txt", "", "a.b"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rsp := ts.upload(t, tt.collection, tt.file, tt.format, "text")
+			if rsp.status != http.StatusBadRequest {
+				t.Fatalf("status %d %s, want 400", rsp.status, rsp.body)
+			}
+			if got := errorBody(t, rsp); !strings.Contains(got, tt.err) {
+				t.Errorf("error %q, want it to contain %q", got, tt.err)
+			}
+		})
+	}
+}
+
+func TestAPIKey(t *testing.T) {
+	ts := newTestServer(t, "secret")
+	tests := []struct {
+		name   string
+		path   string
+		header []string
+		status int
+	}{
+		{"missing key", "/v1/jobs/nope", nil, http.StatusUnauthorized},
+		{"wrong key", "/v1/jobs/nope", []string{"Authorization", "Bearer other"}, http.StatusUnauthorized},
+		{"key", "/v1/jobs/nope", []string{"Authorization", "Bearer secret"}, http.StatusNotFound},
+		{"health needs no key", "/healthz", nil, http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rsp := ts.do(t, http.MethodGet, tt.path, "", nil, tt.header...)
+			if rsp.status != tt.status {
+				t.Errorf("status %d %s, want %d", rsp.status, rsp.body, tt.status)
+			}
+		})
+	}
+}
+
+func TestUploadAndDeleteJobs(t *testing.T) {
+	ts := newTestServer(t, "")
+
+	j := ts.ingest(t, "soul.txt", "The soul is eternal and cannot be slain by weapons.")
+	if j.Kind != jobIngest || j.Result == nil || j.Result.Embedded == 0 || j.FinishedAt == nil {
+		t.Fatalf("unexpected ingest job %+v", j)
+	}
+	source := filepath.Join(ts.cfg.Server.UploadDir, "bg_collection", "soul.txt")
+	if j.Source != source {
+		t.Errorf("source %s, want %s", j.Source, source)
+	}
+
+	// the same file again is unchanged
+	j = ts.ingest(t, "soul.txt", "The soul is eternal and cannot be slain by weapons.")
+	if !j.Result.Skipped {
+		t.Errorf("re-upload of the same file was not skipped: %+v", j.Result)
+	}
+
+	var sources struct {
+		Sources []models.SourceInfo `json:"sources"`
+	}
+	decode(t, ts.do(t, http.MethodGet, "/v1/collections/default/sources", "", nil), &sources)
+	if len(sources.Sources) != 1 || sources.Sources[0].Name != source {
+		t.Fatalf("sources %+v, want %s", sources, source)
+	}
+
+	del := func() job {
+		return ts.wait(t, ts.do(t, http.MethodDelete, "/v1/collections/default/sources?source="+source, "", nil))
+	}
+	if j := del(); j.Kind != jobDelete || j.Status != jobDone || j.Result.Deleted == 0 {
+		t.Errorf("unexpected delete job %+v", j)
+	}
+	if j := del(); j.Status != jobFailed || !strings.Contains(j.Error, "has no chunks") {
+		t.Errorf("delete of a removed source: %+v", j)
+	}
+}
+
+func TestUploadsOfTheSameName(t *testing.T) {
+	ts := newTestServer(t, "")
+	texts := []string{"first version of the text", "second version of the text", "third version of the text"}
+
+	var queued []response
+	for _, text := range texts {
+		queued = append(queued, ts.upload(t, "default", "a.txt", "", text))
+	}
+	for i, rsp := range queued {
+		if j := ts.wait(t, rsp); j.Status != jobDone || j.Result.Skipped {
+			t.Errorf("upload %d: %+v", i, j)
+		}
+	}
+
+	dir := filepath.Join(ts.cfg.Server.UploadDir, "bg_collection")
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 || entries[0].Name() != "a.txt" {
+		t.Errorf("upload folder holds %v, want a.txt only", entries)
+	}
+	data, err := os.ReadFile(filepath.Join(dir, "a.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != texts[len(texts)-1] {
+		t.Errorf("source holds %q, want the last upload", data)
+	}
+}
+
+func TestQuery(t *testing.T) {
+	ts := newTestServer(t, "")
+	ts.ingest(t, "soul.txt", "The soul is eternal and cannot be slain by weapons.")
+
+	rsp := ts.query(t, `{"query": "Is the soul eternal?"}`)
+	if rsp.status != http.StatusOK {
+		t.Fatalf("status %d %s", rsp.status, rsp.body)
+	}
+	var answer struct {
+		Content   string `json:"content"`
+		Citations []struct {
+			Source string `json:"source"`
+		} `json:"citations"`
+	}
+	decode(t, rsp, &answer)
+	if answer.Content != testAnswer || len(answer.Citations) != 1 {
+		t.Errorf("unexpected answer %+v", answer)
+	}
+	if rsp.header.Get("X-Session-ID") != "" {
+		t.Errorf("a query without session answered session %s", rsp.header.Get("X-Session-ID"))
+	}
+}
+
+// event is one server-sent event
+type event struct {
+	name string
+	data string
+}
+
+func readEvents(t *testing.T, body string) []event {
+	t.Helper()
+	var events []event
+	var current event
+	scanner := bufio.NewScanner(strings.NewReader(body))
+	for scanner.Scan() {
+		line := scanner.Text()
+		switch {
+		case line == "":
+			if current.name == "" || current.data == "" {
+				t.Fatalf("incomplete event %+v in %q", current, body)
+			}
+			events = append(events, current)
+			current = event{}
+		case strings.HasPrefix(line, "event: "):
+			current.name = strings.TrimPrefix(line, "event: ")
+		case strings.HasPrefix(line, "data: "):
+			current.data = strings.TrimPrefix(line, "data: ")
+		default:
+			t.Fatalf("unexpected line %q", line)
+		}
+	}
+	if current != (event{}) {
+		t.Fatalf("unterminated event %+v", current)
+	}
+	return events
+}
+
+func TestQueryStream(t *testing.T) {
+	ts := newTestServer(t, "")
+	ts.ingest(t, "soul.txt", "The soul is eternal and cannot be slain by weapons.")
+
+	tests := []struct {
+		name   string
+		body   string
+		header []string
+	}{
+		{"stream field", `{"query": "Is the soul eternal?", "stream": true}`, nil},
+		{"accept header", `{"query": "Is the soul eternal?"}`, []string{"Accept", "text/event-stream"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rsp := ts.query(t, tt.body, tt.header...)
+			if rsp.status != http.StatusOK {
+				t.Fatalf("status %d %s", rsp.status, rsp.body)
+			}
+			if ct := rsp.header.Get("Content-Type"); ct != "text/event-stream" {
+				t.Errorf("content type %q", ct)
+			}
+
+			events := readEvents(t, rsp.body)
+			var tokens strings.Builder
+			for _, e := range events[:len(events)-1] {
+				if e.name != "token" {
+					t.Fatalf("event %q before the done event", e.name)
+				}
+				var token struct {
+					Token string `json:"token"`
+				}
+				if err := json.Unmarshal([]byte(e.data), &token); err != nil {
+					t.Fatal(err)
+				}
+				tokens.WriteString(token.Token)
+			}
+			if tokens.String() != testAnswer {
+				t.Errorf("tokens %q, want %q", tokens.String(), testAnswer)
+			}
+
+			last := events[len(events)-1]
+			var done struct {
+				Content string `json:"content"`
+			}
+			if err := json.Unmarshal([]byte(last.data), &done); err != nil {
+				t.Fatal(err)
+			}
+			if last.name != "done" || done.Content != testAnswer {
+				t.Errorf("last event %+v", last)
+			}
+		})
+	}
+}
+
+func TestQueryStreamErrorBeforeFirstEvent(t *testing.T) {
+	ts := newTestServer(t, "")
+	// the default collection does not exist yet
+	rsp := ts.query(t, `{"query": "soul", "stream": true}`)
+	if rsp.status != http.StatusNotFound || rsp.header.Get("Content-Type") != "application/json" {
+		t.Errorf("status %d %s %s, want a 404 JSON error", rsp.status, rsp.header.Get("Content-Type"), rsp.body)
+	}
+}
+
+func TestSessions(t *testing.T) {
+	ts := newTestServer(t, "")
+	ts.ingest(t, "soul.txt", "The soul is eternal and cannot be slain by weapons.")
+
+	rsp := ts.query(t, `{"query": "Is the soul eternal?", "session_id": "new"}`)
+	if rsp.status != http.StatusOK {
+		t.Fatalf("status %d %s", rsp.status, rsp.body)
+	}
+	id := rsp.header.Get("X-Session-ID")
+	if id == "" || id == "new" {
+		t.Fatalf("session ID %q", id)
+	}
+
+	for _, body := range []string{
+		fmt.Sprintf(`{"query": "Can weapons slay it?", "session_id": %q}`, id),
+		fmt.Sprintf(`{"query": "Why?", "session_id": %q, "stream": true}`, id),
+	} {
+		rsp := ts.query(t, body)
+		if rsp.status != http.StatusOK || rsp.header.Get("X-Session-ID") != id {
+			t.Fatalf("status %d session %q %s", rsp.status, rsp.header.Get("X-Session-ID"), rsp.body)
+		}
+	}
+
+	session, err := ts.s.sessions.Load(context.Background(), id)
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	if len(session.Turns) != 3 {
+		t.Errorf("session holds %d turns, want 3", len(session.Turns))
+	}
+
+	// a given ID that does not exist yet starts a session
+	rsp = ts.query(t, `{"query": "Is the soul eternal?", "session_id": "mine"}`)
+	if rsp.status != http.StatusOK || rsp.header.Get("X-Session-ID") != "mine" {
+		t.Errorf("status %d session %q", rsp.status, rsp.header.Get("X-Session-ID"))
+	}
+}
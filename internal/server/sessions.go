@@ -0,0 +1,56 @@
+package server
+
+import (
+	"context"
+	"sync"
+)
+
+// sessionLocks serializes the turns of each chat session
+type sessionLocks struct {
+	mu    sync.Mutex
+	locks map[string]*sessionLock
+}
+
+// sessionLock is held by one turn at a time, users counts the turns holding
+// or waiting for it so it is dropped once unused
+type sessionLock struct {
+	held  chan struct{}
+	users int
+}
+
+func newSessionLocks() *sessionLocks {
+	return &sessionLocks{locks: map[string]*sessionLock{}}
+}
+
+// lock waits for the session until ctx is done and returns the function
+// releasing it
+func (l *sessionLocks) lock(ctx context.Context, id string) (func(), error) {
+	l.mu.Lock()
+	sl, ok := l.locks[id]
+	if !ok {
+		sl = &sessionLock{held: make(chan struct{}, 1)}
+		l.locks[id] = sl
+	}
+	sl.users++
+	l.mu.Unlock()
+
+	select {
+	case sl.held <- struct{}{}:
+		return func() {
+			<-sl.held
+			l.release(id, sl)
+		}, nil
+	case <-ctx.Done():
+		l.release(id, sl)
+		return nil, ctx.Err()
+	}
+}
+
+func (l *sessionLocks) release(id string, sl *sessionLock) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	sl.users--
+	if sl.users == 0 {
+		delete(l.locks, id)
+	}
+}
@@ -0,0 +1,249 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+
+	"document-rag/internal/chromemdb"
+	"document-rag/internal/config"
+	"document-rag/internal/db"
+	"document-rag/internal/embedding"
+	"document-rag/internal/helper"
+	"document-rag/internal/models"
+
+	"github.com/rs/zerolog/log"
+	"github.com/tmc/langchaingo/embeddings"
+	"github.com/uptrace/bun"
+)
+
+// VectorStore is the storage and retrieval interface of one collection,
+// shared by every backend
+type VectorStore interface {
+	// Upsert stores chunks, replacing chunks that already exist with the same ID
+	Upsert(ctx context.Context, chunks []models.ChunkEmbedding) error
+	// Search returns up to req.K chunks ordered by descending score
+	Search(ctx context.Context, req models.SearchRequest) ([]models.SearchResult, error)
+	// SourceState returns the source hash and chunk hashes recorded for a source
+	SourceState(ctx context.Context, source string) (models.SourceState, error)
+	// RecordSource records a completed ingest of a source: its hash, and the
+	// file metadata where the backend keeps it
+	RecordSource(ctx context.Context, src models.SourceInfo) error
+	// Delete removes the chunks with the given IDs
+	Delete(ctx context.Context, ids ...string) error
+	// Sources returns every source with chunks in the collection ordered by name
+	Sources(ctx context.Context) ([]models.SourceInfo, error)
+	// Chunks returns the stored chunks of a source in page and chunk order,
+	// without embeddings
+	Chunks(ctx context.Context, source string) ([]models.ChunkEmbedding, error)
+	// DeleteSource removes every chunk of a source and returns how many were removed
+	DeleteSource(ctx context.Context, source string) (int, error)
+	// Reset removes every chunk from the collection
+	Reset(ctx context.Context) error
+	// Dimension returns the embedding vector size the collection holds
+	Dimension() int
+	// Info returns the collection the store is scoped to
+	Info() models.CollectionInfo
+}
+
+// Backend manages the named collections of one database
+type Backend interface {
+	// Collection opens a collection, creating it for the given embedding
+	// model and dimension if it does not exist yet
+	Collection(ctx context.Context, name, embeddingModel string, dimension int) (VectorStore, error)
+	// Describe returns a collection, models.ErrCollectionNotFound if it does not exist
+	Describe(ctx context.Context, name string) (*models.CollectionInfo, error)
+	// Collections returns every collection ordered by name
+	Collections(ctx context.Context) ([]models.CollectionInfo, error)
+	// DropCollection deletes a collection and all of its chunks
+	DropCollection(ctx context.Context, name string) error
+	Close() error
+}
+
+const (
+	BackendPgvector = "pgvector"
+	BackendChromem  = "chromem"
+)
+
+const defaultChromemPath = "./chromemdb"
+
+// collection names end up in file names and index names
+var collectionName = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+
+// ValidateCollectionName rejects names that are not usable by every backend
+func ValidateCollectionName(name string) error {
+	if !collectionName.MatchString(name) {
+		return fmt.Errorf("invalid collection name %q, use letters, digits, _ and -", name)
+	}
+	return nil
+}
+
+// DefaultCollection returns the configured collection, or the backend's
+// default which holds data ingested before collections existed
+func DefaultCollection(cfg *config.Config) string {
+	if cfg.VectorStore.Collection != "" {
+		return cfg.VectorStore.Collection
+	}
+	if cfg.VectorStore.Backend == BackendPgvector {
+		return db.DefaultCollection
+	}
+	return chromemdb.DefaultCollection
+}
+
+// OpenBackend opens the backend selected by the vector_store.backend setting
+func OpenBackend(ctx context.Context, cfg *config.Config) (Backend, error) {
+	switch cfg.VectorStore.Backend {
+	case BackendPgvector:
+		return openPgvector(ctx, cfg)
+	case BackendChromem, "":
+		return openChromem(cfg)
+	default:
+		return nil, fmt.Errorf("unsupported vector store backend: %s", cfg.VectorStore.Backend)
+	}
+}
+
+// Persist saves the snapshot of an in-memory chromem collection after a
+// change, which is lost when the process exits otherwise. Other stores
+// persist every write.
+func Persist(ctx context.Context, cfg *config.Config, vs VectorStore) error {
+	if exporter, ok := vs.(interface{ Export(context.Context) error }); ok && cfg.VectorStore.InMemory {
+		return exporter.Export(ctx)
+	}
+	return nil
+}
+
+// OpenCollection opens a named collection together with an embedder for the
+// model it was created with. embedCfg.Model only applies to new collections;
+// with reset the collection is dropped first and recreated for embedCfg.Model,
+// which is the way to move a collection to another embedding model.
+func OpenCollection(ctx context.Context, backend Backend, name string, embedCfg config.LLMConfig, reset bool) (VectorStore, *embeddings.EmbedderImpl, error) {
+	if err := ValidateCollectionName(name); err != nil {
+		return nil, nil, err
+	}
+
+	existing, err := backend.Describe(ctx, name)
+	if err != nil && !errors.Is(err, models.ErrCollectionNotFound) {
+		return nil, nil, err
+	}
+	if existing != nil && reset {
+		if err := backend.DropCollection(ctx, name); err != nil {
+			return nil, nil, fmt.Errorf("failed to reset collection %s: %w", name, err)
+		}
+		existing = nil
+	}
+	if existing != nil && existing.EmbeddingModel != "" && existing.EmbeddingModel != embedCfg.Model {
+		log.Debug().Msgf("Collection %s uses embedding model %s", name, existing.EmbeddingModel)
+		embedCfg.Model = existing.EmbeddingModel
+	}
+
+	embedder, err := embedding.NewOllamaEmbedder(&embedCfg)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to initialize embedder: %w", err)
+	}
+	dimension, err := embedding.ProbeDimension(ctx, embedder)
+	if err != nil {
+		return nil, nil, err
+	}
+	log.Debug().Msgf("Embedding model %s produces %d dimensions", embedCfg.Model, dimension)
+
+	vs, err := backend.Collection(ctx, name, embedCfg.Model, dimension)
+	if err != nil {
+		return nil, nil, err
+	}
+	return vs, embedder, nil
+}
+
+type pgBackend struct {
+	db       *bun.DB
+	indexCfg *config.VectorIndexConfig
+	loadCfg  *config.BulkLoadConfig
+}
+
+func openPgvector(ctx context.Context, cfg *config.Config) (Backend, error) {
+	dbClient, err := db.ConnectDB(ctx, &cfg.Database)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to database: %w", err)
+	}
+	dbInstance := db.NewDB(dbClient, cfg.Database.Debug)
+
+	if err := db.InitDB(ctx, dbInstance); err != nil {
+		dbInstance.Close()
+		return nil, fmt.Errorf("failed to initialize database: %w", err)
+	}
+	return &pgBackend{db: dbInstance, indexCfg: &cfg.Database.VectorIndex, loadCfg: &cfg.Database.BulkLoad}, nil
+}
+
+func (b *pgBackend) Collection(ctx context.Context, name, embeddingModel string, dimension int) (VectorStore, error) {
+	return db.OpenPgStore(ctx, b.db, name, embeddingModel, dimension, b.indexCfg, b.loadCfg)
+}
+
+func (b *pgBackend) Describe(ctx context.Context, name string) (*models.CollectionInfo, error) {
+	return db.GetCollection(ctx, b.db, name)
+}
+
+func (b *pgBackend) Collections(ctx context.Context) ([]models.CollectionInfo, error) {
+	return db.ListCollections(ctx, b.db)
+}
+
+func (b *pgBackend) DropCollection(ctx context.Context, name string) error {
+	return db.DropCollection(ctx, b.db, name)
+}
+
+func (b *pgBackend) Close() error {
+	return b.db.Close()
+}
+
+type chromemBackend struct {
+	*chromemdb.Backend
+}
+
+func openChromem(cfg *config.Config) (Backend, error) {
+	dbPath := cfg.VectorStore.Path
+	if dbPath == "" {
+		dbPath = defaultChromemPath
+	}
+	if !cfg.VectorStore.InMemory {
+		if err := helper.CreateFolder(dbPath); err != nil {
+			return nil, fmt.Errorf("failed to create folder: %w", err)
+		}
+	}
+
+	b, err := chromemdb.NewBackend(dbPath, cfg.VectorStore.InMemory, cfg.RAG.EncryptionKey)
+	if err != nil {
+		return nil, err
+	}
+	b.ConfigureSnapshots(cfg.VectorStore.Snapshot.Dir, cfg.VectorStore.Snapshot.Compress)
+
+	if cfg.VectorStore.InMemory && cfg.VectorStore.Snapshot.Restore {
+		restored, err := b.RestoreSnapshots()
+		if err != nil {
+			return nil, fmt.Errorf("failed to restore snapshots: %w", err)
+		}
+		for _, m := range restored {
+			log.Info().Msgf("Restored collection %s with %d chunks from snapshot", m.Collection, m.Documents)
+		}
+	}
+	return chromemBackend{b}, nil
+}
+
+func (b chromemBackend) Collection(ctx context.Context, name, embeddingModel string, dimension int) (VectorStore, error) {
+	return b.Backend.Collection(name, embeddingModel, dimension)
+}
+
+func (b chromemBackend) Describe(ctx context.Context, name string) (*models.CollectionInfo, error) {
+	return b.Backend.Describe(name)
+}
+
+func (b chromemBackend) Collections(ctx context.Context) ([]models.CollectionInfo, error) {
+	return b.Backend.Collections()
+}
+
+func (b chromemBackend) DropCollection(ctx context.Context, name string) error {
+	return b.Backend.DropCollection(name)
+}
+
+// Close is a no-op, chromem persists on every write
+func (b chromemBackend) Close() error {
+	return nil
+}
@@ -0,0 +1,83 @@
+// Package tokens estimates the token counts of texts for prompt budgets.
+//
+// The query models are served by different backends with different
+// tokenizers, so counts are estimated instead of tokenized: about four
+// characters per token for English prose, and at least one token per word
+// or punctuation mark, which keeps short words and code on the safe side.
+package tokens
+
+import (
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/tmc/langchaingo/llms"
+)
+
+const charsPerToken = 4
+
+// messageOverhead is the role and separator tokens of a chat message
+const messageOverhead = 4
+
+// Count estimates the tokens of text
+func Count(text string) int {
+	byChars := (utf8.RuneCountInString(text) + charsPerToken - 1) / charsPerToken
+	return max(byChars, pieces(text))
+}
+
+// CountMessages estimates the tokens of the text parts of chat messages
+func CountMessages(messages []llms.MessageContent) int {
+	n := 0
+	for _, m := range messages {
+		n += messageOverhead
+		for _, part := range m.Parts {
+			if text, ok := part.(llms.TextContent); ok {
+				n += Count(text.Text)
+			}
+		}
+	}
+	return n
+}
+
+// Truncate cuts text to at most limit tokens, at a word boundary
+func Truncate(text string, limit int) string {
+	if Count(text) <= limit {
+		return text
+	}
+	if limit <= 0 {
+		return ""
+	}
+	// binary search the most words that fit
+	words := strings.Fields(text)
+	lo, hi := 0, len(words)
+	for lo < hi {
+		mid := (lo + hi + 1) / 2
+		if Count(strings.Join(words[:mid], " ")) <= limit {
+			lo = mid
+		} else {
+			hi = mid - 1
+		}
+	}
+	return strings.Join(words[:lo], " ")
+}
+
+// pieces counts the words and punctuation marks of text
+func pieces(text string) int {
+	n := 0
+	inWord := false
+	for _, r := range text {
+		switch {
+		case unicode.IsLetter(r) || unicode.IsDigit(r):
+			if !inWord {
+				n++
+			}
+			inWord = true
+		case unicode.IsSpace(r):
+			inWord = false
+		default:
+			n++
+			inWord = false
+		}
+	}
+	return n
+}